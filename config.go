@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
@@ -9,10 +12,83 @@ import (
 )
 
 type Config struct {
-	Addr string
-	DB   server.DBConfig
-	Auth server.AuthConfig
-	Cors server.CorsConfig
+	Addr    string
+	DB      server.DBConfig
+	Auth    server.AuthConfig
+	Cors    server.CorsConfig
+	Queries map[string]server.QueryConfig
+	Timeout server.TimeoutConfig
+	Tables  server.TablesConfig
+	// HideErrorDetails hides raw database messages from error responses
+	HideErrorDetails bool `yaml:"hide_error_details"`
+	// Databases are served under their own prefixes in one process, the
+	// top level DB is ignored if there are any databases
+	Databases []DatabaseConfig
+}
+
+// DatabaseConfig is a named database mounted under Prefix, which is
+// `/<name>` by default
+type DatabaseConfig struct {
+	Name    string
+	Prefix  string
+	DB      server.DBConfig
+	Auth    server.AuthConfig
+	Queries map[string]server.QueryConfig
+	Timeout server.TimeoutConfig
+	Tables  server.TablesConfig
+}
+
+// databases returns all the databases to serve, the top level DB is served
+// under `/` if there is no databases defined
+func (c *Config) databases() ([]DatabaseConfig, error) {
+	if len(c.Databases) == 0 {
+		return []DatabaseConfig{{
+			DB:      c.DB,
+			Auth:    c.Auth,
+			Queries: c.Queries,
+			Timeout: c.Timeout,
+			Tables:  c.Tables,
+		}}, nil
+	}
+
+	databases := make([]DatabaseConfig, 0, len(c.Databases))
+	prefixes := map[string]bool{}
+	for _, database := range c.Databases {
+		if database.Name == "" {
+			return nil, errors.New("database name is required")
+		}
+		if database.Prefix == "" {
+			database.Prefix = "/" + database.Name
+		}
+		database.Prefix = strings.TrimRight("/"+strings.Trim(database.Prefix, "/"), "/")
+		if prefixes[database.Prefix] {
+			return nil, fmt.Errorf("duplicated database prefix: %s", database.Prefix)
+		}
+		prefixes[database.Prefix] = true
+		databases = append(databases, database)
+	}
+	return databases, nil
+}
+
+// database returns the database to run commands against, the only database
+// is used if name is empty
+func (c *Config) database(name string) (*DatabaseConfig, error) {
+	databases, err := c.databases()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(databases) > 1 {
+			return nil, errors.New("multiple databases are defined, please specify one by -db.name")
+		}
+		return &databases[0], nil
+	}
+	for i := range databases {
+		if databases[i].Name == name {
+			return &databases[i], nil
+		}
+	}
+	return nil, fmt.Errorf("database not found: %s", name)
 }
 
 func NewConfig(configPath string) (*Config, error) {
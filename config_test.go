@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rest-go/rest/pkg/server"
+)
+
+func TestConfigDatabases(t *testing.T) {
+	db := func(url string) server.DBConfig {
+		return server.DBConfig{URL: url}
+	}
+	tests := []struct {
+		name      string
+		config    Config
+		databases []DatabaseConfig
+		hasErr    bool
+	}{
+		{
+			name:      "single database",
+			config:    Config{DB: db("sqlite://my.db")},
+			databases: []DatabaseConfig{{DB: db("sqlite://my.db")}},
+		},
+		{
+			name: "multiple databases",
+			config: Config{
+				DB: db("sqlite://ignored.db"),
+				Databases: []DatabaseConfig{
+					{Name: "sales", Prefix: "/api/sales", DB: db("sqlite://sales.db")},
+					{Name: "inventory", Prefix: "/inventory", DB: db("sqlite://inventory.db")},
+				},
+			},
+			databases: []DatabaseConfig{
+				{Name: "sales", Prefix: "/api/sales", DB: db("sqlite://sales.db")},
+				{Name: "inventory", Prefix: "/inventory", DB: db("sqlite://inventory.db")},
+			},
+		},
+		{
+			name: "prefix defaults",
+			config: Config{
+				Databases: []DatabaseConfig{
+					{Name: "sales"},
+					{Name: "inventory", Prefix: "inventory/"},
+					{Name: "root", Prefix: "/"},
+				},
+			},
+			databases: []DatabaseConfig{
+				{Name: "sales", Prefix: "/sales"},
+				{Name: "inventory", Prefix: "/inventory"},
+				{Name: "root", Prefix: ""},
+			},
+		},
+		{
+			name: "duplicated prefixes",
+			config: Config{
+				Databases: []DatabaseConfig{
+					{Name: "sales"},
+					{Name: "other", Prefix: "/sales/"},
+				},
+			},
+			hasErr: true,
+		},
+		{
+			name:   "no name",
+			config: Config{Databases: []DatabaseConfig{{Prefix: "/sales"}}},
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			databases, err := tt.config.databases()
+			if tt.hasErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.databases, databases)
+		})
+	}
+}
@@ -6,13 +6,16 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/cors"
 
 	"github.com/rest-go/rest/pkg/auth"
 
+	j "github.com/rest-go/rest/pkg/jsonutil"
 	"github.com/rest-go/rest/pkg/log"
 	"github.com/rest-go/rest/pkg/server"
 	"github.com/rest-go/rest/pkg/sql"
@@ -20,10 +23,11 @@ import (
 
 const defaultAddr = ":3000"
 
-func parseConfig() *Config {
+func parseConfig() (*Config, string) {
 	addr := flag.String("addr", "", "listen addr")
 	url := flag.String("db.url", "", "database url")
 	cfgPath := flag.String("config", "", "path to config file")
+	dbName := flag.String("db.name", "", "database name in config to run auth commands against")
 	flag.Parse()
 
 	cfg := &Config{}
@@ -41,32 +45,35 @@ func parseConfig() *Config {
 		cfg.Addr = defaultAddr
 	}
 	if *url != "" {
+		if len(cfg.Databases) > 0 {
+			log.Fatal("-db.url can't be used with databases in config, set the url of each database instead")
+		}
 		cfg.DB.URL = *url
+	} else if cfg.DB.URL != "" && len(cfg.Databases) > 0 {
+		log.Warn("the top level db is ignored as databases are defined")
 	}
-	return cfg
+	return cfg, *dbName
 }
 
 func main() {
-	cfg := parseConfig()
+	cfg, dbName := parseConfig()
 	if flag.Arg(0) == "auth" {
-		authCmd(cfg.DB.URL)
+		database, err := cfg.database(dbName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authCmd(database.DB.URL)
 		return
 	}
 
-	restServer := server.New(&cfg.DB, server.EnableAuth(cfg.Auth.Enabled))
+	databases, err := cfg.databases()
+	if err != nil {
+		log.Fatal(err)
+	}
+	j.HideErrorDetails(cfg.HideErrorDetails)
 	mux := http.NewServeMux()
-	if cfg.Auth.Enabled {
-		log.Info("auth is enabled")
-		authHandler, err := auth.NewHandler(cfg.DB.URL, []byte(cfg.Auth.Secret))
-		if err != nil {
-			log.Fatal("initialize auth error ", err)
-		}
-		mux.Handle("/auth/", authHandler)
-
-		middleware := auth.NewMiddleware([]byte(cfg.Auth.Secret))
-		mux.Handle("/", middleware(restServer))
-	} else {
-		mux.Handle("/", restServer)
+	for i := range databases {
+		mount(mux, &databases[i])
 	}
 
 	var handler http.Handler = mux
@@ -87,6 +94,11 @@ func main() {
 				// Delete
 				http.MethodDelete,
 			},
+			AllowedHeaders: []string{
+				"Accept", "Content-Type", "X-Requested-With", auth.AuthorizationHeader, auth.APIKeyHeader,
+				server.PreferHeader, server.RangeHeader, server.RangeUnitHeader,
+			},
+			ExposedHeaders: []string{server.ContentRangeHeader, server.LinkHeader},
 		})
 		handler = c.Handler(handler)
 	}
@@ -100,9 +112,80 @@ func main() {
 	log.Fatal(s.ListenAndServe())
 }
 
+// mount serves a database under its prefix, the auth endpoints are served
+// under `<prefix>/auth/` if auth is enabled
+func mount(mux *http.ServeMux, database *DatabaseConfig) {
+	log.Infof("serve database %q under %q", database.Name, database.Prefix+"/")
+	restServer := server.New(&database.DB,
+		server.Prefix(database.Prefix),
+		server.EnableAuth(database.Auth.Enabled),
+		server.RowLevelSecurity(database.Auth.RLS),
+		server.Queries(database.Queries),
+		server.Timeout(database.Timeout),
+		server.Tables(database.Tables),
+	)
+	if !database.Auth.Enabled {
+		mux.Handle(database.Prefix+"/", restServer)
+		return
+	}
+
+	log.Infof("auth is enabled for database %q", database.Name)
+	options := []auth.HandlerOption{
+		auth.AccessTokenTTL(database.Auth.AccessTokenTTL),
+		auth.RefreshTokenTTL(database.Auth.RefreshTokenTTL),
+		auth.OAuthProviders(database.Auth.OAuth...),
+		auth.PasswordResetTTL(database.Auth.PasswordResetTTL),
+		auth.VerificationTTL(database.Auth.VerificationTTL),
+		auth.RequireVerification(database.Auth.RequireVerification),
+		auth.Issuer(database.Auth.Issuer),
+		auth.Audience(database.Auth.Audience),
+	}
+	if database.Auth.SMTP.Addr != "" {
+		notifier := database.Auth.SMTP
+		options = append(options, auth.WithNotifier(&notifier))
+	}
+	if database.Auth.PrivateKey != "" {
+		pemData, err := os.ReadFile(database.Auth.PrivateKey)
+		if err != nil {
+			log.Fatal("read private key error ", err)
+		}
+		signer, err := auth.NewSigner(pemData, database.Auth.KeyID)
+		if err != nil {
+			log.Fatal("initialize signer error ", err)
+		}
+		options = append(options, auth.WithSigner(signer))
+	}
+	authHandler, err := auth.NewHandler(database.DB.URL, []byte(database.Auth.Secret), options...)
+	if err != nil {
+		log.Fatal("initialize auth error ", err)
+	}
+	keySet := authHandler.KeySet()
+	for _, source := range database.Auth.JWKS {
+		if source.Audience == "" {
+			source.Audience = database.Auth.Audience
+		}
+		if err := keySet.AddJWKS(source); err != nil {
+			log.Fatal("load JWKS error ", err)
+		}
+	}
+	if len(database.Auth.JWKS) > 0 {
+		keySet.Watch(context.Background())
+	}
+	mux.Handle(database.Prefix+"/auth/", http.StripPrefix(database.Prefix, authHandler))
+	mux.Handle(database.Prefix+auth.JWKSPath, http.StripPrefix(database.Prefix, authHandler))
+
+	middleware := auth.NewMiddleware([]byte(database.Auth.Secret),
+		auth.WithDenylist(authHandler.Denylist()),
+		auth.WithKeySet(keySet),
+		auth.WithAPIKeys(authHandler.APIKeys()),
+		auth.WithIdentities(authHandler.Identities()),
+	)
+	mux.Handle(database.Prefix+"/", middleware(restServer))
+}
+
 func authCmd(url string) {
 	if len(flag.Args()) == 1 {
-		log.Fatal("rest auth setup/user/policy")
+		log.Fatal("rest auth setup/user/policy/role/apikey")
 	}
 
 	db, err := sql.Open(url)
@@ -124,27 +207,39 @@ func authCmd(url string) {
 		if err := policyCmd(db, flag.Args()[2:]); err != nil {
 			log.Fatal(err)
 		}
+	case "role":
+		if err := roleCmd(db, flag.Args()[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "apikey":
+		if err := apikeyCmd(db, flag.Args()[2:]); err != nil {
+			log.Fatal(err)
+		}
 	default:
-		log.Fatal("rest auth setup/user/policy")
+		log.Fatal("rest auth setup/user/policy/role/apikey")
 	}
 }
 
 func userCmd(db *sql.DB, args []string) error {
 	if len(args) == 0 {
-		return errors.New("rest auth user list/add")
+		return errors.New("rest auth user list/add/passwd/verify")
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
 
 	switch args[0] {
 	case "list":
-		objects, err := db.FetchData(ctx, "SELECT id, username, is_admin FROM auth_users")
+		objects, err := db.FetchData(ctx, "SELECT id, username, is_admin, email, verified_at FROM auth_users")
 		if err != nil {
 			return err
 		}
-		fmt.Println("id | username | is_admin ")
+		fmt.Println("id | username | is_admin | email | verified ")
 		for _, object := range objects {
-			fmt.Printf("%d | %s | %t\n", object["id"], object["username"], object["is_admin"])
+			// NULL email and verified_at are nil
+			email, _ := object["email"].(string)
+			verifiedAt, ok := object["verified_at"].(int64)
+			fmt.Printf("%d | %s | %t | %s | %t\n", object["id"], object["username"], object["is_admin"],
+				email, ok && verifiedAt != 0)
 		}
 	case "add":
 		if len(args) < 4 { //nolint:gomnd
@@ -164,13 +259,50 @@ func userCmd(db *sql.DB, args []string) error {
 			return err
 		}
 		fmt.Println("use is added into database")
+	case "passwd":
+		if len(args) < 3 { //nolint:gomnd
+			return errors.New("rest auth user passwd <username> <password>")
+		}
+		username, password := args[1], args[2]
+		hashedPasswd, err := auth.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		rows, err := db.ExecQuery(ctx, "UPDATE auth_users SET password = ? WHERE username = ?", hashedPasswd, username)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return fmt.Errorf("user %s not found", username)
+		}
+		// sessions with the old password are revoked
+		_, err = db.ExecQuery(ctx,
+			"UPDATE auth_sessions SET revoked_at = ? WHERE revoked_at IS NULL AND user_id = (SELECT id FROM auth_users WHERE username = ?)",
+			time.Now().Unix(), username,
+		)
+		if err != nil {
+			return err
+		}
+		fmt.Println("password is changed")
+	case "verify":
+		if len(args) < 2 { //nolint:gomnd
+			return errors.New("rest auth user verify <username>")
+		}
+		rows, err := db.ExecQuery(ctx, "UPDATE auth_users SET verified_at = ? WHERE username = ?", time.Now().Unix(), args[1])
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return fmt.Errorf("user %s not found", args[1])
+		}
+		fmt.Println("user is verified")
 	}
 	return nil
 }
 
 func policyCmd(db *sql.DB, args []string) error {
 	if len(args) == 0 {
-		return errors.New("rest auth policy list/add")
+		return errors.New("rest auth policy list/add/add-columns")
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
@@ -188,10 +320,24 @@ func policyCmd(db *sql.DB, args []string) error {
 				object["expression"], object["description"],
 			)
 		}
+		objects, err = db.FetchData(ctx, "SELECT * FROM auth_column_policies")
+		if err != nil {
+			return err
+		}
+		fmt.Println("\ntable_name | action | columns | expression | description ")
+		for _, object := range objects {
+			fmt.Printf("%s | %s | %s | %s | %s\n",
+				object["table_name"], object["action"], object["columns"],
+				object["expression"], object["description"],
+			)
+		}
 	case "add":
 		if len(args) < 5 { //nolint:gomnd
 			return errors.New("rest auth policy add <table_name> <action> <expression> <description>")
 		}
+		if err := auth.ValidateExpression(args[3]); err != nil {
+			return err
+		}
 		_, err := db.ExecQuery(ctx,
 			"INSERT INTO auth_policies (table_name, action, expression, description) VALUES (?,?,?,?)",
 			args[1], args[2], args[3], args[4],
@@ -200,6 +346,138 @@ func policyCmd(db *sql.DB, args []string) error {
 			return err
 		}
 		fmt.Println("policy is added into database")
+	case "add-columns":
+		if len(args) < 6 { //nolint:gomnd
+			return errors.New("rest auth policy add-columns <table_name> <action> <columns> <expression> <description>")
+		}
+		err := auth.AddColumnPolicy(ctx, db, auth.ColumnPolicy{
+			TableName:   args[1],
+			Action:      args[2],
+			Columns:     args[3],
+			Expression:  args[4],
+			Description: args[5],
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("column policy is added into database")
+	}
+	return nil
+}
+
+func roleCmd(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("rest auth role list/add/grant/revoke")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+
+	switch args[0] {
+	case "list":
+		objects, err := db.FetchData(ctx, `
+			SELECT r.name, r.description, u.username FROM auth_roles r
+			LEFT JOIN auth_user_roles ur ON ur.role_id = r.id
+			LEFT JOIN auth_users u ON u.id = ur.user_id ORDER BY r.name, u.username`)
+		if err != nil {
+			return err
+		}
+		fmt.Println("name | description | username")
+		for _, object := range objects {
+			fmt.Printf("%s | %s | %v\n", object["name"], object["description"], object["username"])
+		}
+	case "add":
+		if len(args) < 2 { //nolint:gomnd
+			return errors.New("rest auth role add <name> [description]")
+		}
+		description := ""
+		if len(args) > 2 { //nolint:gomnd
+			description = args[2]
+		}
+		_, err := db.ExecQuery(ctx, "INSERT INTO auth_roles (name, description) VALUES (?,?)", args[1], description)
+		if err != nil {
+			return err
+		}
+		fmt.Println("role is added into database")
+	case "grant", "revoke":
+		if len(args) < 3 { //nolint:gomnd
+			return fmt.Errorf("rest auth role %s <username> <role>", args[0])
+		}
+		query := `
+			INSERT INTO auth_user_roles (user_id, role_id)
+			SELECT u.id, r.id FROM auth_users u, auth_roles r WHERE u.username = ? AND r.name = ?`
+		if args[0] == "revoke" {
+			query = `
+				DELETE FROM auth_user_roles
+				WHERE user_id = (SELECT id FROM auth_users WHERE username = ?)
+				AND role_id = (SELECT id FROM auth_roles WHERE name = ?)`
+		}
+		rows, err := db.ExecQuery(ctx, query, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errors.New("user or role not found")
+		}
+		fmt.Println("role is updated, it takes effect on the next login or refresh")
+	}
+	return nil
+}
+
+func apikeyCmd(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("rest auth apikey create/list/revoke")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+
+	switch args[0] {
+	case "create":
+		if len(args) < 3 { //nolint:gomnd
+			return errors.New("rest auth apikey create <username> <name> [scopes, e.g. todos:read,*:execute] [ttl, e.g. 720h]")
+		}
+		var scopes []string
+		if len(args) > 3 && args[3] != "" { //nolint:gomnd
+			scopes = strings.Split(args[3], ",")
+		}
+		var ttl time.Duration
+		if len(args) > 4 { //nolint:gomnd
+			var err error
+			if ttl, err = time.ParseDuration(args[4]); err != nil {
+				return err
+			}
+		}
+		key, err := auth.CreateAPIKey(ctx, db, args[1], args[2], scopes, ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Println("api key is created, it won't be shown again:")
+		fmt.Println(key)
+	case "list":
+		keys, err := auth.ListAPIKeys(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Println("id | name | prefix | username | scopes | expires_at | revoked")
+		for _, key := range keys {
+			expiresAt := "never"
+			if key.ExpiresAt != 0 {
+				expiresAt = time.Unix(key.ExpiresAt, 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%d | %s | %s | %s | %s | %s | %t\n",
+				key.ID, key.Name, key.Prefix, key.Username, strings.Join(key.Scopes, ","), expiresAt, key.RevokedAt != 0)
+		}
+	case "revoke":
+		if len(args) < 2 { //nolint:gomnd
+			return errors.New("rest auth apikey revoke <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := auth.RevokeAPIKey(ctx, db, id); err != nil {
+			return err
+		}
+		fmt.Println("api key is revoked")
 	}
 	return nil
 }
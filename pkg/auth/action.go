@@ -8,6 +8,7 @@ const (
 	ActionUpdate
 	ActionDelete
 	ActionReadMine // read with ?mine query, usually filter by user_id field
+	ActionExecute  // execute a stored function or procedure
 )
 
 var actionToStr = map[Action]string{
@@ -16,6 +17,7 @@ var actionToStr = map[Action]string{
 	ActionUpdate:   "update",
 	ActionDelete:   "delete",
 	ActionReadMine: "read_mine",
+	ActionExecute:  "execute",
 }
 
 func (a Action) String() string {
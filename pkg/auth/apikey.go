@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the API keys table
+	APIKeyTableName = "auth_api_keys"
+	// APIKeyHeader is the header to send an API key besides
+	// `Authorization: ApiKey <key>`
+	APIKeyHeader = "X-API-Key"
+
+	// apiKeyPrefix is the prefix of keys to be recognized in secret scanning
+	apiKeyPrefix = "rest_"
+	// apiKeyCacheTTL is how long a validated key is cached, revoked keys are
+	// denied after it in the worst case
+	apiKeyCacheTTL = denylistRefreshInterval
+	// maxAPIKeyCacheSize limits the cached keys, stale ones are evicted when
+	// it's exceeded
+	maxAPIKeyCacheSize = 1024
+
+	// times are unix seconds to be portable across databases, 0 expires_at
+	// means never expires
+	createAPIKeyTable = `
+	CREATE TABLE auth_api_keys (
+		id %s,
+		name VARCHAR(64) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) UNIQUE NOT NULL,
+		user_id BIGINT NOT NULL,
+		scopes VARCHAR(1024) NOT NULL,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		revoked_at BIGINT
+	)
+	`
+	createAPIKey = `
+		INSERT INTO auth_api_keys (name, prefix, key_hash, user_id, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	queryAPIKey = `
+		SELECT k.user_id, k.scopes, k.expires_at, u.username, u.is_admin
+		FROM auth_api_keys k JOIN auth_users u ON u.id = k.user_id
+		WHERE k.key_hash = ? AND k.revoked_at IS NULL AND (k.expires_at = 0 OR k.expires_at > ?)
+	`
+	queryAPIKeys = `
+		SELECT k.id, k.name, k.prefix, k.scopes, k.created_at, k.expires_at, k.revoked_at, u.username
+		FROM auth_api_keys k JOIN auth_users u ON u.id = k.user_id ORDER BY k.id
+	`
+	revokeAPIKey  = `UPDATE auth_api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	apiKeysPolicy = "api keys are limited to admin user(to deny user to read others' keys)"
+)
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+// APIKey is an API key without its secret
+type APIKey struct {
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Prefix   string   `json:"prefix"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes"`
+	// CreatedAt, ExpiresAt and RevokedAt are unix seconds, zero ExpiresAt
+	// means never expires and zero RevokedAt means not revoked
+	CreatedAt int64 `json:"created_at"`
+	ExpiresAt int64 `json:"expires_at"`
+	RevokedAt int64 `json:"revoked_at"`
+}
+
+// parseScopes validates scopes in the form of `<table>:<action>`, `*` matches
+// all tables or actions, e.g. `todos:read`, `*:read`, `todos:*`
+func parseScopes(scopes []string) error {
+	for _, scope := range scopes {
+		table, action, ok := strings.Cut(scope, ":")
+		if !ok || table == "" {
+			return fmt.Errorf("invalid scope %q, it should be <table>:<action>", scope)
+		}
+		valid := action == "*"
+		for _, a := range actionToStr {
+			valid = valid || action == a
+		}
+		if !valid {
+			return fmt.Errorf("invalid action of scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey creates an API key acting as the user within the scopes, it
+// returns the key which is only stored hashed. Empty scopes allow everything
+// the user is allowed to, zero ttl means the key never expires
+func CreateAPIKey(ctx context.Context, db *sql.DB, username, name string, scopes []string, ttl time.Duration) (string, error) {
+	if err := parseScopes(scopes); err != nil {
+		return "", err
+	}
+	// create the table for databases set up before api keys
+	if err := setupAPIKeys(db); err != nil {
+		return "", err
+	}
+	row, err := db.FetchOne(ctx, queryUser, username)
+	if err != nil {
+		return "", fmt.Errorf("fetch user %s error: %w", username, err)
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	key := apiKeyPrefix + secret
+	now := time.Now()
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = now.Add(ttl).Unix()
+	}
+	_, err = db.ExecQuery(ctx, createAPIKey,
+		name, key[:len(apiKeyPrefix)+6], hashToken(key), row["id"], strings.Join(scopes, ","), now.Unix(), expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns all the API keys
+func ListAPIKeys(ctx context.Context, db *sql.DB) ([]APIKey, error) {
+	rows, err := db.FetchData(ctx, queryAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		key := APIKey{
+			ID:        row["id"].(int64),
+			Name:      row["name"].(string),
+			Prefix:    row["prefix"].(string),
+			Username:  row["username"].(string),
+			Scopes:    splitScopes(row["scopes"].(string)),
+			CreatedAt: row["created_at"].(int64),
+			ExpiresAt: row["expires_at"].(int64),
+		}
+		key.RevokedAt, _ = row["revoked_at"].(int64)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key, it's denied by middlewares after their
+// caches expire
+func RevokeAPIKey(ctx context.Context, db *sql.DB, id int64) error {
+	rows, err := db.ExecQuery(ctx, revokeAPIKey, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("api key %d not found or revoked already", id)
+	}
+	return nil
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return nil
+	}
+	return strings.Split(scopes, ",")
+}
+
+// setupAPIKeys creates `auth_api_keys` table and the policy limiting it to
+// admin user, it's skipped if the table exists
+func setupAPIKeys(db *sql.DB) error {
+	if tableExists(db, APIKeyTableName) {
+		return nil
+	}
+	log.Info("create api keys table")
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	_, dbErr := db.ExecQuery(ctx, fmt.Sprintf(createAPIKeyTable, db.Dialect().PrimaryKey()))
+	if dbErr != nil {
+		return dbErr
+	}
+	_, dbErr = db.ExecQuery(ctx, createInternalPolicy, apiKeysPolicy, APIKeyTableName, "all", "auth_user.is_admin")
+	return dbErr
+}
+
+// APIKeys authenticates API keys, validated keys are cached for a while to
+// avoid querying the database on every request
+type APIKeys struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	cache map[string]*apiKeyEntry // key hash => entry
+}
+
+type apiKeyEntry struct {
+	user      *User
+	expiresAt int64
+	checkedAt time.Time
+}
+
+func newAPIKeys(db *sql.DB) *APIKeys {
+	return &APIKeys{db: db, cache: map[string]*apiKeyEntry{}}
+}
+
+// Authenticate returns the user of the API key, the user is limited to the
+// scopes of the key and only unscoped keys of admin users act as admin
+func (k *APIKeys) Authenticate(ctx context.Context, key string) (*User, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, errInvalidAPIKey
+	}
+	hashed := hashToken(key)
+	now := time.Now()
+	k.mu.Lock()
+	entry, ok := k.cache[hashed]
+	k.mu.Unlock()
+	if ok && now.Sub(entry.checkedAt) < apiKeyCacheTTL && (entry.expiresAt == 0 || entry.expiresAt > now.Unix()) {
+		return entry.user, nil
+	}
+
+	row, err := k.db.FetchOne(ctx, queryAPIKey, hashed, now.Unix())
+	if err != nil {
+		k.mu.Lock()
+		delete(k.cache, hashed)
+		k.mu.Unlock()
+		var dbErr sql.Error
+		if errors.As(err, &dbErr) && dbErr.Code == http.StatusNotFound {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+	user := &User{
+		ID:       row["user_id"].(int64),
+		Username: row["username"].(string),
+		Scopes:   splitScopes(row["scopes"].(string)),
+	}
+	user.IsAdmin = row["is_admin"].(bool) && len(user.Scopes) == 0
+	if user.Roles, err = fetchRoles(ctx, k.db, user.ID); err != nil {
+		return nil, err
+	}
+	k.add(hashed, &apiKeyEntry{user: user, expiresAt: row["expires_at"].(int64), checkedAt: now})
+	return user, nil
+}
+
+func (k *APIKeys) add(hashed string, entry *apiKeyEntry) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.cache) >= maxAPIKeyCacheSize {
+		for h, e := range k.cache {
+			if time.Since(e.checkedAt) >= apiKeyCacheTTL {
+				delete(k.cache, h)
+			}
+		}
+	}
+	if len(k.cache) < maxAPIKeyCacheSize {
+		k.cache[hashed] = entry
+	}
+}
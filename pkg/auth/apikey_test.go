@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey(t *testing.T) {
+	ctx := context.Background()
+	authRequest(t, "register", "", `{"username": "apikey", "password": "world"}`)
+
+	t.Run("invalid scopes", func(t *testing.T) {
+		for _, scopes := range [][]string{{"todos"}, {":read"}, {"todos:write"}} {
+			_, err := CreateAPIKey(ctx, testHandler.db, "apikey", "invalid", scopes, 0)
+			assert.NotNil(t, err, scopes)
+		}
+		_, err := CreateAPIKey(ctx, testHandler.db, "not-exist", "invalid", nil, 0)
+		assert.NotNil(t, err)
+	})
+
+	key, err := CreateAPIKey(ctx, testHandler.db, "apikey", "ci", []string{"todos:read", "*:execute"}, time.Hour)
+	assert.Nil(t, err)
+	expired, err := CreateAPIKey(ctx, testHandler.db, "apikey", "expired", nil, time.Hour)
+	assert.Nil(t, err)
+	_, err = testHandler.db.ExecQuery(ctx, "UPDATE auth_api_keys SET expires_at = ? WHERE name = ?",
+		time.Now().Add(-time.Minute).Unix(), "expired")
+	assert.Nil(t, err)
+	adminKey, err := CreateAPIKey(ctx, testHandler.db, adminUsername, "admin", nil, 0)
+	assert.Nil(t, err)
+	scopedAdminKey, err := CreateAPIKey(ctx, testHandler.db, adminUsername, "scoped admin", []string{"*:read"}, 0)
+	assert.Nil(t, err)
+
+	keys, err := ListAPIKeys(ctx, testHandler.db)
+	assert.Nil(t, err)
+	var ci APIKey
+	for _, k := range keys {
+		if k.Name == "ci" {
+			ci = k
+		}
+	}
+	assert.Equal(t, "apikey", ci.Username)
+	assert.Equal(t, []string{"todos:read", "*:execute"}, ci.Scopes)
+	assert.Equal(t, key[:len(ci.Prefix)], ci.Prefix)
+	assert.NotZero(t, ci.ExpiresAt)
+	assert.Zero(t, ci.RevokedAt)
+
+	handler := NewMiddleware([]byte(testSecret), WithAPIKeys(newAPIKeys(testHandler.db)))(http.HandlerFunc(testHandle))
+	request := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, request(AuthorizationHeader, "ApiKey "+key))
+	assert.Equal(t, http.StatusOK, request(APIKeyHeader, key))
+	assert.Equal(t, http.StatusUnauthorized, request(APIKeyHeader, key+"x"))
+	assert.Equal(t, http.StatusUnauthorized, request(APIKeyHeader, "not-a-key"))
+	assert.Equal(t, http.StatusUnauthorized, request(APIKeyHeader, expired))
+
+	t.Run("admin", func(t *testing.T) {
+		apiKeys := newAPIKeys(testHandler.db)
+		user, err := apiKeys.Authenticate(ctx, adminKey)
+		assert.Nil(t, err)
+		assert.True(t, user.IsAdmin)
+		user, err = apiKeys.Authenticate(ctx, scopedAdminKey)
+		assert.Nil(t, err)
+		assert.False(t, user.IsAdmin, "scoped keys don't act as admin")
+		assert.Equal(t, []string{"*:read"}, user.Scopes)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		apiKeys := newAPIKeys(testHandler.db)
+		_, err := apiKeys.Authenticate(ctx, key)
+		assert.Nil(t, err)
+
+		assert.Nil(t, RevokeAPIKey(ctx, testHandler.db, ci.ID))
+		assert.NotNil(t, RevokeAPIKey(ctx, testHandler.db, ci.ID))
+		// cached until the cache expires
+		_, err = apiKeys.Authenticate(ctx, key)
+		assert.Nil(t, err)
+		apiKeys.cache[hashToken(key)].checkedAt = time.Now().Add(-apiKeyCacheTTL)
+		_, err = apiKeys.Authenticate(ctx, key)
+		assert.ErrorIs(t, err, errInvalidAPIKey)
+	})
+}
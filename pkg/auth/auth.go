@@ -10,12 +10,6 @@ import (
 	"github.com/rest-go/rest/pkg/sql"
 )
 
-var primaryKeySQL = map[string]string{
-	"postgres": "BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY",
-	"mysql":    "BIGINT PRIMARY KEY AUTO_INCREMENT",
-	"sqlite":   "INTEGER PRIMARY KEY",
-}
-
 // GenJWTToken generate and return jwt token
 func GenJWTToken(secret []byte, data map[string]any) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(data))
@@ -53,6 +47,34 @@ func Setup(db *sql.DB) (username, password string, err error) {
 		return
 	}
 	err = setupPolicies(db)
+	if err != nil {
+		return
+	}
+	err = setupSessions(db)
+	if err != nil {
+		return
+	}
+	err = setupIdentities(db)
+	if err != nil {
+		return
+	}
+	err = setupAPIKeys(db)
+	if err != nil {
+		return
+	}
+	err = setupRoles(db)
+	if err != nil {
+		return
+	}
+	err = setupColumnPolicies(db)
+	if err != nil {
+		return
+	}
+	err = setupPasswordResets(db)
+	if err != nil {
+		return
+	}
+	err = setupProfiles(db)
 	return
 }
 
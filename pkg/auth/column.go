@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// the name of the column policies table
+	ColumnPolicyTableName = "auth_column_policies"
+
+	createColumnPolicyTable = `
+	CREATE TABLE auth_column_policies (
+		id %s,
+		description VARCHAR(256) NOT NULL,
+		table_name VARCHAR(128) NOT NULL,
+		action VARCHAR(16) NOT NULL,
+		columns VARCHAR(1024) NOT NULL,
+		expression VARCHAR(256) NOT NULL
+	)
+	`
+	createColumnPolicy = `
+		INSERT INTO auth_column_policies (description, table_name, action, columns, expression)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	columnPoliciesPolicy = "column policies are limited to admin user"
+)
+
+// ColumnPolicy limits the columns of a table the users can read or write,
+// it applies to the users meeting the expression, e.g. `NOT auth_user.is_admin`,
+// an empty expression applies to all users
+type ColumnPolicy struct {
+	ID          int64  `json:"id"`
+	Description string `json:"description"`
+	TableName   string `json:"table_name"`
+	// Action is read, create, update or all
+	Action string `json:"action"`
+	// Columns are the allowed columns separated by comma
+	Columns    string `json:"columns"`
+	Expression string `json:"expression"`
+}
+
+// validate validates the action, columns and the expression of the policy,
+// the expression can only use values of the user
+func (p *ColumnPolicy) validate() error {
+	if p.Action != "all" && p.Action != ActionRead.String() &&
+		p.Action != ActionCreate.String() && p.Action != ActionUpdate.String() {
+		return fmt.Errorf("invalid action %q of column policy, it should be read/create/update/all", p.Action)
+	}
+	for _, column := range strings.Split(p.Columns, ",") {
+		if column != "" && !identRe.MatchString(column) {
+			return fmt.Errorf("invalid column %q of column policy", column)
+		}
+	}
+	n, err := parseExpression(p.Expression)
+	if err != nil {
+		return err
+	}
+	if hasColumn(n) {
+		return errors.New("columns are not allowed in the expression of column policy")
+	}
+	return nil
+}
+
+// applies returns whether the policy applies to the user performing action
+// on the table, invalid expressions apply to all users
+func (p *ColumnPolicy) applies(u *User, table string, action Action) bool {
+	if action == ActionReadMine {
+		action = ActionRead
+	}
+	if p.TableName != table || (p.Action != "all" && p.Action != action.String()) {
+		return false
+	}
+	if err := p.validate(); err != nil {
+		log.Errorf("invalid column policy exp: %s, %v", p.Expression, err)
+		return true
+	}
+	_, ok, err := newCondition(p.Expression, u)
+	return ok && err == nil
+}
+
+// hasColumn returns whether the expression references any column
+func hasColumn(n node) bool {
+	switch n := n.(type) {
+	case *columnNode:
+		return true
+	case *logicNode:
+		return hasColumn(n.left) || hasColumn(n.right)
+	case *cmpNode:
+		return hasColumn(n.left) || hasColumn(n.right)
+	case *inNode:
+		for _, item := range n.list {
+			if hasColumn(item) {
+				return true
+			}
+		}
+		return hasColumn(n.x)
+	case *notNode:
+		return hasColumn(n.x)
+	case *isNullNode:
+		return hasColumn(n.x)
+	case *truthNode:
+		return hasColumn(n.x)
+	}
+	return false
+}
+
+// Columns returns the columns the user is allowed to read or write by the
+// column policies, limited is false if no policy applies to the user, the
+// allowed columns of multiple policies are merged, e.g. a role can be allowed
+// to write more columns
+func (u *User) Columns(table string, action Action, policies []ColumnPolicy) (columns []string, limited bool) {
+	seen := map[string]bool{}
+	for i := range policies {
+		p := &policies[i]
+		if !p.applies(u, table, action) {
+			continue
+		}
+		limited = true
+		for _, column := range strings.Split(p.Columns, ",") {
+			if column != "" && !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns, limited
+}
+
+// AddColumnPolicy validates and adds a column policy
+func AddColumnPolicy(ctx context.Context, db *sql.DB, policy ColumnPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	// create the table for databases set up before column policies
+	if err := setupColumnPolicies(db); err != nil {
+		return err
+	}
+	_, err := db.ExecQuery(ctx, createColumnPolicy,
+		policy.Description, policy.TableName, policy.Action, policy.Columns, policy.Expression)
+	return err
+}
+
+// setupColumnPolicies creates `auth_column_policies` table and the policy
+// limiting it to admin user, it's skipped if the table exists
+func setupColumnPolicies(db *sql.DB) error {
+	if tableExists(db, ColumnPolicyTableName) {
+		return nil
+	}
+	log.Info("create column policies table")
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	_, dbErr := db.ExecQuery(ctx, fmt.Sprintf(createColumnPolicyTable, db.Dialect().PrimaryKey()))
+	if dbErr != nil {
+		return dbErr
+	}
+	_, dbErr = db.ExecQuery(ctx, createInternalPolicy, columnPoliciesPolicy, ColumnPolicyTableName, "all", "auth_user.is_admin")
+	return dbErr
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var columnPolicies = []ColumnPolicy{
+	{TableName: "profiles", Action: "all", Columns: "id,name,bio", Expression: "NOT auth_user.is_admin"},
+	{TableName: "profiles", Action: "read", Columns: "is_verified", Expression: "NOT auth_user.is_admin"},
+	{TableName: "profiles", Action: "update", Columns: "credit_limit", Expression: "auth_user.has_role('finance')"},
+	{TableName: "orders", Action: "read", Columns: "id", Expression: "invalid policy"},
+}
+
+func TestUser_Columns(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		user    User
+		table   string
+		action  Action
+		columns []string
+		limited bool
+	}{
+		{
+			name:    "users read the allowed columns",
+			user:    User{ID: 1},
+			table:   "profiles",
+			action:  ActionRead,
+			columns: []string{"id", "name", "bio", "is_verified"},
+			limited: true,
+		},
+		{
+			name:    "read mine is read",
+			user:    User{ID: 1},
+			table:   "profiles",
+			action:  ActionReadMine,
+			columns: []string{"id", "name", "bio", "is_verified"},
+			limited: true,
+		},
+		{
+			name:    "users update the allowed columns",
+			user:    User{ID: 1},
+			table:   "profiles",
+			action:  ActionUpdate,
+			columns: []string{"id", "name", "bio"},
+			limited: true,
+		},
+		{
+			name:    "roles are allowed to update more columns",
+			user:    User{ID: 1, Roles: []string{"finance"}},
+			table:   "profiles",
+			action:  ActionUpdate,
+			columns: []string{"id", "name", "bio", "credit_limit"},
+			limited: true,
+		},
+		{
+			name:    "admin users are not limited",
+			user:    User{ID: 1, IsAdmin: true},
+			table:   "profiles",
+			action:  ActionUpdate,
+			limited: false,
+		},
+		{
+			name:    "other tables are not limited",
+			user:    User{ID: 1},
+			table:   "todos",
+			action:  ActionRead,
+			limited: false,
+		},
+		{
+			name:    "invalid policy applies to all users",
+			user:    User{ID: 1, IsAdmin: true},
+			table:   "orders",
+			action:  ActionRead,
+			columns: []string{"id"},
+			limited: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			columns, limited := test.user.Columns(test.table, test.action, columnPolicies)
+			assert.Equal(t, test.columns, columns)
+			assert.Equal(t, test.limited, limited)
+		})
+	}
+}
+
+func TestAddColumnPolicy(t *testing.T) {
+	ctx := context.Background()
+	policy := ColumnPolicy{
+		Description: "users edit their profile name",
+		TableName:   "profiles",
+		Action:      "update",
+		Columns:     "name",
+		Expression:  "NOT auth_user.is_admin",
+	}
+	assert.Nil(t, AddColumnPolicy(ctx, testHandler.db, policy))
+	row, err := testHandler.db.FetchOne(ctx,
+		"SELECT columns, expression FROM auth_column_policies WHERE table_name = ?", "profiles")
+	assert.Nil(t, err)
+	assert.Equal(t, "name", row["columns"])
+
+	for _, p := range []ColumnPolicy{
+		{TableName: "profiles", Action: "delete", Columns: "name"},
+		{TableName: "profiles", Action: "read", Columns: "name;"},
+		{TableName: "profiles", Action: "read", Columns: "name", Expression: "owner_id = auth_user.id"},
+	} {
+		assert.NotNil(t, AddColumnPolicy(ctx, testHandler.db, p), p)
+	}
+}
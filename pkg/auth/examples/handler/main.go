@@ -23,7 +23,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	middleware := auth.NewMiddleware([]byte(jwtSecret))
+	middleware := auth.NewMiddleware([]byte(jwtSecret), auth.WithDenylist(authHandler.Denylist()))
 
 	http.Handle("/auth/", authHandler)
 	http.Handle("/", middleware(http.HandlerFunc(handle)))
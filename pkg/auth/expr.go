@@ -0,0 +1,861 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Policy expressions are a small SQL-like language, e.g.
+//
+//	org_id = auth_user.claims.org_id AND (public OR owner_id = auth_user.id)
+//
+// Operands are columns, string/number/boolean constants, NULL and values of
+// the request user:
+//
+//	auth_user.id, auth_user.username, auth_user.is_admin,
+//	auth_user.is_authenticated, auth_user.roles, auth_user.claims.<name>
+//
+// Operators are `= != <> < <= > >=`, `[NOT] IN (a, b)`, `IN <list>` for
+// lists like auth_user.roles, `IS [NOT] NULL`, `AND`, `OR`, `NOT` and
+// `auth_user.has_role('<role>')`, a bare operand is a boolean test.
+//
+// Expressions are evaluated for the user first, and the parts depending on
+// columns are compiled into a parameterized WHERE clause, NULL follows the
+// three-valued logic of SQL, e.g. `owner_id = auth_user.id` is never true for
+// anonymous users.
+
+const authUserPrefix = "auth_user."
+
+var (
+	identRe      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	authUserKeys = map[string]bool{
+		"id": true, "username": true, "is_admin": true, "is_authenticated": true, "roles": true,
+	}
+	// parsed expressions by source
+	exprCache sync.Map
+)
+
+type node interface{}
+
+type (
+	// logicNode is `AND` or `OR`
+	logicNode struct {
+		op          string
+		left, right node
+	}
+	notNode struct{ x node }
+	// cmpNode is a comparison of two operands
+	cmpNode struct {
+		op          string
+		left, right node
+	}
+	// inNode is `x IN (list...)`, a list item may be a list value
+	inNode struct {
+		x    node
+		list []node
+	}
+	isNullNode struct {
+		x   node
+		not bool
+	}
+	// truthNode tests a bare operand, e.g. `public`
+	truthNode   struct{ x node }
+	columnNode  struct{ name string }
+	authNode    struct{ path []string }
+	hasRoleNode struct{ role string }
+	constNode   struct{ val any }
+)
+
+// ValidateExpression returns an error if the policy expression is invalid
+func ValidateExpression(exp string) error {
+	_, err := parseExpression(exp)
+	return err
+}
+
+// parseExpression parses a policy expression, an empty expression is nil
+func parseExpression(exp string) (node, error) {
+	if v, ok := exprCache.Load(exp); ok {
+		return v.(node), nil
+	}
+	tokens, err := tokenize(exp)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in policy expression", p.tokens[p.pos].text)
+	}
+	exprCache.Store(exp, n)
+	return n, nil
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(exp string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(exp); {
+		c := rune(exp[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, token{tokenPunct, string(c)})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			j := i + 1
+			if j < len(exp) && (exp[j] == '=' || (c == '<' && exp[j] == '>')) {
+				j++
+			}
+			op := exp[i:j]
+			if op == "!" {
+				return nil, errors.New("unexpected ! in policy expression")
+			}
+			tokens = append(tokens, token{tokenOp, op})
+			i = j
+		case c == '\'' || c == '"':
+			// quotes are escaped by doubling them like SQL
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(exp); j++ {
+				if exp[j] == byte(c) {
+					if j+1 < len(exp) && exp[j+1] == byte(c) {
+						b.WriteByte(byte(c))
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(exp[j])
+			}
+			if j >= len(exp) {
+				return nil, errors.New("unterminated string in policy expression")
+			}
+			tokens = append(tokens, token{tokenString, b.String()})
+			i = j + 1
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(exp) && (unicode.IsDigit(rune(exp[j])) || exp[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, exp[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(exp) && (exp[j] == '_' || exp[j] == '.' ||
+				unicode.IsLetter(rune(exp[j])) || unicode.IsDigit(rune(exp[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, exp[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in policy expression", c)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// keyword consumes the keyword if it's the next token
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t != nil && t.kind == tokenIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) punct(s string) bool {
+	if t := p.peek(); t != nil && t.kind == tokenPunct && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.punct(s) {
+		return fmt.Errorf("expected %q in policy expression", s)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{"OR", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{"AND", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.keyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (node, error) {
+	// a parenthesized expression
+	if p.punct("(") {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := left.(*hasRoleNode); ok {
+		return left, nil
+	}
+	if t := p.peek(); t != nil && t.kind == tokenOp {
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		op := t.text
+		if op == "!=" {
+			op = "<>"
+		}
+		return &cmpNode{op, left, right}, nil
+	}
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, errors.New("expected NULL after IS in policy expression")
+		}
+		return &isNullNode{left, not}, nil
+	}
+	not := p.keyword("NOT")
+	if p.keyword("IN") {
+		var list []node
+		if p.punct("(") {
+			for {
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+				if !p.punct(",") {
+					break
+				}
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		} else {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		var n node = &inNode{left, list}
+		if not {
+			n = &notNode{n}
+		}
+		return n, nil
+	}
+	if not {
+		return nil, errors.New("expected IN after NOT in policy expression")
+	}
+	return &truthNode{left}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, errors.New("unexpected end of policy expression")
+	}
+	p.pos++
+	switch t.kind {
+	case tokenString:
+		return &constNode{t.text}, nil
+	case tokenNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in policy expression", t.text)
+		}
+		return &constNode{v}, nil
+	case tokenIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return &constNode{true}, nil
+		case "FALSE":
+			return &constNode{false}, nil
+		case "NULL":
+			return &constNode{nil}, nil
+		case "AND", "OR", "NOT", "IN", "IS":
+			return nil, fmt.Errorf("unexpected %s in policy expression", t.text)
+		}
+		if t.text == "auth_user.has_role" {
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			role := p.peek()
+			if role == nil || role.kind != tokenString {
+				return nil, errors.New("has_role expects a string in policy expression")
+			}
+			p.pos++
+			return &hasRoleNode{role.text}, p.expect(")")
+		}
+		if strings.HasPrefix(t.text, authUserPrefix) {
+			path := strings.Split(strings.TrimPrefix(t.text, authUserPrefix), ".")
+			if (len(path) == 1 && authUserKeys[path[0]]) || (len(path) > 1 && path[0] == "claims") {
+				return &authNode{path}, nil
+			}
+			return nil, fmt.Errorf("unknown %s in policy expression", t.text)
+		}
+		if !identRe.MatchString(t.text) {
+			return nil, fmt.Errorf("invalid column %q in policy expression", t.text)
+		}
+		return &columnNode{t.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q in policy expression", t.text)
+}
+
+// result is a partially evaluated expression, it's either a known value or
+// SQL with args
+type result struct {
+	known bool
+	val   any // nil is NULL, []any is a list
+	sql   string
+	args  []any
+}
+
+func known(v any) *result { return &result{known: true, val: v} }
+
+// isList returns whether the value is a list
+func (r *result) isList() bool {
+	_, ok := r.val.([]any)
+	return r.known && ok
+}
+
+// operand returns the SQL of an operand, known values are parameterized
+func (r *result) operand() (string, []any) {
+	if r.known {
+		if r.val == nil {
+			return "NULL", nil
+		}
+		return "?", []any{r.val}
+	}
+	return r.sql, r.args
+}
+
+// predicate returns the SQL of a boolean result
+func (r *result) predicate() (string, []any) {
+	if r.known {
+		switch r.val {
+		case true:
+			return "1 = 1", nil
+		case false:
+			return "1 = 0", nil
+		default:
+			return "1 = NULL", nil
+		}
+	}
+	return r.sql, r.args
+}
+
+// evaluator evaluates expressions for a user, columns in values are replaced
+// by their values and the others are NULL if complete is set
+type evaluator struct {
+	user     *User
+	values   map[string]any
+	complete bool
+}
+
+func (e *evaluator) eval(n node) (*result, error) {
+	switch n := n.(type) {
+	case *constNode:
+		return known(n.val), nil
+	case *authNode:
+		return known(e.user.value(n.path)), nil
+	case *hasRoleNode:
+		return known(e.user.HasRole(n.role)), nil
+	case *columnNode:
+		if v, ok := e.values[n.name]; ok {
+			return known(v), nil
+		}
+		if e.complete {
+			return known(nil), nil
+		}
+		return &result{sql: n.name}, nil
+	case *truthNode:
+		return e.evalTruth(n)
+	case *cmpNode:
+		return e.evalCmp(n)
+	case *inNode:
+		return e.evalIn(n)
+	case *isNullNode:
+		x, err := e.eval(n.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.known {
+			return known((x.val == nil) != n.not), nil
+		}
+		if n.not {
+			return &result{sql: x.sql + " IS NOT NULL", args: x.args}, nil
+		}
+		return &result{sql: x.sql + " IS NULL", args: x.args}, nil
+	case *notNode:
+		x, err := e.eval(n.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.known {
+			if b, ok := x.val.(bool); ok {
+				return known(!b), nil
+			}
+			return known(nil), nil
+		}
+		return &result{sql: "NOT (" + x.sql + ")", args: x.args}, nil
+	case *logicNode:
+		return e.evalLogic(n)
+	}
+	return nil, fmt.Errorf("unknown policy expression node %T", n)
+}
+
+func (e *evaluator) evalTruth(n *truthNode) (*result, error) {
+	x, err := e.eval(n.x)
+	if err != nil {
+		return nil, err
+	}
+	if !x.known {
+		return &result{sql: x.sql + " = ?", args: append(x.args, true)}, nil
+	}
+	switch x.val.(type) {
+	case nil, bool:
+		return x, nil
+	}
+	return nil, fmt.Errorf("%v is not a boolean in policy expression", x.val)
+}
+
+func (e *evaluator) evalCmp(n *cmpNode) (*result, error) {
+	left, err := e.eval(n.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.eval(n.right)
+	if err != nil {
+		return nil, err
+	}
+	if left.isList() || right.isList() {
+		return nil, fmt.Errorf("lists can't be compared with %s in policy expression, use IN", n.op)
+	}
+	if (left.known && left.val == nil) || (right.known && right.val == nil) {
+		return known(nil), nil
+	}
+	if left.known && right.known {
+		return known(compare(n.op, left.val, right.val)), nil
+	}
+	lsql, largs := left.operand()
+	rsql, rargs := right.operand()
+	return &result{sql: fmt.Sprintf("%s %s %s", lsql, n.op, rsql), args: append(largs, rargs...)}, nil
+}
+
+func (e *evaluator) evalIn(n *inNode) (*result, error) {
+	x, err := e.eval(n.x)
+	if err != nil {
+		return nil, err
+	}
+	if x.isList() {
+		return nil, errors.New("lists can't be in a list in policy expression")
+	}
+	// expand list values like auth_user.roles
+	var items []*result
+	for _, item := range n.list {
+		r, err := e.eval(item)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := r.val.([]any); ok && r.known {
+			for _, v := range list {
+				items = append(items, known(normalize(v)))
+			}
+		} else {
+			items = append(items, r)
+		}
+	}
+	if x.known && x.val == nil {
+		return known(nil), nil
+	}
+
+	// x IN (a, b) is x = a OR x = b in three-valued logic
+	var res *result = known(false)
+	for _, item := range items {
+		var eq *result
+		switch {
+		case item.known && item.val == nil:
+			eq = known(nil)
+		case x.known && item.known:
+			eq = known(compare("=", x.val, item.val))
+		default:
+			lsql, largs := x.operand()
+			rsql, rargs := item.operand()
+			eq = &result{sql: fmt.Sprintf("%s = %s", lsql, rsql), args: append(largs, rargs...)}
+		}
+		res = or(res, eq)
+	}
+	return res, nil
+}
+
+func (e *evaluator) evalLogic(n *logicNode) (*result, error) {
+	left, err := e.eval(n.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.eval(n.right)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range []*result{left, right} {
+		if r.known {
+			if _, ok := r.val.(bool); !ok && r.val != nil {
+				return nil, fmt.Errorf("%v is not a boolean in policy expression", r.val)
+			}
+		}
+	}
+	if n.op == "AND" {
+		return and(left, right), nil
+	}
+	return or(left, right), nil
+}
+
+func and(left, right *result) *result {
+	switch {
+	case left.known && left.val == false, right.known && right.val == false:
+		return known(false)
+	case left.known && left.val == true:
+		return right
+	case right.known && right.val == true:
+		return left
+	case left.known && right.known:
+		// both are NULL
+		return known(nil)
+	}
+	lsql, largs := left.predicate()
+	rsql, rargs := right.predicate()
+	return &result{sql: fmt.Sprintf("(%s) AND (%s)", lsql, rsql), args: append(largs, rargs...)}
+}
+
+func or(left, right *result) *result {
+	switch {
+	case left.known && left.val == true, right.known && right.val == true:
+		return known(true)
+	case left.known && left.val == false:
+		return right
+	case right.known && right.val == false:
+		return left
+	case left.known && right.known:
+		return known(nil)
+	}
+	lsql, largs := left.predicate()
+	rsql, rargs := right.predicate()
+	return &result{sql: fmt.Sprintf("(%s) OR (%s)", lsql, rsql), args: append(largs, rargs...)}
+}
+
+// normalize converts numbers to float64 to compare values from JSON, claims
+// and the database
+func normalize(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case []string:
+		list := make([]any, len(n))
+		for i, s := range n {
+			list[i] = s
+		}
+		return list
+	}
+	return v
+}
+
+// compare compares two known non-NULL values, a string equals to a number if
+// it's the same number, other values of different types are never equal
+func compare(op string, a, b any) any {
+	a, b = normalize(a), normalize(b)
+	if s, ok := a.(string); ok {
+		if _, ok := b.(float64); ok {
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				a = v
+			}
+		}
+	} else if s, ok := b.(string); ok {
+		if _, ok := a.(float64); ok {
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				b = v
+			}
+		}
+	}
+
+	var c int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return mismatch(op)
+		}
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return mismatch(op)
+		}
+		c = strings.Compare(x, y)
+	case bool:
+		y, ok := b.(bool)
+		if !ok || (op != "=" && op != "<>") {
+			return mismatch(op)
+		}
+		if x != y {
+			c = 1
+		}
+	default:
+		return mismatch(op)
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return nil
+}
+
+// mismatch is the result of comparing values of different types
+func mismatch(op string) any {
+	switch op {
+	case "=":
+		return false
+	case "<>":
+		return true
+	}
+	return nil
+}
+
+// value returns the value of `auth_user.<path>`, values of anonymous users
+// are NULL except is_authenticated and is_admin
+func (u *User) value(path []string) any {
+	switch path[0] {
+	case "is_authenticated":
+		return u.IsAuthenticated()
+	case "is_admin":
+		return u.IsAdmin
+	case "roles":
+		return normalize(append([]string{}, u.Roles...))
+	}
+	if u.IsAnonymous() {
+		return nil
+	}
+	switch path[0] {
+	case "id":
+		return u.ID
+	case "username":
+		return u.Username
+	case "claims":
+		var v any = u.Claims
+		for _, key := range path[1:] {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil
+			}
+			v = m[key]
+		}
+		return normalize(v)
+	}
+	return nil
+}
+
+// Condition is a policy expression evaluated for a user, it limits the rows a
+// user can access with a WHERE clause and checks the rows to write
+type Condition struct {
+	exp   node
+	user  *User
+	where *result
+}
+
+// newCondition evaluates the expression for the user, it returns nil if the
+// expression is always true, and false if it's never true
+func newCondition(exp string, user *User) (*Condition, bool, error) {
+	n, err := parseExpression(exp)
+	if err != nil || n == nil {
+		return nil, err == nil, err
+	}
+	e := &evaluator{user: user}
+	res, err := e.eval(n)
+	if err != nil {
+		return nil, false, err
+	}
+	if res.known {
+		if _, ok := res.val.(bool); !ok && res.val != nil {
+			return nil, false, fmt.Errorf("%v is not a boolean in policy expression", res.val)
+		}
+		return nil, res.val == true, nil
+	}
+	return &Condition{exp: n, user: user, where: res}, true, nil
+}
+
+// Where returns the WHERE clause with `?` placeholders limiting the rows
+func (c *Condition) Where() (string, []any) {
+	return c.where.sql, c.where.args
+}
+
+// WhereUpdated returns the WHERE clause the rows should meet after updated
+// with values, ok is false if the values never meet the condition
+func (c *Condition) WhereUpdated(values map[string]any) (query string, args []any, ok bool) {
+	e := &evaluator{user: c.user, values: values}
+	res, err := e.eval(c.exp)
+	if err != nil {
+		return "", nil, false
+	}
+	if res.known {
+		return "", nil, res.val == true
+	}
+	return res.sql, res.args, true
+}
+
+// Allows returns whether a new row meets the condition, missing columns are
+// NULL
+func (c *Condition) Allows(row map[string]any) bool {
+	e := &evaluator{user: c.user, values: row, complete: true}
+	res, err := e.eval(c.exp)
+	return err == nil && res.known && res.val == true
+}
+
+// Defaults returns the columns which must equal to a value of the user by the
+// top level AND of the condition, e.g. `owner_id = auth_user.id`, they are
+// set on new rows
+func (c *Condition) Defaults() map[string]any {
+	defaults := map[string]any{}
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *logicNode:
+			if n.op == "AND" {
+				walk(n.left)
+				walk(n.right)
+			}
+		case *cmpNode:
+			if n.op != "=" {
+				return
+			}
+			col, ok := n.left.(*columnNode)
+			auth, ok2 := n.right.(*authNode)
+			if !ok || !ok2 {
+				col, ok = n.right.(*columnNode)
+				auth, ok2 = n.left.(*authNode)
+			}
+			if ok && ok2 {
+				if v := c.user.value(auth.path); v != nil {
+					defaults[col.name] = v
+				}
+			}
+		}
+	}
+	walk(c.exp)
+	return defaults
+}
+
+// userIDColumn returns the column if the expression is `<column> =
+// auth_user.id`
+func userIDColumn(n node) string {
+	if n, ok := n.(*cmpNode); ok && n.op == "=" {
+		col, ok := n.left.(*columnNode)
+		auth, ok2 := n.right.(*authNode)
+		if !ok || !ok2 {
+			col, ok = n.right.(*columnNode)
+			auth, ok2 = n.left.(*authNode)
+		}
+		if ok && ok2 && auth.path[0] == "id" {
+			return col.name
+		}
+	}
+	return ""
+}
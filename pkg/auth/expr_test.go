@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExpression(t *testing.T) {
+	for _, exp := range []string{
+		"",
+		"auth_user.is_admin",
+		"user_id = auth_user.id",
+		"org_id = auth_user.claims.org_id AND (public OR owner_id = auth_user.id)",
+		"status IN ('draft', 'published') AND deleted_at IS NULL",
+		"NOT (owner_id != auth_user.id) OR auth_user.has_role('support')",
+		"role NOT IN auth_user.roles",
+		"title = 'it''s'",
+	} {
+		assert.Nil(t, ValidateExpression(exp), exp)
+	}
+
+	for _, exp := range []string{
+		"invalid policy",
+		"user_id = ",
+		"user_id = auth_user.unknown",
+		"(public",
+		"title = 'unterminated",
+		"user_id ! 1",
+		"user_id; DROP TABLE users",
+		"auth_user.has_role(support)",
+		"deleted_at IS 1",
+		"status NOT 'draft'",
+		"a.b = 1",
+	} {
+		assert.NotNil(t, ValidateExpression(exp), exp)
+	}
+}
+
+//nolint:funlen
+func TestNewCondition(t *testing.T) {
+	user := &User{
+		ID:    1,
+		Roles: []string{"editor"},
+		Claims: map[string]any{
+			"org_id": float64(10),
+			"teams":  []any{"a", "b"},
+			"app":    map[string]any{"plan": "pro"},
+		},
+	}
+	anonymous := &User{}
+	for _, test := range []struct {
+		name  string
+		exp   string
+		user  *User
+		ok    bool
+		where string
+		args  []any
+	}{
+		{
+			name: "empty expression allows all",
+			exp:  "",
+			user: anonymous,
+			ok:   true,
+		},
+		{
+			name: "known values are evaluated",
+			exp:  "auth_user.is_authenticated AND auth_user.claims.app.plan = 'pro'",
+			user: user,
+			ok:   true,
+		},
+		{
+			name: "false is denied",
+			exp:  "auth_user.is_admin",
+			user: user,
+			ok:   false,
+		},
+		{
+			name:  "columns are parameterized",
+			exp:   "user_id = auth_user.id",
+			user:  user,
+			ok:    true,
+			where: "user_id = ?",
+			args:  []any{int64(1)},
+		},
+		{
+			name: "NULL is never true",
+			exp:  "user_id = auth_user.id",
+			user: anonymous,
+			ok:   false,
+		},
+		{
+			name:  "known parts are simplified",
+			exp:   "org_id = auth_user.claims.org_id AND (public OR owner_id = auth_user.id OR auth_user.is_admin)",
+			user:  user,
+			ok:    true,
+			where: "(org_id = ?) AND ((public = ?) OR (owner_id = ?))",
+			args:  []any{float64(10), true, int64(1)},
+		},
+		{
+			name:  "NULL OR column",
+			exp:   "owner_id = auth_user.id OR public",
+			user:  anonymous,
+			ok:    true,
+			where: "(1 = NULL) OR (public = ?)",
+			args:  []any{true},
+		},
+		{
+			name:  "roles grant access",
+			exp:   "owner_id = auth_user.id OR auth_user.has_role('editor')",
+			user:  user,
+			ok:    true,
+			where: "",
+		},
+		{
+			name:  "IN lists are expanded",
+			exp:   "team IN auth_user.claims.teams AND status NOT IN ('draft', 'deleted')",
+			user:  user,
+			ok:    true,
+			where: "((team = ?) OR (team = ?)) AND (NOT ((status = ?) OR (status = ?)))",
+			args:  []any{"a", "b", "draft", "deleted"},
+		},
+		{
+			name: "IN of known values",
+			exp:  "'editor' IN auth_user.roles",
+			user: user,
+			ok:   true,
+		},
+		{
+			name:  "IS NULL",
+			exp:   "deleted_at IS NULL AND auth_user.claims.missing IS NULL",
+			user:  user,
+			ok:    true,
+			where: "deleted_at IS NULL",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cond, ok, err := newCondition(test.exp, test.user)
+			assert.Nil(t, err)
+			assert.Equal(t, test.ok, ok)
+			if test.where == "" {
+				assert.Nil(t, cond)
+				return
+			}
+			where, args := cond.Where()
+			assert.Equal(t, test.where, where)
+			assert.Equal(t, test.args, args)
+		})
+	}
+
+	t.Run("non boolean expression is invalid", func(t *testing.T) {
+		_, ok, err := newCondition("auth_user.claims.org_id", user)
+		assert.NotNil(t, err)
+		assert.False(t, ok)
+
+		_, ok, err = newCondition("auth_user.roles = 'editor'", user)
+		assert.NotNil(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCondition(t *testing.T) {
+	user := &User{ID: 1, Claims: map[string]any{"org_id": float64(10)}}
+	cond, ok, err := newCondition("org_id = auth_user.claims.org_id AND (public OR owner_id = auth_user.id)", user)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	t.Run("defaults", func(t *testing.T) {
+		assert.Equal(t, map[string]any{"org_id": float64(10)}, cond.Defaults())
+	})
+
+	t.Run("allows", func(t *testing.T) {
+		assert.True(t, cond.Allows(map[string]any{"org_id": 10, "owner_id": float64(1)}))
+		assert.True(t, cond.Allows(map[string]any{"org_id": "10", "public": true}))
+		assert.False(t, cond.Allows(map[string]any{"org_id": 10, "owner_id": 2}))
+		assert.False(t, cond.Allows(map[string]any{"org_id": 11, "owner_id": 1}))
+		assert.False(t, cond.Allows(map[string]any{"owner_id": 1}))
+	})
+
+	t.Run("where updated", func(t *testing.T) {
+		where, args, ok := cond.WhereUpdated(map[string]any{"title": "new"})
+		assert.True(t, ok)
+		assert.Equal(t, "(org_id = ?) AND ((public = ?) OR (owner_id = ?))", where)
+		assert.Equal(t, []any{float64(10), true, int64(1)}, args)
+
+		where, args, ok = cond.WhereUpdated(map[string]any{"owner_id": 2})
+		assert.True(t, ok)
+		assert.Equal(t, "(org_id = ?) AND (public = ?)", where)
+		assert.Equal(t, []any{float64(10), true}, args)
+
+		where, _, ok = cond.WhereUpdated(map[string]any{"org_id": 10, "public": true})
+		assert.True(t, ok)
+		assert.Equal(t, "", where)
+
+		_, _, ok = cond.WhereUpdated(map[string]any{"org_id": 11})
+		assert.False(t, ok)
+	})
+}
@@ -15,25 +15,208 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-const adminUsername = "rest_admin"
+const (
+	adminUsername = "rest_admin"
+
+	// DefaultIssuer is the `iss` claim of tokens issued by the handler
+	DefaultIssuer = "rest"
+)
 
 // Handler is handler with auth endpoints like `register`, `login`, and `logout`
 type Handler struct {
-	db     *sql.DB
-	secret []byte
+	db       *sql.DB
+	secret   []byte
+	signer   *Signer
+	keySet   *KeySet
+	denylist *Denylist
+	apiKeys  *APIKeys
+	// identities maps tokens of JWKS sources to users
+	identities *Identities
+	client     *http.Client
+	notifier   Notifier
+
+	// providers are OpenID Connect providers by name
+	providers map[string]*oauthProvider
+
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
+	passwordResetTTL time.Duration
+	verificationTTL  time.Duration
+
+	// issuer and audience of the tokens issued by the handler
+	issuer   string
+	audience string
+
+	// requireVerification blocks login of users with unverified emails
+	requireVerification bool
+}
+
+// HandlerOption configures a Handler
+type HandlerOption func(*Handler)
+
+// AccessTokenTTL sets the lifetime of access tokens, it's 15 minutes by default
+func AccessTokenTTL(ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if ttl > 0 {
+			h.accessTokenTTL = ttl
+		}
+	}
+}
+
+// RefreshTokenTTL sets the lifetime of sessions, it's 14 days by default
+func RefreshTokenTTL(ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if ttl > 0 {
+			h.refreshTokenTTL = ttl
+		}
+	}
+}
+
+// PasswordResetTTL sets the lifetime of password reset tokens, it's 1 hour by
+// default
+func PasswordResetTTL(ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if ttl > 0 {
+			h.passwordResetTTL = ttl
+		}
+	}
+}
+
+// VerificationTTL sets the lifetime of email verification tokens, it's 24
+// hours by default
+func VerificationTTL(ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if ttl > 0 {
+			h.verificationTTL = ttl
+		}
+	}
+}
+
+// RequireVerification blocks login until the email of the user is verified,
+// an email is required on registration
+func RequireVerification(required bool) HandlerOption {
+	return func(h *Handler) {
+		h.requireVerification = required
+	}
+}
+
+// WithNotifier sends password reset and email verification tokens by the
+// notifier, they are not enabled without a notifier
+func WithNotifier(notifier Notifier) HandlerOption {
+	return func(h *Handler) {
+		h.notifier = notifier
+	}
+}
+
+// Issuer sets the `iss` claim of tokens issued by the handler, it's
+// DefaultIssuer by default, its key set only accepts tokens of the issuer
+// besides the ones of JWKS sources
+func Issuer(issuer string) HandlerOption {
+	return func(h *Handler) {
+		if issuer != "" {
+			h.issuer = issuer
+		}
+	}
+}
+
+// Audience sets the `aud` claim of tokens issued by the handler, its key set
+// requires it in the tokens if it's set
+func Audience(audience string) HandlerOption {
+	return func(h *Handler) {
+		h.audience = audience
+	}
+}
+
+// WithSigner signs tokens with the signer instead of HS256 with the secret
+func WithSigner(signer *Signer) HandlerOption {
+	return func(h *Handler) {
+		h.signer = signer
+	}
 }
 
 // NewHandler return a Handler with provided database url and JWT secret
-func NewHandler(dbURL string, secret []byte) (*Handler, error) {
+func NewHandler(dbURL string, secret []byte, options ...HandlerOption) (*Handler, error) {
 	db, err := sql.Open(dbURL)
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{db, secret}, nil
+	h := &Handler{
+		db:               db,
+		secret:           secret,
+		accessTokenTTL:   DefaultAccessTokenTTL,
+		refreshTokenTTL:  DefaultRefreshTokenTTL,
+		passwordResetTTL: DefaultPasswordResetTTL,
+		verificationTTL:  DefaultVerificationTTL,
+		issuer:           DefaultIssuer,
+		client:           &http.Client{Timeout: oauthTimeout},
+		providers:        map[string]*oauthProvider{},
+	}
+	for _, option := range options {
+		option(h)
+	}
+	if h.signer == nil {
+		h.signer = NewHMACSigner(secret)
+	}
+	h.keySet = NewKeySet(secret)
+	h.keySet.AddSigner(h.signer)
+	h.keySet.SetIssuer(h.issuer, h.audience)
+	h.denylist = newDenylist(db, h.accessTokenTTL)
+	h.apiKeys = newAPIKeys(db)
+	h.identities = &Identities{db: db}
+	// create tables for databases set up before sessions, identities, api
+	// keys, roles, column policies, password resets and profiles
+	if isSetupDone(db) {
+		setups := []func(*sql.DB) error{
+			setupSessions, setupIdentities, setupAPIKeys, setupRoles, setupColumnPolicies, setupPasswordResets,
+			setupProfiles,
+		}
+		for _, setup := range setups {
+			if err := setup(db); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
+
+// KeySet returns the keys to verify tokens issued by the handler, more keys
+// can be added to it for NewMiddleware to accept tokens of other issuers
+func (h *Handler) KeySet() *KeySet {
+	return h.keySet
+}
+
+// Denylist returns the revoked sessions for NewMiddleware to deny their
+// access tokens
+func (h *Handler) Denylist() *Denylist {
+	return h.denylist
+}
+
+// APIKeys returns the API keys authenticator for NewMiddleware to accept API
+// keys
+func (h *Handler) APIKeys() *APIKeys {
+	return h.apiKeys
+}
+
+// Identities returns the linked identities for NewMiddleware to authenticate
+// tokens of JWKS sources
+func (h *Handler) Identities() *Identities {
+	return h.identities
 }
 
 // ServeHTTP implements http.Handler interface
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == JWKSPath && r.Method == http.MethodGet {
+		j.Write(w, h.signer.JWKS())
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, oauthPathPrefix) {
+		h.serveOAuth(w, r)
+		return
+	}
+	if r.URL.Path == mePath {
+		h.serveMe(w, r)
+		return
+	}
 	if r.Method != http.MethodPost {
 		res := &j.Response{
 			Code: http.StatusMethodNotAllowed,
@@ -61,8 +244,20 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		res = h.register(r)
 	case "login":
 		res = h.login(r)
+	case "refresh":
+		res = h.refresh(r)
 	case "logout":
 		res = h.logout(r)
+	case "password/change":
+		res = h.changePassword(r)
+	case "password/reset/request":
+		res = h.requestPasswordReset(r)
+	case "password/reset/confirm":
+		res = h.confirmPasswordReset(r)
+	case "verify/request":
+		res = h.requestVerification(r)
+	case "verify/confirm":
+		res = h.confirmVerification(r)
 	default:
 		res = &j.Response{
 			Code: http.StatusBadRequest,
@@ -88,31 +283,56 @@ func (h *Handler) setup() any {
 	}
 }
 
+type registerData struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	Email       string `json:"email"`
+	DisplayName string `json:"display_name"`
+}
+
+// register creates a user with optional email and display name, a
+// verification token is sent to the email if there is a notifier
 func (h *Handler) register(r *http.Request) any {
-	user := &User{}
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var data registerData
+	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
 		return &j.Response{
 			Code: http.StatusBadRequest,
 			Msg:  "failed to decode json data",
 		}
 	}
+	if data.Email == "" && h.requireVerification {
+		return &j.Response{Code: http.StatusBadRequest, Msg: "email is required"}
+	}
+	if err := validateProfile(data.Email, data.DisplayName); err != nil {
+		return &j.Response{Code: http.StatusBadRequest, Msg: err.Error()}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
-	hashedPassword, err := HashPassword(user.Password)
+	hashedPassword, err := HashPassword(data.Password)
 	if err != nil {
 		return &j.Response{
 			Code: http.StatusInternalServerError,
 			Msg:  "failed to hash password",
 		}
 	}
-	_, dbErr := h.db.ExecQuery(ctx, createUser, user.Username, hashedPassword)
+	_, dbErr := h.db.ExecQuery(ctx, createUserWithProfile,
+		data.Username, hashedPassword, nullable(data.Email), nullable(data.DisplayName))
 	if dbErr != nil {
 		log.Errorf("create user error: %v", dbErr)
 		return j.ErrResponse(dbErr)
 	}
 
+	if data.Email != "" && h.notifier != nil {
+		row, err := h.db.FetchOne(ctx, queryUser, data.Username)
+		if err == nil {
+			err = h.sendVerification(ctx, row["id"].(int64), data.Email)
+		}
+		if err != nil {
+			log.Errorf("send verification error: %v", err)
+		}
+	}
 	return &j.Response{Code: http.StatusOK, Msg: "success"}
 }
 
@@ -142,10 +362,36 @@ func (h *Handler) login(r *http.Request) any {
 		}
 	}
 
-	tokenString, err := GenJWTToken(h.secret, map[string]any{
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if res := h.checkVerified(ctx, user.ID); res != nil {
+		return res
+	}
+	sid, refreshToken, err := h.createSession(ctx, user.ID)
+	if err != nil {
+		log.Errorf("create session error: %v", err)
+		return j.ErrResponse(err)
+	}
+	return h.tokens(user, sid, refreshToken)
+}
+
+// tokens returns a new access token along with the refresh token of the
+// session, roles of the user are embedded in the access token so changes of
+// roles take effect on the next refresh
+func (h *Handler) tokens(user *User, sid, refreshToken string) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	roles, err := fetchRoles(ctx, h.db, user.ID)
+	if err != nil {
+		log.Errorf("fetch roles error: %v", err)
+		return j.ErrResponse(err)
+	}
+	tokenString, err := h.sign(map[string]any{
 		"user_id":  user.ID,
 		"is_admin": user.IsAdmin,
-		"exp":      time.Now().Add(14 * 24 * time.Hour).Unix(),
+		"roles":    roles,
+		"sid":      sid,
+		"exp":      time.Now().Add(h.accessTokenTTL).Unix(),
 	})
 	if err != nil {
 		return &j.Response{
@@ -155,15 +401,102 @@ func (h *Handler) login(r *http.Request) any {
 	}
 
 	return &struct {
-		Token string `json:"token"`
-	}{tokenString}
+		Token        string `json:"token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}{tokenString, "Bearer", int64(h.accessTokenTTL.Seconds()), refreshToken}
+}
+
+// sign signs the claims with the issuer and audience of the handler
+func (h *Handler) sign(claims map[string]any) (string, error) {
+	claims["iss"] = h.issuer
+	if h.audience != "" {
+		claims["aud"] = h.audience
+	}
+	return h.signer.Sign(claims)
+}
+
+type refreshData struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refresh issues a new access token and rotates the refresh token
+func (h *Handler) refresh(r *http.Request) any {
+	var data refreshData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.RefreshToken == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "refresh_token is required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	userID, sid, refreshToken, err := h.rotateSession(ctx, data.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			return &j.Response{Code: http.StatusUnauthorized, Msg: err.Error()}
+		}
+		log.Errorf("refresh session error: %v", err)
+		return j.ErrResponse(err)
+	}
+	row, err := h.db.FetchOne(ctx, queryUserByID, userID)
+	if err != nil {
+		log.Errorf("fetch user error: %v", err)
+		return j.ErrResponse(err)
+	}
+	user := &User{ID: userID, Username: row["username"].(string), IsAdmin: row["is_admin"].(bool)}
+	return h.tokens(user, sid, refreshToken)
 }
 
-func (h *Handler) logout(_ *http.Request) any {
-	// client delete token, no op on server side
+// logout revokes the session of the access token in Authorization header or
+// the refresh token in body
+func (h *Handler) logout(r *http.Request) any {
+	var sid string
+	if tokenString := strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer "); tokenString != "" {
+		if claims, err := h.keySet.Parse(tokenString); err == nil {
+			sid, _ = claims["sid"].(string)
+		}
+	} else {
+		var data refreshData
+		if err := json.NewDecoder(r.Body).Decode(&data); err == nil {
+			sid, _, _ = strings.Cut(data.RefreshToken, ".")
+		}
+	}
+	if sid == "" {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "a valid access token or refresh token is required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if err := h.revokeSession(ctx, sid); err != nil {
+		log.Errorf("revoke session error: %v", err)
+		return j.ErrResponse(err)
+	}
 	return &j.Response{Code: http.StatusOK, Msg: "success"}
 }
 
+// bearerClaims returns the claims of the access token in Authorization
+// header, claims are nil if there is no token
+func (h *Handler) bearerClaims(r *http.Request) (map[string]any, error) {
+	tokenString := strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer ")
+	if tokenString == "" {
+		return nil, nil
+	}
+	claims, err := h.keySet.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if sid, _ := claims["sid"].(string); h.denylist.IsRevoked(sid) {
+		return nil, errors.New("session is revoked")
+	}
+	return claims, nil
+}
+
 func (h *Handler) authenticate(username, password string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
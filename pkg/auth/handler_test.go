@@ -2,11 +2,13 @@ package auth
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -82,7 +84,7 @@ func TestHandlerActions(t *testing.T) {
 			t.Error(err)
 		}
 
-		var resData map[string]string
+		var resData map[string]any
 		err = json.Unmarshal(data, &resData)
 		assert.Nil(t, err)
 		t.Log("get token: ", resData["token"])
@@ -112,12 +114,109 @@ func TestHandlerActions(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, res.StatusCode)
 	})
 
-	t.Run("logout", func(t *testing.T) {
+	t.Run("logout without token", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
 		w := httptest.NewRecorder()
 		testHandler.ServeHTTP(w, req)
 		res := w.Result()
 		defer res.Body.Close()
-		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+}
+
+// authRequest posts body to the auth action and returns the status code and
+// the decoded response
+func authRequest(t *testing.T, action, token, body string) (int, map[string]any) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/"+action, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(AuthorizationHeader, "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, req)
+	res := w.Result()
+	defer res.Body.Close()
+	var data map[string]any
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&data))
+	return res.StatusCode, data
+}
+
+func TestHandlerSession(t *testing.T) {
+	authRequest(t, "register", "", `{"username": "session", "password": "world"}`)
+	login := func() map[string]any {
+		code, data := authRequest(t, "login", "", `{"username": "session", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "Bearer", data["token_type"])
+		assert.Equal(t, DefaultAccessTokenTTL.Seconds(), data["expires_in"])
+		return data
+	}
+	refresh := func(refreshToken string) (int, map[string]any) {
+		return authRequest(t, "refresh", "", fmt.Sprintf(`{"refresh_token": %q}`, refreshToken))
+	}
+
+	t.Run("rotate refresh token", func(t *testing.T) {
+		tokens := login()
+		code, refreshed := refresh(tokens["refresh_token"].(string))
+		assert.Equal(t, http.StatusOK, code)
+		assert.NotEqual(t, tokens["refresh_token"], refreshed["refresh_token"])
+
+		claims, err := ParseJWTToken([]byte(testSecret), refreshed["token"].(string))
+		assert.Nil(t, err)
+		assert.Equal(t, false, claims["is_admin"])
+
+		t.Log("reuse a rotated refresh token revokes the session")
+		code, _ = refresh(tokens["refresh_token"].(string))
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = refresh(refreshed["refresh_token"].(string))
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("invalid refresh token", func(t *testing.T) {
+		for _, token := range []string{"", "invalid", "unknown.token"} {
+			code, _ := refresh(token)
+			assert.Contains(t, []int{http.StatusBadRequest, http.StatusUnauthorized}, code, token)
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		tokens := login()
+		token := tokens["token"].(string)
+		middleware := NewMiddleware([]byte(testSecret), WithDenylist(testHandler.Denylist()))
+		authHandler := middleware(http.HandlerFunc(testHandle))
+		get := func() int {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Add(AuthorizationHeader, "Bearer "+token)
+			w := httptest.NewRecorder()
+			authHandler.ServeHTTP(w, req)
+			return w.Result().StatusCode
+		}
+		assert.Equal(t, http.StatusOK, get())
+
+		code, _ := authRequest(t, "logout", token, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, http.StatusUnauthorized, get())
+		code, _ = refresh(tokens["refresh_token"].(string))
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		t.Log("logout by refresh token")
+		tokens = login()
+		code, _ = authRequest(t, "logout", "", fmt.Sprintf(`{"refresh_token": %q}`, tokens["refresh_token"]))
+		assert.Equal(t, http.StatusOK, code)
+		code, _ = refresh(tokens["refresh_token"].(string))
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("denylist is loaded from database", func(t *testing.T) {
+		tokens := login()
+		claims, err := ParseJWTToken([]byte(testSecret), tokens["token"].(string))
+		assert.Nil(t, err)
+		sid := claims["sid"].(string)
+
+		denylist := newDenylist(testHandler.db, DefaultAccessTokenTTL)
+		assert.False(t, denylist.IsRevoked(sid))
+		code, _ := authRequest(t, "logout", tokens["token"].(string), "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.False(t, denylist.IsRevoked(sid), "cached until next load")
+		denylist.loadedAt = time.Time{}
+		assert.True(t, denylist.IsRevoked(sid))
 	})
 }
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/rest-go/rest/pkg/log"
+)
+
+const (
+	// JWKSPath is the path to serve public keys of the signer
+	JWKSPath = "/.well-known/jwks.json"
+
+	// jwksRefreshInterval is the interval to reload JWKS for key rotation
+	jwksRefreshInterval = 10 * time.Minute
+	// jwksMinRefreshInterval limits reloading JWKS for unknown key ids
+	jwksMinRefreshInterval = time.Minute
+	// jwksFetchTimeout is the timeout to fetch JWKS from a URL
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// JWKSSource is a JWKS file or URL of an identity provider, tokens verified
+// by its keys must be issued by Issuer, and for Audience if it's set. Their
+// `sub` claims are mapped to local users by identities of Provider in
+// `auth_identities`, e.g. the name of an OAuth provider, tokens of sources
+// without Provider don't authenticate any user
+type JWKSSource struct {
+	URL      string
+	Issuer   string
+	Audience string
+	Provider string
+}
+
+// jwksKey is a key loaded from a JWKS source
+type jwksKey struct {
+	key    crypto.PublicKey
+	source *JWKSSource
+}
+
+// KeySet verifies JWT tokens with a HMAC secret, public keys of signers and
+// JWKS from files or URLs, JWKS are reloaded periodically and on unknown key
+// ids to support key rotation
+type KeySet struct {
+	secret  []byte
+	client  *http.Client
+	sources []*JWKSSource
+
+	// issuer and audience of tokens verified by the secret or signers
+	issuer   string
+	audience string
+
+	mu       sync.RWMutex
+	static   map[string]crypto.PublicKey // kid => key of signers
+	loaded   map[string]jwksKey          // kid => key of JWKS
+	loadedAt time.Time
+}
+
+// NewKeySet returns a KeySet verifying HS256 tokens with the secret, the
+// secret can be empty if only asymmetric keys are used
+func NewKeySet(secret []byte) *KeySet {
+	return &KeySet{
+		secret: secret,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		static: map[string]crypto.PublicKey{},
+		loaded: map[string]jwksKey{},
+	}
+}
+
+// SetIssuer requires tokens verified by the secret or signers to be issued by
+// the issuer, and for the audience if it's set
+func (ks *KeySet) SetIssuer(issuer, audience string) {
+	ks.issuer, ks.audience = issuer, audience
+}
+
+// AddSigner accepts tokens signed by the signer
+func (ks *KeySet) AddSigner(s *Signer) {
+	if key := s.publicKey(); key != nil {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		ks.static[s.kid] = key
+	}
+}
+
+// AddJWKS accepts tokens signed by keys in a JWKS file or URL, e.g.
+// https://idp.example.com/.well-known/jwks.json, the issuer is required so
+// that tokens of other sources are not accepted
+func (ks *KeySet) AddJWKS(source JWKSSource) error {
+	if source.Issuer == "" {
+		return fmt.Errorf("issuer is required for JWKS %s", source.URL)
+	}
+	keys, err := ks.fetch(source.URL)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	s := &source
+	ks.sources = append(ks.sources, s)
+	for kid, key := range keys {
+		ks.loaded[kid] = jwksKey{key, s}
+	}
+	ks.loadedAt = time.Now()
+	return nil
+}
+
+// Parse verifies the token and returns its claims, the issuer and audience
+// are checked against the source of the key
+func (ks *KeySet) Parse(tokenString string) (map[string]any, error) {
+	claims, _, err := ks.ParseSource(tokenString)
+	return claims, err
+}
+
+// ParseSource verifies the token and returns its claims along with the JWKS
+// source of its key, the source is nil for tokens of the secret or signers
+func (ks *KeySet) ParseSource(tokenString string) (map[string]any, *JWKSSource, error) {
+	var source *JWKSSource
+	issuer, audience := ks.issuer, ks.audience
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		key, s, err := ks.keyfunc(token)
+		if s != nil {
+			source, issuer, audience = s, s.Issuer, s.Audience
+		}
+		return key, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, nil, errors.New("invalid token")
+	}
+	if issuer != "" && !claims.VerifyIssuer(issuer, true) {
+		return nil, nil, fmt.Errorf("invalid issuer: %v", claims["iss"])
+	}
+	if audience != "" && !claims.VerifyAudience(audience, true) {
+		return nil, nil, fmt.Errorf("invalid audience: %v", claims["aud"])
+	}
+	return map[string]any(claims), source, nil
+}
+
+// keyfunc returns the key to verify the token along with its JWKS source,
+// the source is nil for the secret and signers, the key must match the
+// algorithm of the token to prevent algorithm confusion
+func (ks *KeySet) keyfunc(token *jwt.Token) (any, *JWKSSource, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(ks.secret) == 0 {
+			return nil, nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return ks.secret, nil, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, source := ks.lookup(kid, token.Method)
+	if key == nil && ks.reload() {
+		key, source = ks.lookup(kid, token.Method)
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("no key found for kid %q and alg %v", kid, token.Header["alg"])
+	}
+	return key, source, nil
+}
+
+// lookup finds a key by kid, the only key matching the method is used if the
+// token has no kid
+func (ks *KeySet) lookup(kid string, method jwt.SigningMethod) (crypto.PublicKey, *JWKSSource) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if kid != "" {
+		if key, ok := ks.static[kid]; ok && matchMethod(key, method) {
+			return key, nil
+		}
+		if k, ok := ks.loaded[kid]; ok && matchMethod(k.key, method) {
+			return k.key, k.source
+		}
+		return nil, nil
+	}
+
+	var found crypto.PublicKey
+	var source *JWKSSource
+	count := 0
+	for _, key := range ks.static {
+		if matchMethod(key, method) {
+			found, source = key, nil
+			count++
+		}
+	}
+	for _, k := range ks.loaded {
+		if matchMethod(k.key, method) {
+			found, source = k.key, k.source
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, nil
+	}
+	return found, source
+}
+
+func matchMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, rsaOK := method.(*jwt.SigningMethodRSA)
+		_, pssOK := method.(*jwt.SigningMethodRSAPSS)
+		return rsaOK || pssOK
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+	return false
+}
+
+// reload reloads JWKS if they are not reloaded recently, it returns whether
+// the keys are reloaded
+func (ks *KeySet) reload() bool {
+	ks.mu.Lock()
+	if len(ks.sources) == 0 || time.Since(ks.loadedAt) < jwksMinRefreshInterval {
+		ks.mu.Unlock()
+		return false
+	}
+	ks.loadedAt = time.Now()
+	sources := ks.sources
+	ks.mu.Unlock()
+
+	loaded := map[string]jwksKey{}
+	for _, source := range sources {
+		keys, err := ks.fetch(source.URL)
+		if err != nil {
+			// keep the previous keys
+			log.Warnf("reload JWKS from %s error: %v", source.URL, err)
+			return false
+		}
+		for kid, key := range keys {
+			loaded[kid] = jwksKey{key, source}
+		}
+	}
+	ks.mu.Lock()
+	ks.loaded = loaded
+	ks.mu.Unlock()
+	return true
+}
+
+// Watch reloads JWKS periodically in background until ctx is done
+func (ks *KeySet) Watch(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(jwksRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ks.reload()
+			}
+		}
+	}()
+}
+
+// fetch reads JWKS from a file or URL, keys which are not for signatures or
+// not supported are skipped
+func (ks *KeySet) fetch(source string) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = ks.get(source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS from %s error: %w", source, err)
+	}
+
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("parse JWKS from %s error: %w", source, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for i := range jwks.Keys {
+		jwk := &jwks.Keys[i]
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Warnf("skip key %q in JWKS from %s: %v", jwk.Kid, source, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (ks *KeySet) get(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
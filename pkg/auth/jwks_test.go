@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeJWKS(t *testing.T, path string, signers ...*Signer) {
+	t.Helper()
+	jwks := &JWKS{}
+	for _, s := range signers {
+		jwks.Keys = append(jwks.Keys, s.JWKS().Keys...)
+	}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestSigner(t *testing.T, kind, kid string) *Signer {
+	t.Helper()
+	signer, err := NewSigner(genPEM(t, kind), kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestKeySetJWKS(t *testing.T) {
+	rsaSigner := newTestSigner(t, "rsa", "rsa-1")
+	ecSigner := newTestSigner(t, "ec", "ec-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaSigner, ecSigner)
+
+	ks := NewKeySet(nil)
+	assert.Nil(t, ks.AddJWKS(JWKSSource{URL: path, Issuer: "idp"}))
+	for _, signer := range []*Signer{rsaSigner, ecSigner} {
+		token, err := signer.Sign(map[string]any{"sub": "1", "iss": "idp"})
+		assert.Nil(t, err)
+		claims, err := ks.Parse(token)
+		assert.Nil(t, err)
+		assert.Equal(t, "1", claims["sub"])
+	}
+
+	t.Run("unknown kid", func(t *testing.T) {
+		token, err := newTestSigner(t, "rsa", "rsa-2").Sign(map[string]any{"sub": "1", "iss": "idp"})
+		assert.Nil(t, err)
+		_, err = ks.Parse(token)
+		assert.NotNil(t, err)
+	})
+	t.Run("hmac without secret", func(t *testing.T) {
+		token, err := NewHMACSigner([]byte(testSecret)).Sign(map[string]any{"sub": "1", "iss": "idp"})
+		assert.Nil(t, err)
+		_, err = ks.Parse(token)
+		assert.NotNil(t, err)
+	})
+	t.Run("key type mismatch", func(t *testing.T) {
+		// an EdDSA token claiming the kid of the RSA key
+		token, err := newTestSigner(t, "ed25519", "rsa-1").Sign(map[string]any{"sub": "1", "iss": "idp"})
+		assert.Nil(t, err)
+		_, err = ks.Parse(token)
+		assert.NotNil(t, err)
+	})
+	t.Run("issuer mismatch", func(t *testing.T) {
+		for _, claims := range []map[string]any{{"sub": "1"}, {"sub": "1", "iss": "other"}} {
+			token, err := rsaSigner.Sign(claims)
+			assert.Nil(t, err)
+			_, err = ks.Parse(token)
+			assert.NotNil(t, err)
+		}
+	})
+	t.Run("invalid source", func(t *testing.T) {
+		assert.NotNil(t, ks.AddJWKS(JWKSSource{URL: path}))
+		assert.NotNil(t, ks.AddJWKS(JWKSSource{URL: filepath.Join(t.TempDir(), "missing.json"), Issuer: "idp"}))
+		invalid := filepath.Join(t.TempDir(), "invalid.json")
+		assert.Nil(t, os.WriteFile(invalid, []byte("{"), 0o600))
+		assert.NotNil(t, ks.AddJWKS(JWKSSource{URL: invalid, Issuer: "idp"}))
+	})
+}
+
+func TestKeySetRotation(t *testing.T) {
+	var mu sync.Mutex
+	current := newTestSigner(t, "rsa", "key-1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewEncoder(w).Encode(current.JWKS())
+	}))
+	defer srv.Close()
+
+	ks := NewKeySet(nil)
+	assert.Nil(t, ks.AddJWKS(JWKSSource{URL: srv.URL, Issuer: "idp"}))
+	token, err := current.Sign(map[string]any{"sub": "1", "iss": "idp"})
+	assert.Nil(t, err)
+	_, err = ks.Parse(token)
+	assert.Nil(t, err)
+
+	// rotate the key of the identity provider
+	mu.Lock()
+	current = newTestSigner(t, "rsa", "key-2")
+	mu.Unlock()
+	token, err = current.Sign(map[string]any{"sub": "1", "iss": "idp"})
+	assert.Nil(t, err)
+
+	// unknown kids don't reload keys again within the min interval
+	_, err = ks.Parse(token)
+	assert.NotNil(t, err)
+
+	ks.mu.Lock()
+	ks.loadedAt = time.Now().Add(-jwksMinRefreshInterval)
+	ks.mu.Unlock()
+	_, err = ks.Parse(token)
+	assert.Nil(t, err)
+}
+
+func TestHandlerSigner(t *testing.T) {
+	signer := newTestSigner(t, "ec", "")
+	handler, err := NewHandler("sqlite://ci.db", nil, WithSigner(signer), Audience("rest-api"))
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, JWKSPath, http.NoBody)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var jwks JWKS
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&jwks))
+	assert.Equal(t, signer.JWKS(), &jwks)
+
+	authRequest(t, "register", "", `{"username": "signer", "password": "world"}`)
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username": "signer", "password": "world"}`))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var data map[string]any
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&data))
+	token := data["token"].(string)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	assert.Nil(t, err)
+	assert.Equal(t, "ES256", parsed.Header["alg"])
+	assert.Equal(t, DefaultIssuer, parsed.Claims.(jwt.MapClaims)["iss"])
+	assert.Equal(t, "rest-api", parsed.Claims.(jwt.MapClaims)["aud"])
+
+	tests := []struct {
+		name    string
+		options []MiddlewareOption
+		status  int
+	}{
+		{"secret only", nil, http.StatusUnauthorized},
+		{"key set", []MiddlewareOption{WithKeySet(handler.KeySet())}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewMiddleware([]byte(testSecret), tt.options...)(http.HandlerFunc(testHandle))
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set(AuthorizationHeader, "Bearer "+token)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	t.Run("identity provider token", func(t *testing.T) {
+		idp := newTestSigner(t, "rsa", "idp")
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		writeJWKS(t, path, idp)
+		ks := handler.KeySet()
+		assert.Nil(t, ks.AddJWKS(JWKSSource{URL: path, Issuer: "https://idp.example.com", Audience: "rest-api", Provider: "idp"}))
+		h := NewMiddleware(nil, WithKeySet(ks), WithIdentities(handler.Identities()))(http.HandlerFunc(testHandle))
+
+		ctx := context.Background()
+		row, err := handler.db.FetchOne(ctx, "SELECT id FROM auth_users WHERE username = ?", "signer")
+		assert.Nil(t, err)
+		userID := row["id"].(int64)
+		_, err = handler.db.ExecQuery(ctx, createIdentity, "idp", "ext-1", userID, nil, time.Now().Unix())
+		assert.Nil(t, err)
+
+		tests := []struct {
+			name   string
+			claims map[string]any
+			status int
+		}{
+			{"linked subject", map[string]any{"sub": "ext-1", "iss": "https://idp.example.com", "aud": "rest-api"}, http.StatusOK},
+			{"unlinked subject", map[string]any{"sub": "1", "iss": "https://idp.example.com", "aud": "rest-api"}, http.StatusUnauthorized},
+			{"user id claim", map[string]any{"sub": "ext-2", "user_id": 1, "iss": "https://idp.example.com", "aud": "rest-api"}, http.StatusUnauthorized},
+			{"other audience", map[string]any{"sub": "ext-1", "iss": "https://idp.example.com", "aud": "other"}, http.StatusUnauthorized},
+			{"local issuer", map[string]any{"sub": "ext-1", "iss": DefaultIssuer, "aud": "rest-api"}, http.StatusUnauthorized},
+			{"no issuer", map[string]any{"sub": "ext-1", "aud": "rest-api"}, http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			token, err := idp.Sign(tt.claims)
+			assert.Nil(t, err)
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set(AuthorizationHeader, "Bearer "+token)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, tt.name)
+		}
+
+		// the linked user is used, admin and roles claims of the provider are ignored
+		idpToken, err := idp.Sign(map[string]any{
+			"sub": "ext-1", "iss": "https://idp.example.com", "aud": "rest-api",
+			"is_admin": true, "roles": []string{"admin"},
+		})
+		assert.Nil(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set(AuthorizationHeader, "Bearer "+idpToken)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var user map[string]any
+		assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&user))
+		assert.Equal(t, float64(userID), user["id"])
+		assert.Equal(t, "signer", user["username"])
+		assert.Equal(t, false, user["is_admin"])
+
+		// tokens of sources without a provider don't authenticate users
+		noProvider := NewMiddleware(nil, WithKeySet(ks))(http.HandlerFunc(testHandle))
+		req = httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set(AuthorizationHeader, "Bearer "+idpToken)
+		w = httptest.NewRecorder()
+		noProvider.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// local tokens are still accepted along with the identity provider
+		req = httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set(AuthorizationHeader, "Bearer "+token)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// local tokens without the audience are rejected
+		token, err = signer.Sign(map[string]any{"user_id": 1, "iss": DefaultIssuer})
+		assert.Nil(t, err)
+		req = httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set(AuthorizationHeader, "Bearer "+token)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
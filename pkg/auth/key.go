@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Signer signs JWT tokens with a HMAC secret or a private key
+type Signer struct {
+	method jwt.SigningMethod
+	key    any // []byte for HMAC, crypto.Signer for others
+	kid    string
+}
+
+// NewHMACSigner returns a Signer signing tokens with HS256
+func NewHMACSigner(secret []byte) *Signer {
+	return &Signer{method: jwt.SigningMethodHS256, key: secret}
+}
+
+// NewSigner returns a Signer with a PEM encoded private key in PKCS #8,
+// PKCS #1 or SEC 1 form, RSA keys sign with RS256, P-256 keys with ES256 and
+// Ed25519 keys with EdDSA. The key id is derived from the public key if kid
+// is empty
+func NewSigner(pemData []byte, kid string) (*Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM data is found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if k, e := x509.ParsePKCS1PrivateKey(block.Bytes); e == nil {
+			key, err = k, nil
+		} else if k, e := x509.ParseECPrivateKey(block.Bytes); e == nil {
+			key, err = k, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private key error: %w", err)
+	}
+
+	s := &Signer{kid: kid}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s.method, s.key = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve: %s, only P-256 is supported", k.Curve.Params().Name)
+		}
+		s.method, s.key = jwt.SigningMethodES256, k
+	case ed25519.PrivateKey:
+		s.method, s.key = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported private key: %T", key)
+	}
+	if s.kid == "" {
+		der, err := x509.MarshalPKIXPublicKey(s.key.(crypto.Signer).Public())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		s.kid = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+	return s, nil
+}
+
+// Alg returns the signing algorithm, e.g. RS256
+func (s *Signer) Alg() string {
+	return s.method.Alg()
+}
+
+// Sign returns a signed token of the claims, the key id is set in `kid`
+// header for asymmetric keys
+func (s *Signer) Sign(claims map[string]any) (string, error) {
+	token := jwt.NewWithClaims(s.method, jwt.MapClaims(claims))
+	if s.kid != "" {
+		token.Header["kid"] = s.kid
+	}
+	return token.SignedString(s.key)
+}
+
+// publicKey returns the public key, nil for HMAC
+func (s *Signer) publicKey() crypto.PublicKey {
+	if signer, ok := s.key.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+// JWKS returns the public key in JWKS, it's empty for HMAC
+func (s *Signer) JWKS() *JWKS {
+	jwks := &JWKS{Keys: []JWK{}}
+	if key := s.publicKey(); key != nil {
+		jwk, err := newJWK(key, s.kid, s.Alg())
+		if err == nil {
+			jwks.Keys = append(jwks.Keys, *jwk)
+		}
+	}
+	return jwks
+}
+
+// JWK is a JSON Web Key of a public key, see RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+func newJWK(key crypto.PublicKey, kid, alg string) (*JWK, error) {
+	jwk := &JWK{Kid: kid, Use: "sig", Alg: alg}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(k.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty, jwk.Crv = "EC", k.Curve.Params().Name
+		jwk.X = b64.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = b64.EncodeToString(k)
+	default:
+		return nil, fmt.Errorf("unsupported public key: %T", key)
+	}
+	return jwk, nil
+}
+
+// PublicKey converts the JWK to a public key
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x: %w", err)
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func genPEM(t *testing.T, kind string) []byte {
+	t.Helper()
+	var key any
+	var err error
+	switch kind {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ec":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestSigner(t *testing.T) {
+	tests := []struct {
+		kind string
+		alg  string
+		kty  string
+	}{
+		{"rsa", "RS256", "RSA"},
+		{"ec", "ES256", "EC"},
+		{"ed25519", "EdDSA", "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			signer, err := NewSigner(genPEM(t, tt.kind), "")
+			assert.Nil(t, err)
+			assert.Equal(t, tt.alg, signer.Alg())
+			assert.NotEmpty(t, signer.kid)
+
+			jwks := signer.JWKS()
+			assert.Equal(t, 1, len(jwks.Keys))
+			jwk := jwks.Keys[0]
+			assert.Equal(t, tt.kty, jwk.Kty)
+			assert.Equal(t, tt.alg, jwk.Alg)
+			assert.Equal(t, signer.kid, jwk.Kid)
+			key, err := jwk.PublicKey()
+			assert.Nil(t, err)
+			assert.True(t, key.(interface{ Equal(x crypto.PublicKey) bool }).Equal(signer.publicKey()))
+
+			token, err := signer.Sign(map[string]any{"user_id": 1})
+			assert.Nil(t, err)
+			ks := NewKeySet(nil)
+			ks.AddSigner(signer)
+			claims, err := ks.Parse(token)
+			assert.Nil(t, err)
+			assert.Equal(t, float64(1), claims["user_id"])
+		})
+	}
+
+	t.Run("key id", func(t *testing.T) {
+		signer, err := NewSigner(genPEM(t, "rsa"), "my-key")
+		assert.Nil(t, err)
+		assert.Equal(t, "my-key", signer.JWKS().Keys[0].Kid)
+	})
+	t.Run("hmac", func(t *testing.T) {
+		signer := NewHMACSigner([]byte(testSecret))
+		assert.Equal(t, "HS256", signer.Alg())
+		assert.Empty(t, signer.JWKS().Keys)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewSigner([]byte("not a pem"), "")
+		assert.NotNil(t, err)
+		_, err = NewSigner(genPEM(t, "ec384"), "")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+	}{
+		{"unsupported kty", JWK{Kty: "oct"}},
+		{"unsupported curve", JWK{Kty: "EC", Crv: "P-192"}},
+		{"not on curve", JWK{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}},
+		{"short ed25519", JWK{Kty: "OKP", Crv: "Ed25519", X: "AQ"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.jwk.PublicKey()
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -31,6 +31,38 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_sessions")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_identities")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_api_keys")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_roles")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_user_roles")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_column_policies")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_password_resets")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_email_verifications")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// setup auth tables
 	val := testHandler.setup()
@@ -52,10 +52,10 @@ func TestHandlerMiddleware(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	var resData map[string]string
+	var resData map[string]any
 	err = json.Unmarshal(data, &resData)
 	assert.Nil(t, err)
-	token := resData["token"]
+	token := resData["token"].(string)
 
 	t.Run("not authorized", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/test", nil)
@@ -2,7 +2,10 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/rest-go/rest/pkg/log"
@@ -18,19 +21,76 @@ const (
 // Middleware is a type alias for http handler middleware
 type Middleware func(http.Handler) http.Handler
 
+// MiddlewareOption configures the middleware
+type MiddlewareOption func(*middlewareConfig)
+
+type middlewareConfig struct {
+	keySet     *KeySet
+	denylist   *Denylist
+	apiKeys    *APIKeys
+	identities *Identities
+}
+
+// WithIdentities authenticates tokens of JWKS sources as the users linked to
+// their subjects, e.g. NewMiddleware(secret, WithIdentities(handler.Identities()))
+func WithIdentities(identities *Identities) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.identities = identities
+	}
+}
+
+// WithAPIKeys accepts API keys in `Authorization: ApiKey <key>` or
+// `X-API-Key` header, e.g. NewMiddleware(secret, WithAPIKeys(handler.APIKeys()))
+func WithAPIKeys(apiKeys *APIKeys) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.apiKeys = apiKeys
+	}
+}
+
+// WithDenylist denies access tokens of revoked sessions, e.g.
+// NewMiddleware(secret, WithDenylist(handler.Denylist()))
+func WithDenylist(denylist *Denylist) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.denylist = denylist
+	}
+}
+
+// WithKeySet verifies tokens with the key set instead of the secret, e.g.
+// NewMiddleware(secret, WithKeySet(handler.KeySet()))
+func WithKeySet(keySet *KeySet) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.keySet = keySet
+	}
+}
+
 // NewMiddleware create a middleware using provided secret
-func NewMiddleware(secret []byte) Middleware {
+func NewMiddleware(secret []byte, options ...MiddlewareOption) Middleware {
+	config := &middlewareConfig{}
+	for _, option := range options {
+		option(config)
+	}
+	if config.keySet == nil {
+		config.keySet = NewKeySet(secret)
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := &User{}
-			tokenString := strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer ")
-			if tokenString != "" {
-				data, err := ParseJWTToken(secret, tokenString)
+			authorization := r.Header.Get(AuthorizationHeader)
+			apiKey := r.Header.Get(APIKeyHeader)
+			if strings.HasPrefix(authorization, "ApiKey ") {
+				apiKey = strings.TrimPrefix(authorization, "ApiKey ")
+			}
+			if apiKey != "" && config.apiKeys != nil {
+				u, err := config.apiKeys.Authenticate(r.Context(), apiKey)
 				if err == nil {
-					user = &User{ID: int64(data["user_id"].(float64))}
-					if isAdmin, ok := data["is_admin"]; ok {
-						user.IsAdmin = isAdmin.(bool)
-					}
+					user = u
+				} else {
+					log.Warn("authenticate api key with error: ", err)
+				}
+			} else if tokenString := strings.TrimPrefix(authorization, "Bearer "); tokenString != "" {
+				u, err := config.parse(r.Context(), tokenString)
+				if err == nil {
+					user = u
 				} else {
 					log.Warn("parse jwt token with error: ", err)
 				}
@@ -45,6 +105,63 @@ func NewMiddleware(secret []byte) Middleware {
 	}
 }
 
+// parse verifies the token and returns the user of it, the user id is read
+// from `user_id` claim or a numeric `sub` claim of tokens of the secret or
+// signers, the issuer and audience are checked by the key set
+func (c *middlewareConfig) parse(ctx context.Context, tokenString string) (*User, error) {
+	claims, source, err := c.keySet.ParseSource(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		return c.external(ctx, source, claims)
+	}
+	if c.isRevoked(claims) {
+		return nil, fmt.Errorf("session is revoked: %v", claims["sid"])
+	}
+
+	var id int64
+	switch v := claims["user_id"].(type) {
+	case float64:
+		id = int64(v)
+	case string:
+		id, err = strconv.ParseInt(v, 10, 64)
+	default:
+		sub, _ := claims["sub"].(string)
+		id, err = strconv.ParseInt(sub, 10, 64)
+	}
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("no user id in token, user_id: %v, sub: %v", claims["user_id"], claims["sub"])
+	}
+	user := &User{ID: id}
+	user.IsAdmin, _ = claims["is_admin"].(bool)
+	user.Roles = rolesFromClaims(claims)
+	user.Claims = claims
+	return user, nil
+}
+
+// external returns the user linked to the subject of a token of a JWKS
+// source, user ids, admin and roles in claims of other issuers are not trusted
+func (c *middlewareConfig) external(ctx context.Context, source *JWKSSource, claims map[string]any) (*User, error) {
+	if source.Provider == "" || c.identities == nil {
+		return nil, fmt.Errorf("no identity provider for JWKS %s", source.URL)
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("no subject in token")
+	}
+	return c.identities.Authenticate(ctx, source.Provider, sub)
+}
+
+// isRevoked returns whether the session of token claims is revoked
+func (c *middlewareConfig) isRevoked(claims map[string]any) bool {
+	if c.denylist == nil {
+		return false
+	}
+	sid, ok := claims["sid"].(string)
+	return ok && c.denylist.IsRevoked(sid)
+}
+
 // GetUser return the user in request context
 func GetUser(r *http.Request) *User {
 	v := r.Context().Value(AuthUserKey)
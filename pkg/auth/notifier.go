@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// Message is a message sent to a user, To is the address of the user
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier sends messages like password reset tokens to users
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// SMTPNotifier sends messages by email
+type SMTPNotifier struct {
+	// Addr is the address of the SMTP server, e.g. `smtp.example.com:587`
+	Addr string
+	From string
+	// Username and Password are used for PLAIN auth if Username is set
+	Username string
+	Password string
+}
+
+// Notify implements Notifier, the context is not supported by net/smtp
+func (n *SMTPNotifier) Notify(_ context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid message to %q", msg.To)
+	}
+	var a smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return err
+		}
+		a = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	data := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n"+
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		n.From, msg.To, msg.Subject, msg.Body)
+	return smtp.SendMail(n.Addr, a, n.From, []string{msg.To}, []byte(data))
+}
+
+// MemoryNotifier keeps messages in memory, it's useful in tests
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Notify implements Notifier
+func (n *MemoryNotifier) Notify(_ context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent
+func (n *MemoryNotifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Message{}, n.messages...)
+}
@@ -0,0 +1,549 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the table linking external identities to users
+	IdentityTableName = "auth_identities"
+
+	// oauthPathPrefix is the prefix of `/auth/oauth/{provider}/login` and
+	// `/auth/oauth/{provider}/callback`
+	oauthPathPrefix = "/auth/oauth/"
+	// oauthStateCookie keeps the state, nonce and PKCE verifier of a login
+	// between the redirect to the provider and the callback
+	oauthStateCookie = "rest_oauth_state"
+	oauthStateTTL    = 10 * time.Minute
+	oauthTimeout     = 10 * time.Second
+	// maxUsernameLength is the length of auth_users.username
+	maxUsernameLength = 32
+
+	createIdentityTable = `
+	CREATE TABLE auth_identities (
+		id %s,
+		provider VARCHAR(64) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id BIGINT NOT NULL,
+		email VARCHAR(255),
+		created_at BIGINT NOT NULL,
+		UNIQUE (provider, subject)
+	)
+	`
+	createIdentity = `
+		INSERT INTO auth_identities (provider, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	queryIdentity     = `SELECT user_id FROM auth_identities WHERE provider = ? AND subject = ?`
+	queryIdentityUser = `
+		SELECT u.id, u.username, u.is_admin
+		FROM auth_identities i JOIN auth_users u ON u.id = i.user_id
+		WHERE i.provider = ? AND i.subject = ?
+	`
+	provisionEmail     = `UPDATE auth_users SET email = ?, verified_at = ? WHERE id = ?`
+	queryVerifiedEmail = `SELECT id FROM auth_users WHERE email = ? AND verified_at IS NOT NULL`
+	identitiesPolicy   = "identities are limited to admin user(to deny user to link others' identities)"
+)
+
+var (
+	errIdentityNotLinked = errors.New("identity is not linked to any user")
+	errIdentityLinked    = errors.New("identity is linked to another user")
+	errNoUsername        = errors.New("no available username for the identity")
+)
+
+// OAuthProvider configures an OpenID Connect provider to login with, its
+// endpoints and keys are discovered from
+// `<issuer>/.well-known/openid-configuration`
+type OAuthProvider struct {
+	// Name is used in the paths, e.g. /auth/oauth/google/login
+	Name         string
+	Issuer       string
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is the full url of the callback endpoint registered in the
+	// provider, e.g. https://example.com/auth/oauth/google/callback
+	RedirectURL string `yaml:"redirect_url"`
+	// Scopes are requested besides `openid`, `email` and `profile` by default
+	Scopes []string
+	// AutoProvision creates a user for an identity which isn't linked to any
+	// user, otherwise identities must be linked by logged in users
+	AutoProvision bool `yaml:"auto_provision"`
+}
+
+// OAuthProviders enables logging in with the OpenID Connect providers
+func OAuthProviders(providers ...OAuthProvider) HandlerOption {
+	return func(h *Handler) {
+		for i := range providers {
+			p := &oauthProvider{OAuthProvider: providers[i], keySet: NewKeySet(nil)}
+			h.providers[p.Name] = p
+		}
+	}
+}
+
+// oauthProvider is an OAuthProvider with its discovered endpoints
+type oauthProvider struct {
+	OAuthProvider
+
+	mu            sync.Mutex
+	authURL       string
+	tokenURL      string
+	keySet        *KeySet
+	discoveryDone bool
+}
+
+// discover loads the endpoints and keys of the provider once, it's retried
+// on the next login if it fails
+func (p *oauthProvider) discover(ctx context.Context, client *http.Client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discoveryDone {
+		return nil
+	}
+
+	configURL := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("discover %s error: unexpected status %s", p.Name, res.Status)
+	}
+	var config struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&config); err != nil {
+		return fmt.Errorf("discover %s error: %w", p.Name, err)
+	}
+	if config.Issuer != p.Issuer {
+		return fmt.Errorf("discover %s error: issuer %q doesn't match", p.Name, config.Issuer)
+	}
+	if err := p.keySet.AddJWKS(JWKSSource{URL: config.JWKSURI, Issuer: p.Issuer, Audience: p.ClientID}); err != nil {
+		return err
+	}
+	p.authURL, p.tokenURL = config.AuthorizationEndpoint, config.TokenEndpoint
+	p.discoveryDone = true
+	return nil
+}
+
+// oauthState is saved in a signed cookie during a login
+type oauthState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// LinkUserID is the logged in user to link the identity to
+	LinkUserID int64 `json:"link_user_id,omitempty"`
+}
+
+// identity is the verified user info in an ID token
+type identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// serveOAuth serves `/auth/oauth/{provider}/login` and
+// `/auth/oauth/{provider}/callback`
+func (h *Handler) serveOAuth(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, oauthPathPrefix), "/")
+	p, ok := h.providers[name]
+	if !ok {
+		j.Write(w, &j.Response{Code: http.StatusNotFound, Msg: fmt.Sprintf("oauth provider not found: %s", name)})
+		return
+	}
+	if r.Method != http.MethodGet {
+		j.Write(w, &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		})
+		return
+	}
+
+	switch action {
+	case "login":
+		h.oauthLogin(w, r, p)
+	case "callback":
+		j.Write(w, h.oauthCallback(w, r, p))
+	default:
+		j.Write(w, &j.Response{Code: http.StatusBadRequest, Msg: "action not supported"})
+	}
+}
+
+// oauthLogin redirects the user to the provider with a PKCE challenge, the
+// identity is linked to the user if the request has a valid access token
+func (h *Handler) oauthLogin(w http.ResponseWriter, r *http.Request, p *oauthProvider) {
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	if err := p.discover(ctx, h.client); err != nil {
+		log.Errorf("discover oauth provider error: %v", err)
+		j.Write(w, &j.Response{Code: http.StatusBadGateway, Msg: "failed to discover oauth provider"})
+		return
+	}
+
+	state := &oauthState{Provider: p.Name}
+	for _, s := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		v, err := randomString(32)
+		if err != nil {
+			j.Write(w, j.ErrResponse(err))
+			return
+		}
+		*s = v
+	}
+	claims, err := h.bearerClaims(r)
+	if err != nil {
+		j.Write(w, &j.Response{Code: http.StatusUnauthorized, Msg: fmt.Sprintf("invalid token, %v", err)})
+		return
+	}
+	if userID, ok := claims["user_id"].(float64); ok {
+		state.LinkUserID = int64(userID)
+	}
+	cookie, err := h.sign(map[string]any{
+		"oauth": state,
+		"exp":   time.Now().Add(oauthStateTTL).Unix(),
+	})
+	if err != nil {
+		j.Write(w, j.ErrResponse(err))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    cookie,
+		Path:     "/",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(p.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	scopes := append([]string{"openid", "email", "profile"}, p.Scopes...)
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.authURL+sep+query.Encode(), http.StatusFound)
+}
+
+// oauthCallback exchanges the code for an ID token, and returns tokens of the
+// user linked to the identity
+func (h *Handler) oauthCallback(w http.ResponseWriter, r *http.Request, p *oauthProvider) any {
+	state, err := h.readOAuthState(r, p)
+	// the state is single use
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	if err != nil {
+		return &j.Response{Code: http.StatusBadRequest, Msg: err.Error()}
+	}
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  fmt.Sprintf("oauth error: %s %s", e, query.Get("error_description")),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	if err := p.discover(ctx, h.client); err != nil {
+		log.Errorf("discover oauth provider error: %v", err)
+		return &j.Response{Code: http.StatusBadGateway, Msg: "failed to discover oauth provider"}
+	}
+	id, err := h.exchange(ctx, p, query.Get("code"), state)
+	if err != nil {
+		log.Warnf("oauth exchange error: %v", err)
+		return &j.Response{Code: http.StatusUnauthorized, Msg: fmt.Sprintf("failed to verify identity, %v", err)}
+	}
+
+	userID, err := h.linkIdentity(ctx, p, id, state.LinkUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errIdentityNotLinked):
+			return &j.Response{Code: http.StatusForbidden, Msg: err.Error()}
+		case errors.Is(err, errIdentityLinked), errors.Is(err, errNoUsername):
+			return &j.Response{Code: http.StatusConflict, Msg: err.Error()}
+		}
+		log.Errorf("link identity error: %v", err)
+		return j.ErrResponse(err)
+	}
+	row, err := h.db.FetchOne(ctx, queryUserByID, userID)
+	if err != nil {
+		log.Errorf("fetch user error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if res := h.checkVerified(ctx, userID); res != nil {
+		return res
+	}
+	sid, refreshToken, err := h.createSession(ctx, userID)
+	if err != nil {
+		log.Errorf("create session error: %v", err)
+		return j.ErrResponse(err)
+	}
+	user := &User{ID: userID, Username: row["username"].(string), IsAdmin: row["is_admin"].(bool)}
+	return h.tokens(user, sid, refreshToken)
+}
+
+// readOAuthState verifies the state cookie against the state parameter
+func (h *Handler) readOAuthState(r *http.Request, p *oauthProvider) (*oauthState, error) {
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return nil, errors.New("oauth state cookie is missing")
+	}
+	claims, err := h.keySet.Parse(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oauth state, %w", err)
+	}
+	data, err := json.Marshal(claims["oauth"])
+	if err != nil {
+		return nil, err
+	}
+	state := &oauthState{}
+	if err := json.Unmarshal(data, state); err != nil || state.State == "" {
+		return nil, errors.New("invalid oauth state")
+	}
+	if state.Provider != p.Name || state.State != r.URL.Query().Get("state") {
+		return nil, errors.New("oauth state doesn't match")
+	}
+	return state, nil
+}
+
+// exchange redeems the code with the PKCE verifier and verifies the ID token
+func (h *Handler) exchange(ctx context.Context, p *oauthProvider, code string, state *oauthState) (*identity, error) {
+	if code == "" {
+		return nil, errors.New("code is missing")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {state.Verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decode token response error: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || token.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint error: %s %s", res.Status, token.Error)
+	}
+
+	claims, err := p.keySet.Parse(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	mapClaims := jwt.MapClaims(claims)
+	if !mapClaims.VerifyIssuer(p.Issuer, true) || !mapClaims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("invalid issuer or audience of ID token")
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != state.Nonce {
+		return nil, errors.New("nonce of ID token doesn't match")
+	}
+	id := &identity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	if id.Subject == "" {
+		return nil, errors.New("no subject in ID token")
+	}
+	return id, nil
+}
+
+// Identities authenticates tokens of identity providers as the local users
+// linked to their subjects in `auth_identities`
+type Identities struct {
+	db *sql.DB
+}
+
+// Authenticate returns the user linked to the subject of the provider
+func (ids *Identities) Authenticate(ctx context.Context, provider, subject string) (*User, error) {
+	row, err := ids.db.FetchOne(ctx, queryIdentityUser, provider, subject)
+	if err != nil {
+		var dbErr sql.Error
+		if errors.As(err, &dbErr) && dbErr.Code == http.StatusNotFound {
+			return nil, errIdentityNotLinked
+		}
+		return nil, err
+	}
+	user := &User{
+		ID:       row["id"].(int64),
+		Username: row["username"].(string),
+		IsAdmin:  row["is_admin"].(bool),
+	}
+	if user.Roles, err = fetchRoles(ctx, ids.db, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkIdentity returns the user linked to the identity, the identity is linked
+// to linkUserID or a new user if auto-provisioning is enabled
+func (h *Handler) linkIdentity(ctx context.Context, p *oauthProvider, id *identity, linkUserID int64) (int64, error) {
+	row, err := h.db.FetchOne(ctx, queryIdentity, p.Name, id.Subject)
+	if err == nil {
+		userID := row["user_id"].(int64)
+		if linkUserID != 0 && linkUserID != userID {
+			return 0, errIdentityLinked
+		}
+		return userID, nil
+	}
+	var dbErr sql.Error
+	if !errors.As(err, &dbErr) || dbErr.Code != http.StatusNotFound {
+		return 0, err
+	}
+
+	userID := linkUserID
+	if userID == 0 {
+		if !p.AutoProvision {
+			return 0, errIdentityNotLinked
+		}
+		if userID, err = h.provisionUser(ctx, p, id); err != nil {
+			return 0, err
+		}
+	}
+	var email any
+	if id.Email != "" {
+		email = id.Email
+	}
+	if _, err := h.db.ExecQuery(ctx, createIdentity, p.Name, id.Subject, userID, email, time.Now().Unix()); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// provisionUser creates a user with a random password for the identity, the
+// username is the verified email if it's not taken, otherwise it's derived
+// from the provider and subject. The email verified by the provider is set as
+// the verified email of the user unless another user has verified it
+func (h *Handler) provisionUser(ctx context.Context, p *oauthProvider, id *identity) (int64, error) {
+	var email string
+	if id.EmailVerified && id.Email != "" && validateProfile(id.Email, "") == nil {
+		_, err := h.db.FetchOne(ctx, queryVerifiedEmail, id.Email)
+		var dbErr sql.Error
+		switch {
+		case err == nil:
+			log.Warnf("email of identity of %s is verified by another user", p.Name)
+		case errors.As(err, &dbErr) && dbErr.Code == http.StatusNotFound:
+			email = id.Email
+		default:
+			return 0, err
+		}
+	}
+	var candidates []string
+	if email != "" {
+		candidates = append(candidates, email)
+	}
+	sum := sha256.Sum256([]byte(id.Subject))
+	candidates = append(candidates, fmt.Sprintf("%.15s_%s", p.Name, hex.EncodeToString(sum[:8])))
+
+	password, err := genPasswd(32)
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	for _, username := range candidates {
+		if len(username) > maxUsernameLength {
+			continue
+		}
+		_, err := h.db.ExecQuery(ctx, createUser, username, hashedPassword)
+		var dbErr sql.Error
+		if errors.As(err, &dbErr) && dbErr.ErrCode == sql.ErrCodeUniqueViolation {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		log.Infof("provision user %s for identity of %s", username, p.Name)
+		row, err := h.db.FetchOne(ctx, queryUser, username)
+		if err != nil {
+			return 0, err
+		}
+		userID := row["id"].(int64)
+		if email != "" {
+			if _, err := h.db.ExecQuery(ctx, provisionEmail, email, time.Now().Unix(), userID); err != nil {
+				return 0, err
+			}
+		}
+		return userID, nil
+	}
+	return 0, errNoUsername
+}
+
+// setupIdentities creates `auth_identities` table and the policy limiting it
+// to admin user, it's skipped if the table exists
+func setupIdentities(db *sql.DB) error {
+	if tableExists(db, IdentityTableName) {
+		return nil
+	}
+	log.Info("create identities table")
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	_, dbErr := db.ExecQuery(ctx, fmt.Sprintf(createIdentityTable, db.Dialect().PrimaryKey()))
+	if dbErr != nil {
+		return dbErr
+	}
+	_, dbErr = db.ExecQuery(ctx, createInternalPolicy, identitiesPolicy, IdentityTableName, "all", "auth_user.is_admin")
+	return dbErr
+}
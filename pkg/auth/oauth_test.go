@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOIDC is an in-process OpenID Connect provider, codes are issued by
+// authorize for the subject and redeemed by the token endpoint
+type fakeOIDC struct {
+	*httptest.Server
+	t      *testing.T
+	signer *Signer
+
+	mu     sync.Mutex
+	codes  map[string]url.Values // code => authorize query
+	claims map[string]any        // extra claims of ID tokens
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	f := &fakeOIDC{t: t, signer: newTestSigner(t, "rsa", "idp"), codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(f.signer.JWKS())
+	})
+	mux.HandleFunc("/token", f.token)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// authorize simulates the user consenting on the provider, it returns the
+// code for the authorize url
+func (f *fakeOIDC) authorize(authURL, sub string, claims map[string]any) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("sub", sub)
+	code, err := randomString(16)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.codes[code] = query
+	f.claims = claims
+	return code
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = r.ParseForm()
+	query, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("client_secret") != "client-secret" ||
+		r.PostForm.Get("redirect_uri") != query.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != query.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims := map[string]any{
+		"iss":   f.URL,
+		"aud":   query.Get("client_id"),
+		"sub":   query.Get("sub"),
+		"nonce": query.Get("nonce"),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range f.claims {
+		claims[k] = v
+	}
+	idToken, err := f.signer.Sign(claims)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+func TestHandlerOAuth(t *testing.T) {
+	idp := newFakeOIDC(t)
+	provider := OAuthProvider{
+		Issuer:       idp.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://localhost/auth/oauth/idp/callback",
+	}
+	linked, provisioned := provider, provider
+	linked.Name = "idp"
+	provisioned.Name, provisioned.AutoProvision = "auto", true
+	handler, err := NewHandler("sqlite://ci.db", []byte(testSecret), OAuthProviders(linked, provisioned))
+	assert.Nil(t, err)
+
+	// login redirects to the provider and returns the state cookie
+	login := func(name, token string) (string, *http.Cookie) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/oauth/"+name+"/login", http.NoBody)
+		if token != "" {
+			req.Header.Set(AuthorizationHeader, "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+		cookies := w.Result().Cookies()
+		assert.Equal(t, 1, len(cookies))
+		return w.Header().Get("Location"), cookies[0]
+	}
+	callback := func(name, state, code string, cookie *http.Cookie) (int, map[string]any) {
+		query := url.Values{"state": {state}, "code": {code}}
+		req := httptest.NewRequest(http.MethodGet, "/auth/oauth/"+name+"/callback?"+query.Encode(), http.NoBody)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var data map[string]any
+		assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&data))
+		return w.Code, data
+	}
+	stateOf := func(authURL string) string {
+		u, err := url.Parse(authURL)
+		assert.Nil(t, err)
+		return u.Query().Get("state")
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		authURL, cookie := login("idp", "")
+		u, err := url.Parse(authURL)
+		assert.Nil(t, err)
+		assert.Equal(t, idp.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+		query := u.Query()
+		assert.Equal(t, "code", query.Get("response_type"))
+		assert.Equal(t, "S256", query.Get("code_challenge_method"))
+		assert.Equal(t, "openid email profile", query.Get("scope"))
+		assert.NotEmpty(t, query.Get("nonce"))
+		assert.True(t, cookie.HttpOnly)
+	})
+
+	t.Run("not linked", func(t *testing.T) {
+		authURL, cookie := login("idp", "")
+		code := idp.authorize(authURL, "not-linked", nil)
+		status, _ := callback("idp", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("link and login", func(t *testing.T) {
+		authRequest(t, "register", "", `{"username": "oauth", "password": "world"}`)
+		_, data := authRequest(t, "login", "", `{"username": "oauth", "password": "world"}`)
+		token := data["token"].(string)
+		claims, err := ParseJWTToken([]byte(testSecret), token)
+		assert.Nil(t, err)
+
+		// link the identity by logging in with the access token
+		authURL, cookie := login("idp", token)
+		code := idp.authorize(authURL, "linked-sub", nil)
+		status, data := callback("idp", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusOK, status)
+		assert.NotEmpty(t, data["refresh_token"])
+
+		// login with the linked identity
+		authURL, cookie = login("idp", "")
+		code = idp.authorize(authURL, "linked-sub", nil)
+		status, data = callback("idp", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusOK, status)
+		linkedClaims, err := ParseJWTToken([]byte(testSecret), data["token"].(string))
+		assert.Nil(t, err)
+		assert.Equal(t, claims["user_id"], linkedClaims["user_id"])
+	})
+
+	t.Run("auto provision", func(t *testing.T) {
+		extra := map[string]any{"email": "auto@example.com", "email_verified": true}
+		authURL, cookie := login("auto", "")
+		code := idp.authorize(authURL, "auto-sub", extra)
+		status, data := callback("auto", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusOK, status)
+		claims, err := ParseJWTToken([]byte(testSecret), data["token"].(string))
+		assert.Nil(t, err)
+		row, err := handler.db.FetchOne(context.Background(), queryUserByID, int64(claims["user_id"].(float64)))
+		assert.Nil(t, err)
+		assert.Equal(t, "auto@example.com", row["username"])
+
+		// the same username of another identity falls back to a derived one,
+		// and the email verified by another user isn't set
+		authURL, cookie = login("auto", "")
+		code = idp.authorize(authURL, "auto-sub-2", extra)
+		status, data = callback("auto", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusOK, status)
+		claims2, err := ParseJWTToken([]byte(testSecret), data["token"].(string))
+		assert.Nil(t, err)
+		assert.NotEqual(t, claims["user_id"], claims2["user_id"])
+		profile, err := handler.fetchProfile(context.Background(), int64(claims2["user_id"].(float64)))
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(profile.Username, "auto_"))
+		assert.Empty(t, profile.Email)
+		assert.Zero(t, profile.VerifiedAt)
+
+		// preferred usernames chosen at the provider are not used
+		extra = map[string]any{"preferred_username": "preferred"}
+		authURL, cookie = login("auto", "")
+		code = idp.authorize(authURL, "auto-sub-3", extra)
+		status, data = callback("auto", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusOK, status)
+		claims3, err := ParseJWTToken([]byte(testSecret), data["token"].(string))
+		assert.Nil(t, err)
+		row, err = handler.db.FetchOne(context.Background(), queryUserByID, int64(claims3["user_id"].(float64)))
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(row["username"].(string), "auto_"))
+	})
+
+	t.Run("verification is required for oauth login", func(t *testing.T) {
+		handler.requireVerification = true
+		defer func() { handler.requireVerification = false }()
+
+		// the email verified by the provider is verified
+		extra := map[string]any{"email": "verified@example.com", "email_verified": true}
+		authURL, cookie := login("auto", "")
+		code := idp.authorize(authURL, "verified-sub", extra)
+		status, data := callback("auto", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusOK, status)
+		claims, err := ParseJWTToken([]byte(testSecret), data["token"].(string))
+		assert.Nil(t, err)
+		profile, err := handler.fetchProfile(context.Background(), int64(claims["user_id"].(float64)))
+		assert.Nil(t, err)
+		assert.Equal(t, "verified@example.com", profile.Email)
+		assert.NotZero(t, profile.VerifiedAt)
+
+		extra = map[string]any{"email": "unverified@example.com", "email_verified": false}
+		authURL, cookie = login("auto", "")
+		code = idp.authorize(authURL, "unverified-sub", extra)
+		status, data = callback("auto", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, "email is not verified", data["msg"])
+	})
+
+	t.Run("invalid callback", func(t *testing.T) {
+		authURL, cookie := login("auto", "")
+		code := idp.authorize(authURL, "invalid-sub", nil)
+
+		status, _ := callback("auto", stateOf(authURL), code, nil)
+		assert.Equal(t, http.StatusBadRequest, status, "no cookie")
+		status, _ = callback("auto", "other-state", code, cookie)
+		assert.Equal(t, http.StatusBadRequest, status, "state mismatch")
+		status, _ = callback("idp", stateOf(authURL), code, cookie)
+		assert.Equal(t, http.StatusBadRequest, status, "provider mismatch")
+
+		// the code is bound to the PKCE challenge of the first login
+		authURL2, cookie2 := login("auto", "")
+		status, _ = callback("auto", stateOf(authURL2), code, cookie2)
+		assert.Equal(t, http.StatusUnauthorized, status, "verifier mismatch")
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/oauth/unknown/login", http.NoBody)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// The name of the password resets table
+	PasswordResetTableName = "auth_password_resets"
+
+	// DefaultPasswordResetTTL is the lifetime of password reset tokens
+	DefaultPasswordResetTTL = time.Hour
+
+	// times are unix seconds to be portable across databases
+	createPasswordResetTable = `
+	CREATE TABLE auth_password_resets (
+		id %s,
+		user_id BIGINT NOT NULL,
+		token_hash VARCHAR(64) UNIQUE NOT NULL,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		used_at BIGINT
+	)
+	`
+	createPasswordReset = `
+		INSERT INTO auth_password_resets (user_id, token_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+	// resetting the password uses up the other tokens of the user
+	expirePasswordResets = `UPDATE auth_password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`
+	usePasswordReset     = `
+		UPDATE auth_password_resets SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`
+	queryPasswordReset   = `SELECT user_id FROM auth_password_resets WHERE token_hash = ?`
+	queryPassword        = `SELECT password FROM auth_users WHERE id = ?`
+	updatePassword       = `UPDATE auth_users SET password = ? WHERE id = ?`
+	passwordResetsPolicy = "password resets are limited to admin user(to deny user to read reset tokens)"
+)
+
+type passwordData struct {
+	Username    string `json:"username"`
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+	Token       string `json:"token"`
+}
+
+// setupPasswordResets creates `auth_password_resets` table and the policy
+// limiting it to admin user, it's skipped if the table exists
+func setupPasswordResets(db *sql.DB) error {
+	if tableExists(db, PasswordResetTableName) {
+		return nil
+	}
+	log.Info("create password resets table")
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	_, dbErr := db.ExecQuery(ctx, fmt.Sprintf(createPasswordResetTable, db.Dialect().PrimaryKey()))
+	if dbErr != nil {
+		return dbErr
+	}
+	_, dbErr = db.ExecQuery(ctx, createInternalPolicy, passwordResetsPolicy, PasswordResetTableName, "all", "auth_user.is_admin")
+	return dbErr
+}
+
+// changePassword changes the password of the user of the access token, the
+// old password is required and other sessions of the user are revoked
+func (h *Handler) changePassword(r *http.Request) any {
+	claims, err := h.bearerClaims(r)
+	userID, _ := claims["user_id"].(float64)
+	if err != nil || userID == 0 {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "a valid access token is required",
+		}
+	}
+	var data passwordData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.NewPassword == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "old_password and new_password are required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	row, err := h.db.FetchOne(ctx, queryPassword, int64(userID))
+	if err != nil {
+		log.Errorf("fetch user error: %v", err)
+		return j.ErrResponse(err)
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(row["password"].(string)), []byte(data.OldPassword))
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "old password doesn't match",
+		}
+	}
+	sid, _ := claims["sid"].(string)
+	if err := h.setPassword(ctx, int64(userID), data.NewPassword, sid); err != nil {
+		log.Errorf("change password error: %v", err)
+		return j.ErrResponse(err)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// requestPasswordReset sends a single-use reset token to the verified email
+// of the user, the token is sent in the background so that neither the
+// response nor the time of it tells whether the user exists, has a verified
+// email or the token is sent
+func (h *Handler) requestPasswordReset(r *http.Request) any {
+	if h.notifier == nil {
+		return &j.Response{
+			Code: http.StatusNotImplemented,
+			Msg:  "password reset is not enabled",
+		}
+	}
+	var data passwordData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Username == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "username is required",
+		}
+	}
+	go h.sendPasswordReset(h.notifier, data.Username)
+	return &j.Response{Code: http.StatusOK, Msg: "a reset token is sent if the user exists"}
+}
+
+// sendPasswordReset creates a reset token of the user and sends it by the
+// notifier, failures are only logged to tell nothing about the user. Pending
+// tokens of the user are kept, so requests of others can't cancel them
+func (h *Handler) sendPasswordReset(notifier Notifier, username string) {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	row, err := h.db.FetchOne(ctx, queryUser, username)
+	if err != nil {
+		var dbErr sql.Error
+		if !errors.As(err, &dbErr) || dbErr.Code != http.StatusNotFound {
+			log.Errorf("fetch user error: %v", err)
+		}
+		return
+	}
+	userID := row["id"].(int64)
+	profile, err := h.fetchProfile(ctx, userID)
+	if err != nil {
+		log.Errorf("fetch profile error: %v", err)
+		return
+	}
+	if profile.Email == "" || profile.VerifiedAt == 0 {
+		// unverified emails may belong to others
+		log.Infof("skip password reset of user %d without verified email", userID)
+		return
+	}
+	token, err := randomString(32)
+	if err != nil {
+		log.Errorf("generate password reset token error: %v", err)
+		return
+	}
+	now := time.Now()
+	_, err = h.db.ExecQuery(ctx, createPasswordReset,
+		userID, hashToken(token), now.Unix(), now.Add(h.passwordResetTTL).Unix())
+	if err != nil {
+		log.Errorf("create password reset error: %v", err)
+		return
+	}
+	err = notifier.Notify(ctx, Message{
+		To:      profile.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use the token to reset your password in %s, ignore it if you didn't ask for it.\r\n\r\n%s",
+			h.passwordResetTTL, token),
+	})
+	if err != nil {
+		log.Errorf("send password reset error: %v", err)
+	}
+}
+
+// confirmPasswordReset sets a new password with a reset token, all the tokens
+// of the user are used up and all sessions of the user are revoked
+func (h *Handler) confirmPasswordReset(r *http.Request) any {
+	var data passwordData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Token == "" || data.NewPassword == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "token and new_password are required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	now := time.Now().Unix()
+	hashed := hashToken(data.Token)
+	rows, err := h.db.ExecQuery(ctx, usePasswordReset, now, hashed, now)
+	if err != nil {
+		log.Errorf("use password reset error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if rows == 0 {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "invalid or expired reset token",
+		}
+	}
+	row, err := h.db.FetchOne(ctx, queryPasswordReset, hashed)
+	if err != nil {
+		log.Errorf("fetch password reset error: %v", err)
+		return j.ErrResponse(err)
+	}
+	userID := row["user_id"].(int64)
+	if err := h.setPassword(ctx, userID, data.NewPassword, ""); err != nil {
+		log.Errorf("reset password error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if _, err := h.db.ExecQuery(ctx, expirePasswordResets, now, userID); err != nil {
+		log.Errorf("expire password resets error: %v", err)
+		return j.ErrResponse(err)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// setPassword updates the password of the user and revokes the sessions
+// except keep
+func (h *Handler) setPassword(ctx context.Context, userID int64, password, keep string) error {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := h.db.ExecQuery(ctx, updatePassword, hashedPassword, userID); err != nil {
+		return err
+	}
+	return h.revokeUserSessions(ctx, userID, keep)
+}
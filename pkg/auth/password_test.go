@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangePassword(t *testing.T) {
+	authRequest(t, "register", "", `{"username": "change", "password": "old"}`)
+	_, other := authRequest(t, "login", "", `{"username": "change", "password": "old"}`)
+	_, tokens := authRequest(t, "login", "", `{"username": "change", "password": "old"}`)
+	token := tokens["token"].(string)
+
+	code, _ := authRequest(t, "password/change", "", `{"old_password": "old", "new_password": "new"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = authRequest(t, "password/change", token, `{"old_password": "wrong", "new_password": "new"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = authRequest(t, "password/change", token, `{"old_password": "old"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = authRequest(t, "password/change", token, `{"old_password": "old", "new_password": "new"}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = authRequest(t, "login", "", `{"username": "change", "password": "old"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = authRequest(t, "login", "", `{"username": "change", "password": "new"}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	// other sessions are revoked but the current one
+	code, _ = authRequest(t, "refresh", "", fmt.Sprintf(`{"refresh_token": %q}`, other["refresh_token"]))
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = authRequest(t, "refresh", "", fmt.Sprintf(`{"refresh_token": %q}`, tokens["refresh_token"]))
+	assert.Equal(t, http.StatusOK, code)
+}
+
+//nolint:funlen
+func TestPasswordReset(t *testing.T) {
+	code, _ := authRequest(t, "password/reset/request", "", `{"username": "reset"}`)
+	assert.Equal(t, http.StatusNotImplemented, code)
+
+	notifier := &MemoryNotifier{}
+	testHandler.notifier = notifier
+	defer func() { testHandler.notifier = nil }()
+	authRequest(t, "register", "", `{"username": "reset", "password": "old", "email": "reset@example.com"}`)
+	_, err := testHandler.db.ExecQuery(context.Background(),
+		"UPDATE auth_users SET verified_at = 1 WHERE username = ?", "reset")
+	assert.Nil(t, err)
+	_, session := authRequest(t, "login", "", `{"username": "reset", "password": "old"}`)
+
+	resetToken := func() string {
+		sent := len(notifier.Messages())
+		code, data := authRequest(t, "password/reset/request", "", `{"username": "reset"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "a reset token is sent if the user exists", data["msg"])
+		// tokens are sent in the background
+		assert.Eventually(t, func() bool { return len(notifier.Messages()) > sent }, time.Second, 10*time.Millisecond)
+		messages := notifier.Messages()
+		msg := messages[len(messages)-1]
+		assert.Equal(t, "reset@example.com", msg.To)
+		lines := strings.Split(msg.Body, "\n")
+		return lines[len(lines)-1]
+	}
+	confirm := func(token, password string) int {
+		code, _ := authRequest(t, "password/reset/confirm", "",
+			fmt.Sprintf(`{"token": %q, "new_password": %q}`, token, password))
+		return code
+	}
+
+	t.Run("unknown users get the same response", func(t *testing.T) {
+		sent := len(notifier.Messages())
+		code, data := authRequest(t, "password/reset/request", "", `{"username": "unknown"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "a reset token is sent if the user exists", data["msg"])
+		assert.Equal(t, sent, len(notifier.Messages()))
+	})
+
+	t.Run("users without verified email get the same response", func(t *testing.T) {
+		authRequest(t, "register", "", `{"username": "reset_no_email", "password": "old"}`)
+		authRequest(t, "register", "", `{"username": "reset_unverified", "password": "old", "email": "victim@example.com"}`)
+		for _, username := range []string{"reset_no_email", "reset_unverified"} {
+			sent := len(notifier.Messages())
+			code, data := authRequest(t, "password/reset/request", "", fmt.Sprintf(`{"username": %q}`, username))
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, "a reset token is sent if the user exists", data["msg"])
+			assert.Equal(t, sent, len(notifier.Messages()), username)
+		}
+	})
+
+	t.Run("tokens are hashed and single-use", func(t *testing.T) {
+		token := resetToken()
+		row, err := testHandler.db.FetchOne(context.Background(),
+			"SELECT token_hash FROM auth_password_resets WHERE token_hash = ?", hashToken(token))
+		assert.Nil(t, err)
+		assert.NotEqual(t, token, row["token_hash"])
+
+		assert.Equal(t, http.StatusOK, confirm(token, "new"))
+		assert.Equal(t, http.StatusUnauthorized, confirm(token, "another"))
+		code, _ := authRequest(t, "login", "", `{"username": "reset", "password": "new"}`)
+		assert.Equal(t, http.StatusOK, code)
+
+		// sessions are revoked
+		code, _ = authRequest(t, "refresh", "", fmt.Sprintf(`{"refresh_token": %q}`, session["refresh_token"]))
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("a new token doesn't cancel the previous one", func(t *testing.T) {
+		previous := resetToken()
+		token := resetToken()
+		assert.Equal(t, http.StatusOK, confirm(previous, "previous"))
+		// resetting the password uses up the other tokens
+		assert.Equal(t, http.StatusUnauthorized, confirm(token, "latest"))
+	})
+
+	t.Run("expired tokens are rejected", func(t *testing.T) {
+		token := resetToken()
+		_, err := testHandler.db.ExecQuery(context.Background(),
+			"UPDATE auth_password_resets SET expires_at = 1 WHERE token_hash = ?", hashToken(token))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnauthorized, confirm(token, "expired"))
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, confirm("invalid", "new"))
+		assert.Equal(t, http.StatusBadRequest, confirm("", "new"))
+		code, _ := authRequest(t, "password/reset/request", "", `{}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
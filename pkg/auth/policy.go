@@ -60,7 +60,7 @@ type Policy struct {
 // setupPolicies create `policies` table and create a default internal policies
 func setupPolicies(db *sql.DB) error {
 	log.Info("create policies table")
-	idSQL := primaryKeySQL[db.DriverName]
+	idSQL := db.Dialect().PrimaryKey()
 	createTableQuery := fmt.Sprintf(createPolicyTable, idSQL)
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the email verifications table
+	VerificationTableName = "auth_email_verifications"
+
+	// DefaultVerificationTTL is the lifetime of email verification tokens
+	DefaultVerificationTTL = 24 * time.Hour
+
+	mePath            = "/auth/me"
+	maxEmailLength    = 254
+	maxDisplayNameLen = 64
+
+	// the email is kept with the token so that a token can't verify an
+	// email changed afterwards
+	createVerificationTable = `
+	CREATE TABLE auth_email_verifications (
+		id %s,
+		user_id BIGINT NOT NULL,
+		email VARCHAR(254) NOT NULL,
+		token_hash VARCHAR(64) UNIQUE NOT NULL,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		used_at BIGINT
+	)
+	`
+	createVerification = `
+		INSERT INTO auth_email_verifications (user_id, email, token_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	expireVerifications = `UPDATE auth_email_verifications SET used_at = ? WHERE user_id = ? AND used_at IS NULL`
+	useVerification     = `
+		UPDATE auth_email_verifications SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`
+	queryVerification  = `SELECT user_id, email FROM auth_email_verifications WHERE token_hash = ?`
+	verifyEmail        = `UPDATE auth_users SET verified_at = ? WHERE id = ? AND email = ?`
+	queryProfile       = `SELECT id, username, email, display_name, is_admin, verified_at FROM auth_users WHERE id = ?`
+	updateEmail        = `UPDATE auth_users SET email = ?, verified_at = NULL WHERE id = ?`
+	updateDisplayName  = `UPDATE auth_users SET display_name = ? WHERE id = ?`
+	verificationPolicy = "email verifications are limited to admin user(to deny user to read verification tokens)"
+)
+
+// profile columns added to users tables set up before them, `ADD` without
+// `COLUMN` is supported by all the databases
+var addProfileColumns = []string{
+	"ALTER TABLE auth_users ADD email VARCHAR(254)",
+	"ALTER TABLE auth_users ADD display_name VARCHAR(64)",
+	"ALTER TABLE auth_users ADD verified_at BIGINT",
+}
+
+// Profile is the profile of a user returned by `/auth/me`, VerifiedAt is the
+// unix time the email is verified at
+type Profile struct {
+	ID          int64    `json:"id"`
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	DisplayName string   `json:"display_name"`
+	IsAdmin     bool     `json:"is_admin"`
+	VerifiedAt  int64    `json:"verified_at,omitempty"`
+	Roles       []string `json:"roles"`
+}
+
+type profileData struct {
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	Token       string  `json:"token"`
+}
+
+// setupProfiles adds profile columns to the users table and creates
+// `auth_email_verifications` table, users existing before the columns are
+// treated as verified
+func setupProfiles(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if _, err := db.ExecQuery(ctx, "SELECT email FROM auth_users WHERE 1 = 0"); err != nil {
+		log.Info("add profile columns to users table")
+		for _, query := range addProfileColumns {
+			if _, err := db.ExecQuery(ctx, query); err != nil {
+				return err
+			}
+		}
+		if _, err := db.ExecQuery(ctx, "UPDATE auth_users SET verified_at = ?", time.Now().Unix()); err != nil {
+			return err
+		}
+	}
+	if tableExists(db, VerificationTableName) {
+		return nil
+	}
+	log.Info("create email verifications table")
+	_, dbErr := db.ExecQuery(ctx, fmt.Sprintf(createVerificationTable, db.Dialect().PrimaryKey()))
+	if dbErr != nil {
+		return dbErr
+	}
+	_, dbErr = db.ExecQuery(ctx, createInternalPolicy, verificationPolicy, VerificationTableName, "all", "auth_user.is_admin")
+	return dbErr
+}
+
+// validateProfile validates the email and display name of a user, the email
+// must be a bare address like `hello@example.com`
+func validateProfile(email, displayName string) error {
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email || len(email) > maxEmailLength {
+			return fmt.Errorf("invalid email: %s", email)
+		}
+	}
+	if len(displayName) > maxDisplayNameLen {
+		return fmt.Errorf("display_name is longer than %d", maxDisplayNameLen)
+	}
+	return nil
+}
+
+// nullable returns nil for empty strings to store NULL
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// serveMe returns the profile of the user of the access token on GET and
+// updates it on PUT or PATCH
+func (h *Handler) serveMe(w http.ResponseWriter, r *http.Request) {
+	var res any
+	switch r.Method {
+	case http.MethodGet:
+		res = h.me(r)
+	case http.MethodPut, http.MethodPatch:
+		res = h.updateMe(r)
+	default:
+		res = &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+	j.Write(w, res)
+}
+
+// bearerUserID returns the user id of the access token in Authorization
+// header, it's 0 if there is no valid token
+func (h *Handler) bearerUserID(r *http.Request) int64 {
+	claims, err := h.bearerClaims(r)
+	if err != nil {
+		return 0
+	}
+	userID, _ := claims["user_id"].(float64)
+	return int64(userID)
+}
+
+func (h *Handler) me(r *http.Request) any {
+	userID := h.bearerUserID(r)
+	if userID == 0 {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "a valid access token is required",
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	profile, err := h.fetchProfile(ctx, userID)
+	if err != nil {
+		log.Errorf("fetch profile error: %v", err)
+		return j.ErrResponse(err)
+	}
+	return profile
+}
+
+// updateMe updates the email and display name of the user of the access
+// token, a new email is unverified and a verification token is sent to it
+func (h *Handler) updateMe(r *http.Request) any {
+	userID := h.bearerUserID(r)
+	if userID == 0 {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "a valid access token is required",
+		}
+	}
+	var data profileData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data",
+		}
+	}
+	var email, displayName string
+	if data.Email != nil {
+		email = *data.Email
+		if email == "" && h.requireVerification {
+			return &j.Response{Code: http.StatusBadRequest, Msg: "email is required"}
+		}
+	}
+	if data.DisplayName != nil {
+		displayName = *data.DisplayName
+	}
+	if err := validateProfile(email, displayName); err != nil {
+		return &j.Response{Code: http.StatusBadRequest, Msg: err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	profile, err := h.fetchProfile(ctx, userID)
+	if err != nil {
+		log.Errorf("fetch profile error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if data.DisplayName != nil {
+		if _, err := h.db.ExecQuery(ctx, updateDisplayName, nullable(displayName), userID); err != nil {
+			log.Errorf("update display name error: %v", err)
+			return j.ErrResponse(err)
+		}
+	}
+	if data.Email != nil && email != profile.Email {
+		if _, err := h.db.ExecQuery(ctx, updateEmail, nullable(email), userID); err != nil {
+			log.Errorf("update email error: %v", err)
+			return j.ErrResponse(err)
+		}
+		if email != "" && h.notifier != nil {
+			if err := h.sendVerification(ctx, userID, email); err != nil {
+				log.Errorf("send verification error: %v", err)
+			}
+		}
+	}
+	profile, err = h.fetchProfile(ctx, userID)
+	if err != nil {
+		log.Errorf("fetch profile error: %v", err)
+		return j.ErrResponse(err)
+	}
+	return profile
+}
+
+// requestVerification sends a verification token to the email of the user of
+// the access token
+func (h *Handler) requestVerification(r *http.Request) any {
+	if h.notifier == nil {
+		return &j.Response{
+			Code: http.StatusNotImplemented,
+			Msg:  "email verification is not enabled",
+		}
+	}
+	userID := h.bearerUserID(r)
+	if userID == 0 {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "a valid access token is required",
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	profile, err := h.fetchProfile(ctx, userID)
+	if err != nil {
+		log.Errorf("fetch profile error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if profile.Email == "" {
+		return &j.Response{Code: http.StatusBadRequest, Msg: "email is not set"}
+	}
+	if profile.VerifiedAt != 0 {
+		return &j.Response{Code: http.StatusBadRequest, Msg: "email is already verified"}
+	}
+	if err := h.sendVerification(ctx, userID, profile.Email); err != nil {
+		log.Errorf("send verification error: %v", err)
+		return &j.Response{
+			Code: http.StatusInternalServerError,
+			Msg:  "failed to send verification token",
+		}
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "a verification token is sent"}
+}
+
+// confirmVerification verifies the email with a verification token, the
+// token is used up and it's invalid if the email is changed since
+func (h *Handler) confirmVerification(r *http.Request) any {
+	var data profileData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Token == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "token is required",
+		}
+	}
+	invalid := &j.Response{
+		Code: http.StatusUnauthorized,
+		Msg:  "invalid or expired verification token",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	now := time.Now().Unix()
+	hashed := hashToken(data.Token)
+	rows, err := h.db.ExecQuery(ctx, useVerification, now, hashed, now)
+	if err != nil {
+		log.Errorf("use verification error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if rows == 0 {
+		return invalid
+	}
+	row, err := h.db.FetchOne(ctx, queryVerification, hashed)
+	if err != nil {
+		log.Errorf("fetch verification error: %v", err)
+		return j.ErrResponse(err)
+	}
+	rows, err = h.db.ExecQuery(ctx, verifyEmail, now, row["user_id"].(int64), row["email"].(string))
+	if err != nil {
+		log.Errorf("verify email error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if rows == 0 {
+		return invalid
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// sendVerification sends a single-use verification token to the email, it
+// uses up the previous tokens of the user
+func (h *Handler) sendVerification(ctx context.Context, userID int64, email string) error {
+	token, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := h.db.ExecQuery(ctx, expireVerifications, now.Unix(), userID); err != nil {
+		return err
+	}
+	_, err = h.db.ExecQuery(ctx, createVerification,
+		userID, email, hashToken(token), now.Unix(), now.Add(h.verificationTTL).Unix())
+	if err != nil {
+		return err
+	}
+	return h.notifier.Notify(ctx, Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Use the token to verify your email in %s, ignore it if you didn't sign up.\r\n\r\n%s",
+			h.verificationTTL, token),
+	})
+}
+
+// checkVerified returns an error response if verification is required and
+// the email of the user is not verified, it's checked on every login path
+func (h *Handler) checkVerified(ctx context.Context, userID int64) *j.Response {
+	if !h.requireVerification {
+		return nil
+	}
+	verified, err := h.isVerified(ctx, userID)
+	if err != nil {
+		log.Errorf("fetch profile error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if !verified {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "email is not verified",
+		}
+	}
+	return nil
+}
+
+// isVerified returns whether the email of the user is verified
+func (h *Handler) isVerified(ctx context.Context, userID int64) (bool, error) {
+	profile, err := h.fetchProfile(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return profile.VerifiedAt != 0, nil
+}
+
+func (h *Handler) fetchProfile(ctx context.Context, userID int64) (*Profile, error) {
+	row, err := h.db.FetchOne(ctx, queryProfile, userID)
+	if err != nil {
+		var dbErr sql.Error
+		if errors.As(err, &dbErr) && dbErr.Code == http.StatusNotFound {
+			return nil, sql.NewError(http.StatusUnauthorized, "user not found")
+		}
+		return nil, err
+	}
+	roles, err := fetchRoles(ctx, h.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	// NULL columns are converted to zero values
+	email, _ := row["email"].(string)
+	displayName, _ := row["display_name"].(string)
+	verifiedAt, _ := row["verified_at"].(int64)
+	return &Profile{
+		ID:          userID,
+		Username:    row["username"].(string),
+		Email:       email,
+		DisplayName: displayName,
+		IsAdmin:     row["is_admin"].(bool),
+		VerifiedAt:  verifiedAt,
+		Roles:       roles,
+	}, nil
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rest-go/rest/pkg/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func meRequest(t *testing.T, method, token, body string) (int, map[string]any) {
+	req := httptest.NewRequest(method, mePath, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(AuthorizationHeader, "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, req)
+	res := w.Result()
+	defer res.Body.Close()
+	var data map[string]any
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&data))
+	return res.StatusCode, data
+}
+
+func TestMe(t *testing.T) {
+	code, _ := authRequest(t, "register", "",
+		`{"username": "me", "password": "world", "email": "me@example.com", "display_name": "Me"}`)
+	assert.Equal(t, http.StatusOK, code)
+	_, tokens := authRequest(t, "login", "", `{"username": "me", "password": "world"}`)
+	token := tokens["token"].(string)
+
+	code, _ = meRequest(t, http.MethodGet, "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, data := meRequest(t, http.MethodGet, token, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "me", data["username"])
+	assert.Equal(t, "me@example.com", data["email"])
+	assert.Equal(t, "Me", data["display_name"])
+	assert.Nil(t, data["verified_at"])
+	assert.Nil(t, data["password"])
+
+	code, data = meRequest(t, http.MethodPatch, token, `{"display_name": "New Me"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "New Me", data["display_name"])
+	assert.Equal(t, "me@example.com", data["email"])
+
+	code, _ = meRequest(t, http.MethodPut, token, `{"email": "Me <me@example.com>"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = meRequest(t, http.MethodPut, token, fmt.Sprintf(`{"display_name": %q}`, strings.Repeat("a", 65)))
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = meRequest(t, http.MethodDelete, token, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, data = meRequest(t, http.MethodPut, token, `{"email": ""}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "", data["email"])
+	assert.Equal(t, "New Me", data["display_name"])
+}
+
+//nolint:funlen
+func TestEmailVerification(t *testing.T) {
+	notifier := &MemoryNotifier{}
+	testHandler.notifier = notifier
+	defer func() { testHandler.notifier = nil }()
+
+	lastToken := func(to string) string {
+		messages := notifier.Messages()
+		msg := messages[len(messages)-1]
+		assert.Equal(t, to, msg.To)
+		lines := strings.Split(msg.Body, "\n")
+		return lines[len(lines)-1]
+	}
+	confirm := func(token string) int {
+		code, _ := authRequest(t, "verify/confirm", "", fmt.Sprintf(`{"token": %q}`, token))
+		return code
+	}
+
+	code, _ := authRequest(t, "register", "", `{"username": "verify", "password": "world", "email": "verify@example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	token := lastToken("verify@example.com")
+	_, tokens := authRequest(t, "login", "", `{"username": "verify", "password": "world"}`)
+	access := tokens["token"].(string)
+
+	t.Run("tokens are hashed and single-use", func(t *testing.T) {
+		row, err := testHandler.db.FetchOne(context.Background(),
+			"SELECT token_hash FROM auth_email_verifications WHERE token_hash = ?", hashToken(token))
+		assert.Nil(t, err)
+		assert.NotEqual(t, token, row["token_hash"])
+
+		assert.Equal(t, http.StatusOK, confirm(token))
+		assert.Equal(t, http.StatusUnauthorized, confirm(token))
+		_, data := meRequest(t, http.MethodGet, access, "")
+		assert.NotNil(t, data["verified_at"])
+
+		code, _ := authRequest(t, "verify/request", access, "")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("changing email resets verification", func(t *testing.T) {
+		code, data := meRequest(t, http.MethodPatch, access, `{"email": "new@example.com"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Nil(t, data["verified_at"])
+		previous := lastToken("new@example.com")
+
+		code, _ = authRequest(t, "verify/request", access, "")
+		assert.Equal(t, http.StatusOK, code)
+		token := lastToken("new@example.com")
+		assert.Equal(t, http.StatusUnauthorized, confirm(previous))
+
+		// the token can't verify another email
+		meRequest(t, http.MethodPatch, access, `{"email": "other@example.com"}`)
+		assert.Equal(t, http.StatusUnauthorized, confirm(token))
+	})
+
+	t.Run("expired tokens are rejected", func(t *testing.T) {
+		code, _ := authRequest(t, "verify/request", access, "")
+		assert.Equal(t, http.StatusOK, code)
+		token := lastToken("other@example.com")
+		_, err := testHandler.db.ExecQuery(context.Background(),
+			"UPDATE auth_email_verifications SET expires_at = 1 WHERE token_hash = ?", hashToken(token))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnauthorized, confirm(token))
+	})
+
+	t.Run("password reset is sent to the verified email", func(t *testing.T) {
+		sent := len(notifier.Messages())
+		code, _ := authRequest(t, "password/reset/request", "", `{"username": "verify"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, sent, len(notifier.Messages()))
+
+		code, _ = authRequest(t, "verify/request", access, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, http.StatusOK, confirm(lastToken("other@example.com")))
+		sent = len(notifier.Messages())
+		code, _ = authRequest(t, "password/reset/request", "", `{"username": "verify"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Eventually(t, func() bool { return len(notifier.Messages()) > sent }, time.Second, 10*time.Millisecond)
+		assert.Contains(t, notifier.Messages()[len(notifier.Messages())-1].Subject, "password")
+		lastToken("other@example.com")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, confirm(""))
+		code, _ := authRequest(t, "verify/request", "", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = authRequest(t, "register", "", `{"username": "invalid", "password": "world", "email": "invalid"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+func TestRequireVerification(t *testing.T) {
+	notifier := &MemoryNotifier{}
+	testHandler.notifier = notifier
+	testHandler.requireVerification = true
+	defer func() {
+		testHandler.notifier = nil
+		testHandler.requireVerification = false
+	}()
+
+	code, _ := authRequest(t, "register", "", `{"username": "required", "password": "world"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = authRequest(t, "register", "", `{"username": "required", "password": "world", "email": "required@example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	messages := notifier.Messages()
+	lines := strings.Split(messages[len(messages)-1].Body, "\n")
+
+	code, data := authRequest(t, "login", "", `{"username": "required", "password": "world"}`)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "email is not verified", data["msg"])
+
+	code, _ = authRequest(t, "verify/confirm", "", fmt.Sprintf(`{"token": %q}`, lines[len(lines)-1]))
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = authRequest(t, "login", "", `{"username": "required", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestSetupProfiles(t *testing.T) {
+	db, err := sql.Open("sqlite://test-profiles.db")
+	assert.Nil(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.ExecQuery(ctx, "DROP TABLE IF EXISTS auth_users")
+	assert.Nil(t, err)
+	_, err = db.ExecQuery(ctx, "DROP TABLE IF EXISTS auth_email_verifications")
+	assert.Nil(t, err)
+	_, err = db.ExecQuery(ctx, "DROP TABLE IF EXISTS auth_policies")
+	assert.Nil(t, err)
+	assert.Nil(t, setupPolicies(db))
+
+	// users set up before profiles
+	_, err = db.ExecQuery(ctx, `CREATE TABLE auth_users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username VARCHAR(32) UNIQUE NOT NULL,
+		password VARCHAR(72) NOT NULL,
+		is_admin bool NOT NULL DEFAULT false
+	)`)
+	assert.Nil(t, err)
+	_, err = db.ExecQuery(ctx, createUser, "old", "xxx")
+	assert.Nil(t, err)
+
+	assert.Nil(t, setupProfiles(db))
+	assert.Nil(t, setupProfiles(db))
+	row, err := db.FetchOne(ctx, "SELECT email, verified_at FROM auth_users WHERE username = ?", "old")
+	assert.Nil(t, err)
+	assert.Equal(t, "", row["email"])
+	assert.NotEqual(t, int64(0), row["verified_at"])
+	assert.True(t, tableExists(db, VerificationTableName))
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The names of the roles tables
+	RoleTableName     = "auth_roles"
+	UserRoleTableName = "auth_user_roles"
+
+	createRoleTable = `
+	CREATE TABLE auth_roles (
+		id %s,
+		name VARCHAR(64) UNIQUE NOT NULL,
+		description VARCHAR(256) NOT NULL DEFAULT ''
+	)
+	`
+	createUserRoleTable = `
+	CREATE TABLE auth_user_roles (
+		id %s,
+		user_id BIGINT NOT NULL,
+		role_id BIGINT NOT NULL,
+		UNIQUE (user_id, role_id)
+	)
+	`
+	queryUserRoles = `
+		SELECT r.name FROM auth_roles r JOIN auth_user_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = ? ORDER BY r.name
+	`
+	rolesPolicy = "roles are limited to admin user(to deny user to grant roles to self)"
+)
+
+// setupRoles creates `auth_roles` and `auth_user_roles` tables and the
+// policies limiting them to admin user, it's skipped if the tables exist
+func setupRoles(db *sql.DB) error {
+	if tableExists(db, UserRoleTableName) {
+		return nil
+	}
+	log.Info("create roles tables")
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	idSQL := db.Dialect().PrimaryKey()
+	for _, query := range []string{createRoleTable, createUserRoleTable} {
+		if _, dbErr := db.ExecQuery(ctx, fmt.Sprintf(query, idSQL)); dbErr != nil {
+			return dbErr
+		}
+	}
+	for _, table := range []string{RoleTableName, UserRoleTableName} {
+		_, dbErr := db.ExecQuery(ctx, createInternalPolicy, rolesPolicy, table, "all", "auth_user.is_admin")
+		if dbErr != nil {
+			return dbErr
+		}
+	}
+	return nil
+}
+
+// fetchRoles returns names of the roles granted to the user
+func fetchRoles(ctx context.Context, db *sql.DB, userID int64) ([]string, error) {
+	rows, err := db.FetchData(ctx, queryUserRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(rows))
+	for _, row := range rows {
+		roles = append(roles, row["name"].(string))
+	}
+	return roles, nil
+}
+
+// rolesFromClaims returns the `roles` claim of a token
+func rolesFromClaims(claims map[string]any) []string {
+	values, _ := claims["roles"].([]any)
+	roles := make([]string, 0, len(values))
+	for _, v := range values {
+		if role, ok := v.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
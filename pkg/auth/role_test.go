@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	authRequest(t, "register", "", `{"username": "support", "password": "world"}`)
+	row, err := testHandler.db.FetchOne(ctx, queryUser, "support")
+	assert.Nil(t, err)
+	userID := row["id"].(int64)
+
+	for _, role := range []string{"support", "editor"} {
+		_, err := testHandler.db.ExecQuery(ctx, "INSERT INTO auth_roles (name) VALUES (?)", role)
+		assert.Nil(t, err)
+	}
+	_, err = testHandler.db.ExecQuery(ctx,
+		"INSERT INTO auth_user_roles (user_id, role_id) SELECT ?, id FROM auth_roles WHERE name = ?",
+		userID, "support")
+	assert.Nil(t, err)
+
+	roles, err := fetchRoles(ctx, testHandler.db, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"support"}, roles)
+
+	// roles are embedded in the access token
+	_, data := authRequest(t, "login", "", `{"username": "support", "password": "world"}`)
+	claims, err := ParseJWTToken([]byte(testSecret), data["token"].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"support"}, claims["roles"])
+
+	var user *User
+	handler := NewMiddleware([]byte(testSecret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set(AuthorizationHeader, "Bearer "+data["token"].(string))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, user.HasRole("support"))
+	assert.False(t, user.HasRole("editor"))
+
+	// api keys act with roles of the user
+	key, err := CreateAPIKey(ctx, testHandler.db, "support", "support", nil, 0)
+	assert.Nil(t, err)
+	user, err = newAPIKeys(testHandler.db).Authenticate(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"support"}, user.Roles)
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the sessions table
+	SessionTableName = "auth_sessions"
+
+	// DefaultAccessTokenTTL is the lifetime of access tokens, revoked
+	// sessions are denied by the middleware until their access tokens expire
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL is the lifetime of sessions, a session can be
+	// refreshed until it expires
+	DefaultRefreshTokenTTL = 14 * 24 * time.Hour
+
+	// denylistRefreshInterval is the interval to reload revoked sessions
+	denylistRefreshInterval = 10 * time.Second
+
+	// times are unix seconds to be portable across databases
+	createSessionTable = `
+	CREATE TABLE auth_sessions (
+		id VARCHAR(32) PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		refresh_token VARCHAR(64) NOT NULL,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		revoked_at BIGINT
+	)
+	`
+	createSession = `
+		INSERT INTO auth_sessions (id, user_id, refresh_token, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	querySession      = `SELECT user_id, refresh_token FROM auth_sessions WHERE id = ? AND revoked_at IS NULL AND expires_at > ?`
+	rotateSession     = `UPDATE auth_sessions SET refresh_token = ? WHERE id = ? AND refresh_token = ? AND revoked_at IS NULL`
+	revokeSession     = `UPDATE auth_sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	queryUserSessions = `SELECT id FROM auth_sessions WHERE user_id = ? AND revoked_at IS NULL`
+	queryRevoked      = `SELECT id, revoked_at FROM auth_sessions WHERE revoked_at > ?`
+	queryTableSQL     = `SELECT 1 FROM %s WHERE 1 = 0`
+	sessionsPolicy    = "sessions are limited to admin user(to deny user to restore a revoked session)"
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// setupSessions creates `auth_sessions` table and the policy limiting it to
+// admin user, it's skipped if the table exists so that it can be used to
+// upgrade a database which was set up before sessions
+func setupSessions(db *sql.DB) error {
+	if tableExists(db, SessionTableName) {
+		return nil
+	}
+	log.Info("create sessions table")
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	_, dbErr := db.ExecQuery(ctx, createSessionTable)
+	if dbErr != nil {
+		return dbErr
+	}
+	_, dbErr = db.ExecQuery(ctx, createInternalPolicy, sessionsPolicy, SessionTableName, "all", "auth_user.is_admin")
+	return dbErr
+}
+
+func tableExists(db *sql.DB, name string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	_, err := db.ExecQuery(ctx, fmt.Sprintf(queryTableSQL, name))
+	return err == nil
+}
+
+// newSessionToken returns a random session id and refresh token, the token
+// is prefixed with the session id so that a reused token revokes its session
+func newSessionToken(sid string) (string, string, error) {
+	if sid == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", "", err
+		}
+		sid = hex.EncodeToString(b)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return sid, sid + "." + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession stores a new session of the user and returns its id and
+// refresh token
+func (h *Handler) createSession(ctx context.Context, userID int64) (sid, refreshToken string, err error) {
+	sid, refreshToken, err = newSessionToken("")
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	_, err = h.db.ExecQuery(ctx, createSession,
+		sid, userID, hashToken(refreshToken), now.Unix(), now.Add(h.refreshTokenTTL).Unix())
+	return sid, refreshToken, err
+}
+
+// rotateSession validates a refresh token and replaces it with a new one, a
+// token which has been rotated already revokes the session as it may be
+// stolen
+func (h *Handler) rotateSession(ctx context.Context, refreshToken string) (userID int64, sid, newToken string, err error) {
+	sid, _, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return 0, "", "", errInvalidRefreshToken
+	}
+	row, err := h.db.FetchOne(ctx, querySession, sid, time.Now().Unix())
+	if err != nil {
+		var dbErr sql.Error
+		if errors.As(err, &dbErr) && dbErr.Code == http.StatusNotFound {
+			// revoked or expired
+			return 0, "", "", errInvalidRefreshToken
+		}
+		return 0, "", "", err
+	}
+	hashed := hashToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(hashed), []byte(row["refresh_token"].(string))) != 1 {
+		log.Warnf("refresh token of session %s is reused, revoke the session", sid)
+		if err := h.revokeSession(ctx, sid); err != nil {
+			log.Errorf("revoke session error: %v", err)
+		}
+		return 0, "", "", errInvalidRefreshToken
+	}
+
+	_, newToken, err = newSessionToken(sid)
+	if err != nil {
+		return 0, "", "", err
+	}
+	rows, err := h.db.ExecQuery(ctx, rotateSession, hashToken(newToken), sid, hashed)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if rows != 1 {
+		// rotated or revoked by a concurrent request
+		return 0, "", "", errInvalidRefreshToken
+	}
+	return row["user_id"].(int64), sid, newToken, nil
+}
+
+// revokeSession revokes a session, it's denied by the middleware right away
+// in this process and after the denylist is reloaded in others
+func (h *Handler) revokeSession(ctx context.Context, sid string) error {
+	now := time.Now().Unix()
+	if _, err := h.db.ExecQuery(ctx, revokeSession, now, sid); err != nil {
+		return err
+	}
+	h.denylist.add(sid, now)
+	return nil
+}
+
+// revokeUserSessions revokes the sessions of a user except keep, e.g. after
+// the password is changed
+func (h *Handler) revokeUserSessions(ctx context.Context, userID int64, keep string) error {
+	rows, err := h.db.FetchData(ctx, queryUserSessions, userID)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if sid := row["id"].(string); sid != keep {
+			if err := h.revokeSession(ctx, sid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Denylist caches the revoked sessions whose access tokens may be still
+// valid, it's reloaded from the database periodically
+type Denylist struct {
+	db  *sql.DB
+	ttl time.Duration // access token ttl
+
+	mu       sync.Mutex
+	revoked  map[string]int64 // session id => revoked at
+	loadedAt time.Time
+}
+
+func newDenylist(db *sql.DB, ttl time.Duration) *Denylist {
+	return &Denylist{db: db, ttl: ttl, revoked: map[string]int64{}}
+}
+
+// IsRevoked returns whether the session is revoked
+func (d *Denylist) IsRevoked(sid string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.loadedAt) > denylistRefreshInterval {
+		d.load()
+	}
+	_, ok := d.revoked[sid]
+	return ok
+}
+
+func (d *Denylist) add(sid string, revokedAt int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.revoked[sid] = revokedAt
+}
+
+// load reloads sessions revoked within the access token ttl, the previous
+// ones are kept if it fails
+func (d *Denylist) load() {
+	d.loadedAt = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	since := time.Now().Add(-d.ttl).Unix()
+	rows, err := d.db.FetchData(ctx, queryRevoked, since)
+	if err != nil {
+		log.Warnf("load revoked sessions error: %v", err)
+		return
+	}
+	revoked := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		revoked[row["id"].(string)] = row["revoked_at"].(int64)
+	}
+	d.revoked = revoked
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/base32"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -22,12 +23,18 @@ const (
 		id %s,
 		username VARCHAR(32) UNIQUE NOT NULL,
 		password VARCHAR(72) NOT NULL,
-		is_admin bool NOT NULL DEFAULT false
+		is_admin bool NOT NULL DEFAULT false,
+		email VARCHAR(254),
+		display_name VARCHAR(64),
+		verified_at BIGINT
 	)
 	`
-	createAdminUser = `INSERT INTO auth_users (username, password, is_admin) VALUES (?, ?, true)`
+	createAdminUser = `INSERT INTO auth_users (username, password, is_admin, verified_at) VALUES (?, ?, true, ?)`
 	createUser      = `INSERT INTO auth_users (username, password) VALUES (?, ?)`
-	queryUser       = `SELECT id, username, password, is_admin FROM auth_users WHERE username = ?`
+	// email and display_name are optional, verified_at is set on verification
+	createUserWithProfile = `INSERT INTO auth_users (username, password, email, display_name) VALUES (?, ?, ?, ?)`
+	queryUser             = `SELECT id, username, password, is_admin FROM auth_users WHERE username = ?`
+	queryUserByID         = `SELECT id, username, is_admin FROM auth_users WHERE id = ?`
 )
 
 // User represents a request user
@@ -36,6 +43,13 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"is_admin"`
+	// Roles are names of the roles granted to the user
+	Roles []string `json:"roles,omitempty"`
+	// Scopes limit the tables and actions of an API key user, see CreateAPIKey
+	Scopes []string `json:"scopes,omitempty"`
+	// Claims of the access token, they can be used in policies like
+	// `org_id = auth_user.claims.org_id`
+	Claims map[string]any `json:"-"`
 }
 
 // IsAuthenticated returns a bool to indicate whether user is anonymous
@@ -48,48 +62,101 @@ func (u *User) IsAuthenticated() bool {
 	return u.ID != 0
 }
 
-func (u *User) hasPerm(exp string) (hasPerm bool, withUserIDColumn string) {
-	// remove all the spaces in expression
-	exp = strings.ReplaceAll(exp, " ", "")
-	// if ask a admin user perm
-	if exp == "" {
-		return true, ""
-	} else if exp == "auth_user.is_admin" {
-		return u.IsAdmin, ""
-	} else if exp == "auth_user.is_authenticated" {
-		return u.IsAuthenticated(), ""
-	} else if strings.HasSuffix(exp, "=auth_user.id") {
-		return u.IsAuthenticated(), strings.TrimSuffix(exp, "=auth_user.id")
-	}
-
-	log.Errorf("invalid policy exp: %s, return false", exp)
-	return false, ""
+// HasRole returns whether the role is granted to the user
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
-// HasPerm check whether user has permission to perform action on the table with provided policies
+// InScopes returns whether the action on the table is allowed by the scopes,
+// read scopes allow read_mine as well
+func (u *User) InScopes(table string, action Action) bool {
+	if len(u.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range u.Scopes {
+		t, a, _ := strings.Cut(scope, ":")
+		if t != "*" && t != table {
+			continue
+		}
+		if a == "*" || a == action.String() || (a == ActionRead.String() && action == ActionReadMine) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPerm check whether user has permission to perform action on the table with provided policies,
+// withUserIDColumn is set if the policy is `<column> = auth_user.id`, use Check for other
+// expressions limiting rows
 func (u *User) HasPerm(table string, action Action, policies map[string]map[string]string) (hasPerm bool, withUserIDColumn string) {
+	exp, ok := u.policy(table, action, policies)
+	if !ok {
+		return false, ""
+	}
+	cond, ok, err := newCondition(exp, u)
+	if err != nil {
+		log.Errorf("invalid policy exp: %s, %v, return false", exp, err)
+		return false, ""
+	}
+	n, _ := parseExpression(exp)
+	if column := userIDColumn(n); column != "" {
+		return ok, column
+	}
+	if cond != nil {
+		log.Warnf("policy exp %s limits rows by other columns, use Check instead", exp)
+		return false, ""
+	}
+	return ok, ""
+}
+
+// Check checks whether user has permission to perform action on the table with provided policies,
+// the rows are limited by the condition if it's not nil
+func (u *User) Check(table string, action Action, policies map[string]map[string]string) (*Condition, bool) {
+	exp, ok := u.policy(table, action, policies)
+	if !ok {
+		return nil, false
+	}
+	cond, ok, err := newCondition(exp, u)
+	if err != nil {
+		log.Errorf("invalid policy exp: %s, %v, return false", exp, err)
+		return nil, false
+	}
+	return cond, ok
+}
+
+// policy returns the policy expression of the table and action, ok is false
+// if the action is denied by scopes or policies are missing
+func (u *User) policy(table string, action Action, policies map[string]map[string]string) (exp string, ok bool) {
 	if policies == nil {
 		log.Warnf("nil policies")
-		return false, ""
+		return "", false
+	}
+	if !u.InScopes(table, action) {
+		return "", false
 	}
 
 	var ps map[string]string
-	ps, ok := policies[table]
+	ps, ok = policies[table]
 	defaultTablePerm := policies["all"]
 	if !ok {
 		ps = defaultTablePerm
 	}
-	if len(ps) > 0 {
-		if exp, ok := ps[action.String()]; ok {
-			return u.hasPerm(exp)
-		} else if exp, ok := ps["all"]; ok {
-			return u.hasPerm(exp)
-		} else {
-			return u.hasPerm(defaultTablePerm["all"])
-		}
+	if len(ps) == 0 {
+		return "", true
 	}
-
-	return true, ""
+	exp, ok = ps[action.String()]
+	if !ok {
+		exp, ok = ps["all"]
+	}
+	if !ok {
+		exp = defaultTablePerm["all"]
+	}
+	return exp, true
 }
 
 // HashPassword generate the hashed password for a plain password
@@ -113,7 +180,7 @@ func genPasswd(length int) (string, error) {
 // setupUsers create `users` table and create an admin user
 func setupUsers(db *sql.DB) (username, password string, err error) {
 	log.Info("create users table")
-	idSQL := primaryKeySQL[db.DriverName]
+	idSQL := db.Dialect().PrimaryKey()
 	createTableQuery := fmt.Sprintf(createUserTable, idSQL)
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
@@ -133,6 +200,6 @@ func setupUsers(db *sql.DB) (username, password string, err error) {
 	if err != nil {
 		return "", "", err
 	}
-	_, dbErr = db.ExecQuery(ctx, createAdminUser, username, hashedPassword)
+	_, dbErr = db.ExecQuery(ctx, createAdminUser, username, hashedPassword, time.Now().Unix())
 	return username, password, dbErr
 }
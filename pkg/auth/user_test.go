@@ -39,6 +39,10 @@ var policies = map[string]map[string]string{
 	"notes": {
 		"read": "invalid policy",
 	},
+	"orders": {
+		"read": "auth_user.has_role('support')",
+		"all":  "auth_user.is_admin",
+	},
 }
 
 //nolint:funlen
@@ -163,6 +167,62 @@ func TestUser_HasPerm(t *testing.T) {
 			hasPerm:          false,
 			withUserIDColumn: "",
 		},
+		{
+			name:             "users with the role have read perm on orders",
+			user:             User{ID: 1, Roles: []string{"editor", "support"}},
+			table:            "orders",
+			action:           ActionRead,
+			hasPerm:          true,
+			withUserIDColumn: "",
+		},
+		{
+			name:             "users without the role don't have read perm on orders",
+			user:             User{ID: 1, Roles: []string{"editor"}},
+			table:            "orders",
+			action:           ActionRead,
+			hasPerm:          false,
+			withUserIDColumn: "",
+		},
+		{
+			name:             "the role doesn't grant other actions",
+			user:             User{ID: 1, Roles: []string{"support"}},
+			table:            "orders",
+			action:           ActionUpdate,
+			hasPerm:          false,
+			withUserIDColumn: "",
+		},
+		{
+			name:             "scopes allow the table and action",
+			user:             User{ID: 1, Scopes: []string{"todos:read"}},
+			table:            "todos",
+			action:           ActionRead,
+			hasPerm:          true,
+			withUserIDColumn: "author_id",
+		},
+		{
+			name:             "read scopes allow read mine",
+			user:             User{ID: 1, Scopes: []string{"*:read"}},
+			table:            "todos",
+			action:           ActionReadMine,
+			hasPerm:          true,
+			withUserIDColumn: "author_id",
+		},
+		{
+			name:             "scopes deny other actions",
+			user:             User{ID: 1, Scopes: []string{"todos:read"}},
+			table:            "todos",
+			action:           ActionCreate,
+			hasPerm:          false,
+			withUserIDColumn: "",
+		},
+		{
+			name:             "scopes deny other tables",
+			user:             User{ID: 1, Scopes: []string{"todos:*"}},
+			table:            "comments",
+			action:           ActionRead,
+			hasPerm:          false,
+			withUserIDColumn: "",
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			hasPerm, userIDColumn := test.user.HasPerm(test.table, test.action, policies)
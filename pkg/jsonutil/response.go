@@ -6,35 +6,107 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/rest-go/rest/pkg/log"
 	"github.com/rest-go/rest/pkg/sql"
 )
 
+// ProblemContentType is the content type of error responses, see RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// hideErrorDetails is 1 if raw database messages are hidden from responses
+var hideErrorDetails int32
+
+// HideErrorDetails hides raw database messages from error responses, e.g. in
+// production, the stable code and details like constraint are still returned
+func HideErrorDetails(hide bool) {
+	var v int32
+	if hide {
+		v = 1
+	}
+	atomic.StoreInt32(&hideErrorDetails, v)
+}
+
 // Response serves a default JSON output when no data fetched from data
 type Response struct {
-	Code int    `json:"-"` // write to http status code
-	Msg  string `json:"msg"`
+	Code    int    `json:"-"` // write to http status code
+	Msg     string `json:"msg"`
+	ErrCode string `json:"-"` // stable code of errors, derived from Code if empty
+
+	sql.ErrorDetails `json:"-"`
 }
 
-func Write(w http.ResponseWriter, data any) {
-	w.Header().Set("Content-Type", "application/json")
+// Problem is the body of error responses, see RFC 7807
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	sql.ErrorDetails
+	// Msg is the same as Detail, it's kept for old clients
+	Msg string `json:"msg"`
+}
 
+// Envelope wraps a page of data with pagination meta data
+type Envelope struct {
+	Data     any    `json:"data"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+	HasMore  bool   `json:"has_more"`
+	Total    *int64 `json:"total,omitempty"`
+}
+
+func Write(w http.ResponseWriter, data any) {
+	code := http.StatusOK
+	contentType := "application/json"
 	if res, ok := data.(*Response); ok {
-		w.WriteHeader(res.Code)
-	} else {
-		w.WriteHeader(http.StatusOK)
+		code = res.Code
+		if code >= http.StatusBadRequest {
+			contentType = ProblemContentType
+			data = res.problem()
+		}
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		log.Errorf("failed to encode json data, %v", err)
 	}
 }
 
+func (r *Response) problem() *Problem {
+	code := r.ErrCode
+	if code == "" {
+		// e.g. 404 => not_found
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(r.Code)), " ", "_")
+	}
+	return &Problem{
+		Type:         "about:blank",
+		Title:        http.StatusText(r.Code),
+		Status:       r.Code,
+		Detail:       r.Msg,
+		Code:         code,
+		ErrorDetails: r.ErrorDetails,
+		Msg:          r.Msg,
+	}
+}
+
 func ErrResponse(err error) *Response {
+	hide := atomic.LoadInt32(&hideErrorDetails) == 1
 	var dbErr sql.Error
 	if errors.As(err, &dbErr) {
-		return &Response{Code: dbErr.Code, Msg: dbErr.Msg}
+		msg := dbErr.Msg
+		if hide {
+			msg = dbErr.SafeMsg()
+		}
+		return &Response{Code: dbErr.Code, Msg: msg, ErrCode: dbErr.ErrCode, ErrorDetails: dbErr.ErrorDetails}
+	}
+	msg := err.Error()
+	if hide {
+		msg = http.StatusText(http.StatusInternalServerError)
 	}
-	return &Response{Code: http.StatusInternalServerError, Msg: err.Error()}
+	return &Response{Code: http.StatusInternalServerError, Msg: msg}
 }
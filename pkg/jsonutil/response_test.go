@@ -16,7 +16,45 @@ func TestWrite(t *testing.T) {
 		res := &Response{Code: http.StatusBadGateway, Msg: "bad gateway"}
 		Write(rr, res)
 		assert.Equal(t, rr.Code, http.StatusBadGateway)
-		assert.Equal(t, rr.Body.String(), "{\"msg\":\"bad gateway\"}\n")
+		assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"type": "about:blank",
+			"title": "Bad Gateway",
+			"status": 502,
+			"detail": "bad gateway",
+			"code": "bad_gateway",
+			"msg": "bad gateway"
+		}`, rr.Body.String())
+	})
+
+	t.Run("success response", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		Write(rr, &Response{Code: http.StatusOK, Msg: "ok"})
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "{\"msg\":\"ok\"}\n", rr.Body.String())
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		Write(rr, &Response{
+			Code:    http.StatusConflict,
+			Msg:     "duplicate key",
+			ErrCode: sql.ErrCodeUniqueViolation,
+			ErrorDetails: sql.ErrorDetails{
+				SQLState:   "23505",
+				Constraint: "users_pkey",
+			},
+		})
+		assert.JSONEq(t, `{
+			"type": "about:blank",
+			"title": "Conflict",
+			"status": 409,
+			"detail": "duplicate key",
+			"code": "unique_violation",
+			"sqlstate": "23505",
+			"constraint": "users_pkey",
+			"msg": "duplicate key"
+		}`, rr.Body.String())
 	})
 
 	t.Run("map data", func(t *testing.T) {
@@ -42,4 +80,17 @@ func TestNewErrResponse(t *testing.T) {
 		assert.Equal(t, res.Code, http.StatusInternalServerError)
 		assert.Equal(t, res.Msg, err.Error())
 	})
+
+	t.Run("hide error details", func(t *testing.T) {
+		HideErrorDetails(true)
+		defer HideErrorDetails(false)
+
+		err := sql.Error{Code: http.StatusConflict, Msg: "hello, raw message", ErrCode: sql.ErrCodeUniqueViolation}
+		res := ErrResponse(err)
+		assert.Equal(t, sql.ErrCodeUniqueViolation, res.ErrCode)
+		assert.Equal(t, err.SafeMsg(), res.Msg)
+
+		res = ErrResponse(errors.New("error"))
+		assert.Equal(t, "Internal Server Error", res.Msg)
+	})
 }
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rest-go/rest/pkg/auth"
+	j "github.com/rest-go/rest/pkg/jsonutil"
+)
+
+// adminPrefix is the path prefix of admin endpoints, e.g. GET /_admin/stats
+const adminPrefix = "_admin"
+
+// admin serves admin endpoints, they are limited to admin users if auth is
+// enabled
+func (s *Server) admin(r *http.Request, name string) any {
+	if s.authEnabled && !auth.GetUser(r).IsAdmin {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "admin endpoints are limited to admin users",
+		}
+	}
+	if r.Method != http.MethodGet {
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+
+	switch name {
+	case "stats":
+		return s.stats()
+	default:
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  fmt.Sprintf("admin endpoint does not exist: %s", name),
+		}
+	}
+}
+
+// stats returns statistics of the database connection pool
+func (s *Server) stats() map[string]any {
+	stats := s.db.Stats()
+	return map[string]any{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration":        stats.WaitDuration.String(),
+		"max_idle_closed":      stats.MaxIdleClosed,
+		"max_idle_time_closed": stats.MaxIdleTimeClosed,
+		"max_lifetime_closed":  stats.MaxLifetimeClosed,
+	}
+}
@@ -1,32 +1,185 @@
 package server
 
-import "fmt"
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rest-go/rest/pkg/auth"
+	"github.com/rest-go/rest/pkg/sql"
+)
 
 type Config struct {
-	DB   DBConfig
-	Auth AuthConfig
-	Cors CorsConfig
+	DB      DBConfig
+	Auth    AuthConfig
+	Cors    CorsConfig
+	Queries map[string]QueryConfig
+	Timeout TimeoutConfig
+	Tables  TablesConfig
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("db: %s, auth: %s, cors: %s", c.DB, c.Auth, c.Cors)
+	return fmt.Sprintf("db: %s, auth: %s, cors: %s, queries: %v, timeout: %s, tables: %s",
+		c.DB, c.Auth, c.Cors, c.Queries, c.Timeout, c.Tables)
 }
 
 type DBConfig struct {
 	URL string
+	// Replicas are urls of read replicas, reads are routed to replicas in
+	// round-robin unless `Prefer: consistency=strong` is set
+	Replicas []string
+	// MaxQueryCost rejects read queries whose cost estimated by the query
+	// planner exceeds it, only PG is supported, 0 means no limit
+	MaxQueryCost float64 `yaml:"max_query_cost"`
+
+	// connection pool, see database/sql DB.SetMaxOpenConns etc.
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	SQLite   SQLiteConfig
+	Postgres PostgresConfig
 }
 
 func (c DBConfig) String() string {
-	return fmt.Sprintf("{url: %s}", c.URL)
+	return fmt.Sprintf("{url: %s, replicas: %d, max_query_cost: %v, max_open_conns: %d, max_idle_conns: %d, "+
+		"conn_max_lifetime: %s, conn_max_idle_time: %s, sqlite: %s, postgres: %s}",
+		c.URL, len(c.Replicas), c.MaxQueryCost, c.MaxOpenConns, c.MaxIdleConns,
+		c.ConnMaxLifetime, c.ConnMaxIdleTime, c.SQLite, c.Postgres)
+}
+
+// options returns driver options to open the database
+func (c DBConfig) options() []sql.Option {
+	var opts []sql.Option
+	if c.SQLite.JournalMode != "" {
+		opts = append(opts, sql.Pragma("journal_mode", c.SQLite.JournalMode))
+	}
+	if c.SQLite.ForeignKeys != nil {
+		opts = append(opts, sql.Pragma("foreign_keys", strconv.FormatBool(*c.SQLite.ForeignKeys)))
+	}
+	if c.SQLite.Synchronous != "" {
+		opts = append(opts, sql.Pragma("synchronous", c.SQLite.Synchronous))
+	}
+	if c.Postgres.ApplicationName != "" {
+		opts = append(opts, sql.RuntimeParam("application_name", c.Postgres.ApplicationName))
+	}
+	if c.Postgres.SearchPath != "" {
+		opts = append(opts, sql.RuntimeParam("search_path", c.Postgres.SearchPath))
+	}
+	return opts
+}
+
+// SQLiteConfig is pragmas set on every SQLite connection
+// https://www.sqlite.org/pragma.html
+type SQLiteConfig struct {
+	JournalMode string `yaml:"journal_mode"` // e.g. WAL
+	ForeignKeys *bool  `yaml:"foreign_keys"`
+	Synchronous string // e.g. NORMAL
+}
+
+func (c SQLiteConfig) String() string {
+	foreignKeys := "default"
+	if c.ForeignKeys != nil {
+		foreignKeys = strconv.FormatBool(*c.ForeignKeys)
+	}
+	return fmt.Sprintf("{journal_mode: %s, foreign_keys: %s, synchronous: %s}",
+		c.JournalMode, foreignKeys, c.Synchronous)
+}
+
+// PostgresConfig is run-time parameters set on every PG connection
+type PostgresConfig struct {
+	ApplicationName string `yaml:"application_name"`
+	SearchPath      string `yaml:"search_path"`
+}
+
+func (c PostgresConfig) String() string {
+	return fmt.Sprintf("{application_name: %s, search_path: %s}", c.ApplicationName, c.SearchPath)
 }
 
 type AuthConfig struct {
 	Enabled bool
 	Secret  string
+	// AccessTokenTTL and RefreshTokenTTL are lifetimes of access tokens and
+	// sessions, 15m and 14 days by default
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// PrivateKey is the path to a PEM private key to sign tokens with
+	// RS256/ES256/EdDSA instead of the secret, KeyID is derived from the
+	// public key if it's empty
+	PrivateKey string `yaml:"private_key"`
+	KeyID      string `yaml:"key_id"`
+	// Issuer and Audience are `iss` and `aud` claims of issued tokens, the
+	// issuer is "rest" by default, the audience is also required in tokens
+	// of JWKS sources which don't set their own
+	Issuer   string
+	Audience string
+	// JWKS are files or urls of other identity providers whose tokens are
+	// accepted, their tokens must be issued by the issuer of the source
+	JWKS []auth.JWKSSource `yaml:"jwks"`
+	// OAuth are OpenID Connect providers to login with
+	OAuth []auth.OAuthProvider `yaml:"oauth"`
+	// RLS delegates authorization to PostgreSQL row-level security
+	RLS RLSConfig `yaml:"rls"`
+	// SMTP sends password reset and email verification tokens, they are
+	// not enabled if its addr is empty, PasswordResetTTL is 1 hour and
+	// VerificationTTL is 24 hours by default
+	SMTP             auth.SMTPNotifier `yaml:"smtp"`
+	PasswordResetTTL time.Duration     `yaml:"password_reset_ttl"`
+	VerificationTTL  time.Duration     `yaml:"verification_ttl"`
+	// RequireVerification blocks login until the email is verified
+	RequireVerification bool `yaml:"require_verification"`
 }
 
 func (c AuthConfig) String() string {
-	return fmt.Sprintf("{enabled: %v, secret:xxx}", c.Enabled)
+	return fmt.Sprintf("{enabled: %v, secret:xxx, access_token_ttl: %s, refresh_token_ttl: %s, "+
+		"private_key: %s, key_id: %s, issuer: %s, audience: %s, jwks: %v, oauth: %v, rls: %v, smtp: %s, password_reset_ttl: %s, "+
+		"verification_ttl: %s, require_verification: %v}",
+		c.Enabled, c.AccessTokenTTL, c.RefreshTokenTTL, c.PrivateKey, c.KeyID, c.Issuer, c.Audience, c.JWKS, c.oauthNames(), c.RLS,
+		c.SMTP.Addr, c.PasswordResetTTL, c.VerificationTTL, c.RequireVerification)
+}
+
+// oauthNames returns names of the providers to avoid printing secrets
+func (c AuthConfig) oauthNames() []string {
+	names := make([]string, 0, len(c.OAuth))
+	for i := range c.OAuth {
+		names = append(names, c.OAuth[i].Name)
+	}
+	return names
+}
+
+// RLSConfig delegates authorization to PostgreSQL row-level security, each
+// request runs in a transaction as the role in RoleClaim of the token, or
+// AuthenticatedRole and AnonymousRole by default, with the claims in
+// `request.jwt.claims`, auth_policies are not checked in this mode. The
+// database user must be granted the roles, e.g. `GRANT anon TO rest`
+type RLSConfig struct {
+	Enabled           bool
+	RoleClaim         string `yaml:"role_claim"`         // "role" by default
+	AnonymousRole     string `yaml:"anonymous_role"`     // "anon" by default
+	AuthenticatedRole string `yaml:"authenticated_role"` // "authenticated" by default
+}
+
+func (c RLSConfig) String() string {
+	return fmt.Sprintf("{enabled: %v, role_claim: %s, anonymous_role: %s, authenticated_role: %s}",
+		c.Enabled, c.RoleClaim, c.AnonymousRole, c.AuthenticatedRole)
+}
+
+// withDefaults returns the config with default roles and claim
+func (c RLSConfig) withDefaults() *RLSConfig {
+	if c.RoleClaim == "" {
+		c.RoleClaim = defaultRoleClaim
+	}
+	if c.AnonymousRole == "" {
+		c.AnonymousRole = defaultAnonymousRole
+	}
+	if c.AuthenticatedRole == "" {
+		c.AuthenticatedRole = defaultAuthenticatedRole
+	}
+	return &c
 }
 
 type CorsConfig struct {
@@ -37,3 +190,70 @@ type CorsConfig struct {
 func (c CorsConfig) String() string {
 	return fmt.Sprintf("{enabled: %v, origins: %v}", c.Enabled, c.Origins)
 }
+
+// TimeoutConfig is the statement timeout of requests, Default applies to all
+// tables unless it's overridden in Tables, clients can ask for a different
+// timeout by `Prefer: timeout=5s` which is capped by Max, Max caps configured
+// timeouts as well if it's set, otherwise timeouts asked by clients are capped
+// by 2 minutes
+type TimeoutConfig struct {
+	Default time.Duration
+	Max     time.Duration
+	Tables  map[string]time.Duration
+}
+
+func (c TimeoutConfig) String() string {
+	return fmt.Sprintf("{default: %s, max: %s, tables: %v}", c.Default, c.Max, c.Tables)
+}
+
+// TablesConfig limits the tables exposed by the server, all the tables are
+// exposed by default, names can be glob patterns like `auth_*` and Exclude
+// takes precedence over Include
+type TablesConfig struct {
+	Include []string
+	Exclude []string
+}
+
+func (c TablesConfig) String() string {
+	return fmt.Sprintf("{include: %v, exclude: %v}", c.Include, c.Exclude)
+}
+
+// IsExposed returns whether a table is exposed
+func (c TablesConfig) IsExposed(table string) bool {
+	if matchAny(c.Exclude, table) {
+		return false
+	}
+	return len(c.Include) == 0 || matchAny(c.Include, table)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryConfig is a named query with parameters, e.g.
+// `SELECT * FROM customers WHERE region = :region`, parameters are strings
+// unless their types are specified in Params, supported types are string,
+// int, float and bool
+type QueryConfig struct {
+	SQL    string
+	Params map[string]string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler to support a plain SQL string
+func (c *QueryConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		c.SQL = value.Value
+		return nil
+	}
+	type plain QueryConfig
+	return value.Decode((*plain)(c))
+}
+
+func (c QueryConfig) String() string {
+	return fmt.Sprintf("{sql: %s, params: %v}", c.SQL, c.Params)
+}
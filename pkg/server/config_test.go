@@ -1,6 +1,12 @@
 package server
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
 
 func TestConfig(t *testing.T) {
 	config := Config{
@@ -10,3 +16,65 @@ func TestConfig(t *testing.T) {
 	}
 	t.Log(config.String())
 }
+
+func TestQueryConfig(t *testing.T) {
+	data := `
+top_customers: "SELECT * FROM customers WHERE region = :region"
+large_orders:
+  sql: "SELECT * FROM orders WHERE total > :min_total"
+  params:
+    min_total: float
+`
+	var queries map[string]QueryConfig
+	err := yaml.Unmarshal([]byte(data), &queries)
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT * FROM customers WHERE region = :region", queries["top_customers"].SQL)
+	assert.Equal(t, "float", queries["large_orders"].Params["min_total"])
+
+	_, err = compileQuery("large_orders", queries["large_orders"])
+	assert.Nil(t, err)
+	_, err = compileQuery("invalid_type", QueryConfig{SQL: "SELECT :a", Params: map[string]string{"a": "date"}})
+	assert.NotNil(t, err)
+	_, err = compileQuery("unused_param", QueryConfig{SQL: "SELECT 1", Params: map[string]string{"a": "int"}})
+	assert.NotNil(t, err)
+}
+
+func TestDBConfig(t *testing.T) {
+	data := `
+url: sqlite://my.db
+max_open_conns: 10
+conn_max_lifetime: 1h
+sqlite:
+  journal_mode: WAL
+  foreign_keys: true
+postgres:
+  application_name: rest
+`
+	var config DBConfig
+	err := yaml.Unmarshal([]byte(data), &config)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, config.MaxOpenConns)
+	assert.Equal(t, time.Hour, config.ConnMaxLifetime)
+	assert.Equal(t, "WAL", config.SQLite.JournalMode)
+	assert.True(t, *config.SQLite.ForeignKeys)
+	assert.Equal(t, "rest", config.Postgres.ApplicationName)
+	assert.Equal(t, 3, len(config.options()))
+	t.Log(config.String())
+}
+
+func TestTablesConfig(t *testing.T) {
+	for _, test := range []struct {
+		config  TablesConfig
+		table   string
+		exposed bool
+	}{
+		{config: TablesConfig{}, table: "customers", exposed: true},
+		{config: TablesConfig{Include: []string{"customers"}}, table: "customers", exposed: true},
+		{config: TablesConfig{Include: []string{"customers"}}, table: "invoices", exposed: false},
+		{config: TablesConfig{Exclude: []string{"auth_*"}}, table: "auth_users", exposed: false},
+		{config: TablesConfig{Exclude: []string{"auth_*"}}, table: "invoices", exposed: true},
+		{config: TablesConfig{Include: []string{"*"}, Exclude: []string{"invoices"}}, table: "invoices", exposed: false},
+	} {
+		assert.Equal(t, test.exposed, test.config.IsExposed(test.table), test)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PreferHeader       = "Prefer"
+	RangeHeader        = "Range"
+	RangeUnitHeader    = "Range-Unit"
+	ContentRangeHeader = "Content-Range"
+	LinkHeader         = "Link"
+
+	rangeUnitItems = "items"
+)
+
+// Count modes in `Prefer: count=xxx` header
+const (
+	CountExact     = "exact"
+	CountPlanned   = "planned"
+	CountEstimated = "estimated"
+)
+
+// Preferences of `Prefer` header for writes
+const (
+	// ResolutionMergeDuplicates updates the existing row on conflict of the
+	// primary key
+	ResolutionMergeDuplicates = "merge-duplicates"
+	// ReturnRepresentation returns the created rows
+	ReturnRepresentation = "representation"
+)
+
+// parsePrefer parses all the `Prefer` headers into a map, e.g.
+// `Prefer: count=exact, return=minimal` => {count: exact, return: minimal}
+// preferences without value are set to an empty string
+func parsePrefer(r *http.Request) map[string]string {
+	prefs := map[string]string{}
+	for _, header := range r.Header.Values(PreferHeader) {
+		for _, pref := range strings.Split(header, ",") {
+			// ignore parameters of a preference, e.g. `;foo=bar`
+			pref = strings.TrimSpace(strings.SplitN(pref, ";", 2)[0])
+			if pref == "" {
+				continue
+			}
+			kv := strings.SplitN(pref, "=", 2)
+			key, val := strings.ToLower(strings.TrimSpace(kv[0])), ""
+			if len(kv) == 2 {
+				val = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			}
+			prefs[key] = val
+		}
+	}
+	return prefs
+}
+
+// parseTimeout parses the timeout preference, e.g. `Prefer: timeout=5s`, a
+// number without unit is in seconds
+func parseTimeout(val string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(val, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	timeout, err := time.ParseDuration(val)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout: %s", val)
+	}
+	return timeout, nil
+}
+
+// parseRange parses `Range: 0-99` or `Range: items=0-99` header with optional
+// `Range-Unit: items` header, it returns ok=false if there is no range header
+// and limit=0 if the range is open-ended, e.g. `Range: 10-`
+func parseRange(r *http.Request) (offset, limit int, ok bool, err error) {
+	rangeVal := r.Header.Get(RangeHeader)
+	if rangeVal == "" {
+		return 0, 0, false, nil
+	}
+	unit := r.Header.Get(RangeUnitHeader)
+	if parts := strings.SplitN(rangeVal, "=", 2); len(parts) == 2 {
+		unit, rangeVal = parts[0], parts[1]
+	}
+	if unit != "" && !strings.EqualFold(unit, rangeUnitItems) {
+		return 0, 0, false, fmt.Errorf("unsupported range unit: %s", unit)
+	}
+
+	bounds := strings.SplitN(strings.TrimSpace(rangeVal), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false, fmt.Errorf("invalid range: %s", rangeVal)
+	}
+	offset, err = strconv.Atoi(bounds[0])
+	if err != nil || offset < 0 {
+		return 0, 0, false, fmt.Errorf("invalid range start: %s", bounds[0])
+	}
+	if bounds[1] == "" {
+		return offset, 0, true, nil
+	}
+	end, err := strconv.Atoi(bounds[1])
+	if err != nil || end < offset {
+		return 0, 0, false, fmt.Errorf("invalid range end: %s", bounds[1])
+	}
+	return offset, end - offset + 1, true, nil
+}
+
+// contentRange returns value for `Content-Range` header, e.g. `0-99/1234`,
+// total is unknown when it's negative
+func contentRange(offset, length int, total int64) string {
+	totalStr := "*"
+	if total >= 0 {
+		totalStr = strconv.FormatInt(total, 10)
+	}
+	if length == 0 {
+		return fmt.Sprintf("*/%s", totalStr)
+	}
+	return fmt.Sprintf("%d-%d/%s", offset, offset+length-1, totalStr)
+}
+
+// linkHeader returns value for `Link` header with next and prev pages, e.g.
+// `</todos?page=3&page_size=10>; rel="next", </todos?page=1&page_size=10>; rel="prev"`
+func linkHeader(u *url.URL, page, pageSize int, hasMore bool) string {
+	links := make([]string, 0, 2) //nolint:gomnd
+	pageURL := func(p int) string {
+		values := u.Query()
+		values.Set("page", strconv.Itoa(p))
+		values.Set("page_size", strconv.Itoa(pageSize))
+		return (&url.URL{Path: u.Path, RawQuery: values.Encode()}).String()
+	}
+	if hasMore {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(page+1)))
+	}
+	if page > 1 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(page-1)))
+	}
+	return strings.Join(links, ", ")
+}
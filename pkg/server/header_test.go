@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrefer(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("Prefer", "count=exact, return=minimal")
+	req.Header.Add("Prefer", `timeout="5s"; foo=bar, envelope`)
+	prefs := parsePrefer(req)
+	assert.Equal(t, map[string]string{
+		"count":    "exact",
+		"return":   "minimal",
+		"timeout":  "5s",
+		"envelope": "",
+	}, prefs)
+}
+
+func TestParseTimeout(t *testing.T) {
+	for _, test := range []struct {
+		val     string
+		timeout time.Duration
+		hasErr  bool
+	}{
+		{val: "5s", timeout: 5 * time.Second},
+		{val: "500ms", timeout: 500 * time.Millisecond},
+		{val: "10", timeout: 10 * time.Second},
+		{val: "0.5", timeout: 500 * time.Millisecond},
+		{val: "0", hasErr: true},
+		{val: "-1s", hasErr: true},
+		{val: "abc", hasErr: true},
+	} {
+		timeout, err := parseTimeout(test.val)
+		if test.hasErr {
+			assert.NotNil(t, err, test.val)
+			continue
+		}
+		assert.Nil(t, err, test.val)
+		assert.Equal(t, test.timeout, timeout, test.val)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		rangeVal  string
+		rangeUnit string
+		offset    int
+		limit     int
+		ok        bool
+		hasErr    bool
+	}{
+		{rangeVal: "", ok: false},
+		{rangeVal: "0-99", offset: 0, limit: 100, ok: true},
+		{rangeVal: "10-19", rangeUnit: "items", offset: 10, limit: 10, ok: true},
+		{rangeVal: "items=10-19", offset: 10, limit: 10, ok: true},
+		{rangeVal: "10-", offset: 10, limit: 0, ok: true},
+		{rangeVal: "bytes=0-99", hasErr: true},
+		{rangeVal: "0-99", rangeUnit: "bytes", hasErr: true},
+		{rangeVal: "10-9", hasErr: true},
+		{rangeVal: "a-9", hasErr: true},
+		{rangeVal: "10", hasErr: true},
+	} {
+		t.Run(test.rangeVal, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Range", test.rangeVal)
+			if test.rangeUnit != "" {
+				req.Header.Set("Range-Unit", test.rangeUnit)
+			}
+			offset, limit, ok, err := parseRange(req)
+			if test.hasErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.offset, offset)
+			assert.Equal(t, test.limit, limit)
+		})
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	assert.Equal(t, "0-99/1234", contentRange(0, 100, 1234))
+	assert.Equal(t, "10-19/*", contentRange(10, 10, -1))
+	assert.Equal(t, "*/0", contentRange(0, 0, 0))
+}
+
+func TestLinkHeader(t *testing.T) {
+	u, _ := url.Parse("/api/todos?done=eq.true&page=2")
+	assert.Equal(t,
+		`</api/todos?done=eq.true&page=3&page_size=10>; rel="next", </api/todos?done=eq.true&page=1&page_size=10>; rel="prev"`,
+		linkHeader(u, 2, 10, true))
+	assert.Equal(t, `</api/todos?done=eq.true&page=1&page_size=10>; rel="prev"`, linkHeader(u, 2, 10, false))
+	assert.Equal(t, "", linkHeader(u, 1, 10, false))
+}
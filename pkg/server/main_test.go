@@ -51,6 +51,22 @@ CREATE TABLE IF NOT EXISTS "auth_policies"
 );
 INSERT INTO auth_policies VALUES (1, "d", "articles", "all", "userid=auth_user.id");
 INSERT INTO auth_policies VALUES (2, "d", "auth_policies", "all", "auth_user.is_admin");
+INSERT INTO auth_policies VALUES (3, "d", "documents", "all",
+	'org_id = auth_user.claims.org_id AND (public OR owner_id = auth_user.id)');
+INSERT INTO auth_policies VALUES (4, "d", "profiles", "all", "user_id = auth_user.id");
+
+DROP TABLE IF EXISTS "auth_column_policies";
+CREATE TABLE IF NOT EXISTS "auth_column_policies"
+(
+	id INTEGER PRIMARY KEY,
+	description VARCHAR(256) NOT NULL,
+	table_name VARCHAR(128) NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	columns VARCHAR(1024) NOT NULL,
+	expression VARCHAR(256) NOT NULL
+);
+INSERT INTO auth_column_policies VALUES (1, "d", "profiles", "all", "id,user_id,name", "NOT auth_user.is_admin");
+INSERT INTO auth_column_policies VALUES (2, "d", "profiles", "read", "is_verified", "NOT auth_user.is_admin");
 
 DROP TABLE IF EXISTS "articles";
 CREATE TABLE IF NOT EXISTS "articles"
@@ -58,6 +74,32 @@ CREATE TABLE IF NOT EXISTS "articles"
     [Title] NVARCHAR(40)  NOT NULL,
     [UserID] INTEGER  NOT NULL
 );
+
+DROP TABLE IF EXISTS "documents";
+CREATE TABLE IF NOT EXISTS "documents"
+(
+    [id] INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    [title] NVARCHAR(40)  NOT NULL,
+    [org_id] INTEGER  NOT NULL,
+    [owner_id] INTEGER,
+    [public] BOOL NOT NULL DEFAULT FALSE
+);
+INSERT INTO documents (title, org_id, owner_id, public) VALUES
+	('public of org 1', 1, 2, TRUE),
+	('private of user 1', 1, 1, FALSE),
+	('private of user 2', 1, 2, FALSE),
+	('public of org 2', 2, 3, TRUE);
+
+DROP TABLE IF EXISTS "profiles";
+CREATE TABLE IF NOT EXISTS "profiles"
+(
+    [id] INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    [user_id] INTEGER NOT NULL,
+    [name] NVARCHAR(40) NOT NULL,
+    [is_verified] BOOL NOT NULL DEFAULT FALSE,
+    [credit_limit] INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO profiles (user_id, name, is_verified, credit_limit) VALUES (1, 'user 1', TRUE, 100);
 `
 )
 
@@ -136,17 +178,36 @@ func requestHandler(h http.Handler, token, method, target string, body io.Reader
 	if token != "" {
 		req.Header.Add(auth.AuthorizationHeader, "Bearer "+token)
 	}
+	res, resData, err := serve(h, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.StatusCode, resData, nil
+}
+
+// requestWithHeader sends request with headers to the test server and
+// returns the response along with the decoded json body
+func requestWithHeader(method, target string, header http.Header, body io.Reader) (*http.Response, any, error) {
+	req := httptest.NewRequest(method, target, body)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return serve(testServer, req)
+}
+
+func serve(h http.Handler, req *http.Request) (*http.Response, any, error) {
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, req)
 	res := w.Result()
 	defer res.Body.Close()
 	data, err := io.ReadAll(res.Body)
 	if err != nil {
-		return 0, nil, err
+		return nil, nil, err
 	}
 
+	var resData any
 	err = json.Unmarshal(data, &resData)
-	return res.StatusCode, resData, err
+	return res, resData, err
 }
 
 func assertLength(t *testing.T, length int, data any) {
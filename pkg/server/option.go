@@ -13,3 +13,34 @@ func Prefix(prefix string) Option {
 		s.prefix = prefix
 	}
 }
+
+// Queries exposes named queries on `/_queries/{name}`
+func Queries(queries map[string]QueryConfig) Option {
+	return func(s *Server) {
+		s.queryConfigs = queries
+	}
+}
+
+// Timeout sets statement timeouts of requests
+func Timeout(config TimeoutConfig) Option {
+	return func(s *Server) {
+		s.timeout = config
+	}
+}
+
+// Tables limits the tables exposed by the server
+func Tables(config TablesConfig) Option {
+	return func(s *Server) {
+		s.tablesConfig = config
+	}
+}
+
+// RowLevelSecurity delegates authorization to PostgreSQL row-level security
+// if it's enabled, see RLSConfig
+func RowLevelSecurity(config RLSConfig) Option {
+	return func(s *Server) {
+		if config.Enabled {
+			s.rls = config.withDefaults()
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rest-go/rest/pkg/auth"
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+// queriesPrefix is the path prefix to run named queries, e.g.
+// GET /_queries/top_customers?region=EU
+const queriesPrefix = "_queries"
+
+// paramConverters convert url query values to typed query parameters
+var paramConverters = map[string]func(string) (any, error){
+	"string": func(v string) (any, error) { return v, nil },
+	"int":    func(v string) (any, error) { return strconv.ParseInt(v, 10, 64) },
+	"float":  func(v string) (any, error) { return strconv.ParseFloat(v, 64) },
+	"bool":   func(v string) (any, error) { return strconv.ParseBool(v) },
+}
+
+// namedQuery is a compiled QueryConfig
+type namedQuery struct {
+	name   string
+	query  string   // query with `?` placeholders
+	params []string // parameter names in order of placeholders
+	types  map[string]string
+}
+
+func compileQuery(name string, config QueryConfig) (*namedQuery, error) {
+	if config.SQL == "" {
+		return nil, fmt.Errorf("empty sql for query %s", name)
+	}
+	query, params := sql.CompileNamedQuery(strings.TrimRight(strings.TrimSpace(config.SQL), ";"))
+	types := make(map[string]string, len(params))
+	for _, param := range params {
+		types[param] = "string"
+	}
+	for param, typ := range config.Params {
+		if _, ok := types[param]; !ok {
+			return nil, fmt.Errorf("param %s is not used in query %s", param, name)
+		}
+		if _, ok := paramConverters[typ]; !ok {
+			return nil, fmt.Errorf("unsupported type %s of param %s in query %s", typ, param, name)
+		}
+		types[param] = typ
+	}
+	return &namedQuery{name, query, params, types}, nil
+}
+
+// args converts values in url query to args of the query, the parameters are
+// removed from the values so that they are not treated as filters
+func (q *namedQuery) args(values map[string][]string) ([]any, error) {
+	args := make([]any, 0, len(q.params))
+	for _, param := range q.params {
+		v, ok := values[param]
+		if !ok || len(v) == 0 {
+			return nil, fmt.Errorf("missing param: %s", param)
+		}
+		arg, err := paramConverters[q.types[param]](v[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid param %s, expect %s", param, q.types[param])
+		}
+		args = append(args, arg)
+	}
+	for param := range q.types {
+		delete(values, param)
+	}
+	return args, nil
+}
+
+// runQuery runs a named query, the query is wrapped as a sub query so that
+// select, filters and pagination in the url query are applied to the result
+func (s *Server) runQuery(w http.ResponseWriter, r *http.Request, name string) any {
+	if r.Method != http.MethodGet {
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+	query, ok := s.queries[name]
+	if !ok {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  fmt.Sprintf("query does not exist: %s", name),
+		}
+	}
+
+	// policies of queries are defined on `_queries/<name>`
+	authInfo, res := s.authorize(r, queriesPrefix+"/"+name, auth.ActionRead)
+	if res != nil {
+		return res
+	}
+
+	values := r.URL.Query()
+	args, err := query.args(values)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	urlQuery := sql.NewURLQuery(values, s.db.Dialect())
+	src := &source{from: fmt.Sprintf("(%s) AS %s", query.query, name), args: args, db: s.reader(r)}
+	return s.get(w, r, src, urlQuery, authInfo)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+// ConsistencyStrong is the `Prefer: consistency=strong` preference to read
+// from the primary database, e.g. to read data right after a write
+const ConsistencyStrong = "strong"
+
+// replicaCheckInterval is the interval to check health of replicas
+const replicaCheckInterval = 5 * time.Second
+
+type replica struct {
+	db      *sql.DB
+	url     string
+	healthy int32 // 1 if the last health check succeeded
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// replicaSet picks healthy read replicas in round-robin
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+}
+
+// newReplicaSet connects to the replicas, replicas which are down are
+// unhealthy until the health check brings them back
+func newReplicaSet(config *DBConfig) *replicaSet {
+	rs := &replicaSet{}
+	for _, url := range config.Replicas {
+		db, err := sql.Connect(url, config.options()...)
+		if err != nil {
+			log.Fatal(fmt.Errorf("open replica %s error: %w", url, err))
+		}
+		setPool(db, config)
+		r := &replica{db: db, url: url, healthy: 1}
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+		if err := db.PingContext(ctx); err != nil {
+			log.Warnf("replica %s is down: %v", url, err)
+			r.healthy = 0
+		}
+		cancel()
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs
+}
+
+// close closes the connections of all the replicas
+func (rs *replicaSet) close() {
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
+
+// get returns the next healthy replica, it returns nil if there is no
+// healthy replica
+func (rs *replicaSet) get() *sql.DB {
+	n := uint32(len(rs.replicas))
+	for i := uint32(0); i < n; i++ {
+		r := rs.replicas[atomic.AddUint32(&rs.next, 1)%n]
+		if r.isHealthy() {
+			return r.db
+		}
+	}
+	return nil
+}
+
+// check pings all the replicas and updates their health
+func (rs *replicaSet) check() {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+		err := r.db.PingContext(ctx)
+		cancel()
+		healthy := int32(1)
+		if err != nil {
+			healthy = 0
+		}
+		if atomic.SwapInt32(&r.healthy, healthy) != healthy {
+			if err != nil {
+				log.Warnf("replica %s is down: %v", r.url, err)
+			} else {
+				log.Infof("replica %s is up", r.url)
+			}
+		}
+	}
+}
+
+// watch checks health of replicas periodically until done is closed
+func (rs *replicaSet) watch(done <-chan struct{}) {
+	if len(rs.replicas) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				rs.check()
+			}
+		}
+	}()
+}
+
+// reader returns the database to read from, a healthy replica is used unless
+// strong consistency is preferred by the request
+func (s *Server) reader(r *http.Request) *sql.DB {
+	if parsePrefer(r)["consistency"] == ConsistencyStrong {
+		return s.db
+	}
+	if db := s.replicas.get(); db != nil {
+		return db
+	}
+	return s.db
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+func TestReplicaSet(t *testing.T) {
+	dbs := []*sql.DB{{DriverName: "r1"}, {DriverName: "r2"}, {DriverName: "r3"}}
+	rs := &replicaSet{}
+	for _, db := range dbs {
+		rs.replicas = append(rs.replicas, &replica{db: db, healthy: 1})
+	}
+	got := map[*sql.DB]int{}
+	for i := 0; i < 6; i++ {
+		got[rs.get()]++
+	}
+	assert.Equal(t, map[*sql.DB]int{dbs[0]: 2, dbs[1]: 2, dbs[2]: 2}, got)
+
+	rs.replicas[1].healthy = 0
+	for i := 0; i < 6; i++ {
+		assert.NotEqual(t, dbs[1], rs.get())
+	}
+
+	for _, r := range rs.replicas {
+		r.healthy = 0
+	}
+	assert.Nil(t, rs.get())
+	assert.Nil(t, (&replicaSet{}).get())
+}
+
+func TestServerReplica(t *testing.T) {
+	replicaURL := "sqlite://ci.db-replica"
+	replica, err := sql.Open(replicaURL)
+	assert.Nil(t, err)
+	defer replica.Close()
+	_, err = replica.ExecQuery(context.Background(), `
+		DROP TABLE IF EXISTS customers;
+		CREATE TABLE customers (Id INTEGER PRIMARY KEY, FirstName TEXT);
+		INSERT INTO customers VALUES (1, 'from replica'), (2, 'from replica');
+	`)
+	assert.Nil(t, err)
+
+	s := New(&DBConfig{URL: "sqlite://ci.db", Replicas: []string{replicaURL}})
+	defer s.Close()
+
+	code, data, err := requestHandler(s, "", http.MethodGet, "/customers?count", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), data)
+
+	req := httptest.NewRequest(http.MethodGet, "/customers?count", nil)
+	req.Header.Set("Prefer", "consistency=strong")
+	_, data, err = serve(s, req)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), data)
+
+	// fall back to the primary if replicas are down
+	s.replicas.replicas[0].healthy = 0
+	code, data, err = requestHandler(s, "", http.MethodGet, "/customers?count", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), data)
+
+	// health check brings it back
+	s.replicas.check()
+	assert.True(t, s.replicas.replicas[0].isHealthy())
+	replica.Close()
+	s.replicas.replicas[0].db.Close()
+	s.replicas.check()
+	assert.False(t, s.replicas.replicas[0].isHealthy())
+}
+
+func TestReplicaDown(t *testing.T) {
+	// a replica which can't be connected doesn't stop the server
+	rs := newReplicaSet(&DBConfig{Replicas: []string{"sqlite://file:test-replica.db?mode=ro"}})
+	defer rs.close()
+	assert.Equal(t, 1, len(rs.replicas))
+	assert.False(t, rs.replicas[0].isHealthy())
+	assert.Nil(t, rs.get())
+}
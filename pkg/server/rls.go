@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+
+	"github.com/rest-go/rest/pkg/auth"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	defaultRoleClaim         = "role"
+	defaultAnonymousRole     = "anon"
+	defaultAuthenticatedRole = "authenticated"
+)
+
+// role returns the database role and the claims of the user, claims of API
+// key users are made up from the user
+func (c *RLSConfig) role(user *auth.User) (string, map[string]any) {
+	claims := user.Claims
+	if claims == nil && user.IsAuthenticated() {
+		claims = map[string]any{
+			"user_id":  user.ID,
+			"username": user.Username,
+			"is_admin": user.IsAdmin,
+			"roles":    user.Roles,
+		}
+	}
+	if role, ok := claims[c.RoleClaim].(string); ok && role != "" {
+		return role, claims
+	}
+	if user.IsAuthenticated() {
+		return c.AuthenticatedRole, claims
+	}
+	return c.AnonymousRole, claims
+}
+
+// withRole returns a context running queries as the role of the user
+func (c *RLSConfig) withRole(ctx context.Context, user *auth.User) (context.Context, error) {
+	role, claims := c.role(user)
+	return sql.WithRole(ctx, role, claims)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rest-go/rest/pkg/auth"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+func TestRLSConfigRole(t *testing.T) {
+	config := RLSConfig{Enabled: true, AnonymousRole: "web_anon"}.withDefaults()
+	assert.Equal(t, "role", config.RoleClaim)
+	assert.Equal(t, "authenticated", config.AuthenticatedRole)
+
+	for _, test := range []struct {
+		name   string
+		user   *auth.User
+		role   string
+		claims map[string]any
+	}{
+		{
+			name: "anonymous user",
+			user: &auth.User{},
+			role: "web_anon",
+		},
+		{
+			name:   "authenticated user",
+			user:   &auth.User{ID: 1, Claims: map[string]any{"user_id": float64(1)}},
+			role:   "authenticated",
+			claims: map[string]any{"user_id": float64(1)},
+		},
+		{
+			name:   "role in claims",
+			user:   &auth.User{ID: 1, Claims: map[string]any{"user_id": float64(1), "role": "editor"}},
+			role:   "editor",
+			claims: map[string]any{"user_id": float64(1), "role": "editor"},
+		},
+		{
+			name: "api key user",
+			user: &auth.User{ID: 2, Username: "service", Roles: []string{"support"}},
+			role: "authenticated",
+			claims: map[string]any{
+				"user_id": int64(2), "username": "service", "is_admin": false, "roles": []string{"support"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			role, claims := config.role(test.user)
+			assert.Equal(t, test.role, role)
+			assert.Equal(t, test.claims, claims)
+		})
+	}
+}
+
+func TestServerRLS(t *testing.T) {
+	// policies are left to the database
+	s := &Server{authEnabled: true, rls: RLSConfig{Enabled: true}.withDefaults()}
+	req := httptest.NewRequest(http.MethodGet, "/auth_users", http.NoBody)
+	info, res := s.authorize(req, "auth_users", auth.ActionRead)
+	assert.Nil(t, info)
+	assert.Nil(t, res)
+
+	// scopes of API keys still apply
+	user := &auth.User{ID: 1, Username: "service", Scopes: []string{"todos:read"}}
+	req = req.WithContext(context.WithValue(req.Context(), auth.AuthUserKey, user))
+	info, res = s.authorize(req, "todos", auth.ActionRead)
+	assert.Nil(t, info)
+	assert.Nil(t, res)
+	for _, test := range []struct {
+		resource string
+		action   auth.Action
+	}{
+		{"todos", auth.ActionUpdate},
+		{"todos", auth.ActionDelete},
+		{"auth_users", auth.ActionRead},
+	} {
+		_, res = s.authorize(req, test.resource, test.action)
+		assert.NotNil(t, res, test.resource)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	}
+	_, res = s.returning(req, &sql.Table{Name: "documents"})
+	assert.NotNil(t, res)
+
+	// invalid roles are rejected before querying
+	user = &auth.User{ID: 1, Claims: map[string]any{"role": "admin; RESET ROLE"}}
+	_, err := s.rls.withRole(req.Context(), user)
+	assert.NotNil(t, err)
+}
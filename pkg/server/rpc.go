@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rest-go/rest/pkg/auth"
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+// rpcPrefix is the path prefix to call stored functions and procedures, e.g.
+// POST /rpc/my_function
+const rpcPrefix = "rpc"
+
+// rpc calls a stored function or procedure with named arguments in the JSON
+// body, set-returning functions are read like tables so that select, filters
+// and pagination in the url query are applied to the result
+func (s *Server) rpc(w http.ResponseWriter, r *http.Request, name string) any {
+	if r.Method != http.MethodPost {
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+	routine, ok := s.getRoutines()[name]
+	if !ok {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  fmt.Sprintf("routine does not exist: %s", name),
+		}
+	}
+
+	// policies of routines are defined on `rpc/<name>`
+	authInfo, res := s.authorize(r, rpcPrefix+"/"+name, auth.ActionExecute)
+	if res != nil {
+		return res
+	}
+
+	args := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil && !errors.Is(err, io.EOF) {
+		log.Warnf("failed to parse rpc json data: %v", err)
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  fmt.Sprintf("failed to parse rpc json data, %v", err),
+		}
+	}
+
+	isTableLike := s.db.Dialect().TableFunctions() && routine.Type == sql.RoutineFunction && routine.ReturnsSet
+	if authInfo != nil && authInfo.cond != nil {
+		// call for current auth user, e.g. set owner_id for `owner_id = auth_user.id`
+		for column, val := range authInfo.cond.Defaults() {
+			if routine.HasParam(column) {
+				args[column] = val
+			}
+		}
+		if authInfo.cond.Allows(args) {
+			authInfo.cond = nil
+		} else if !isTableLike {
+			return &j.Response{
+				Code: http.StatusForbidden,
+				Msg:  "routine can't be limited by policy",
+			}
+		}
+	}
+
+	call, callArgs, err := routine.Call(s.db.Dialect(), args)
+	if err != nil {
+		return j.ErrResponse(err)
+	}
+	urlQuery := sql.NewURLQuery(r.URL.Query(), s.db.Dialect())
+	if isTableLike {
+		// functions may write data, so they are always called on the primary
+		src := &source{from: fmt.Sprintf("%s AS %s", call, name), args: callArgs, db: s.db}
+		return s.get(w, r, src, urlQuery, authInfo)
+	}
+
+	var query string
+	switch {
+	case routine.Type == sql.RoutineProcedure:
+		query = "CALL " + call
+	case routine.ReturnsRow:
+		query = "SELECT * FROM " + call
+	default:
+		query = fmt.Sprintf("SELECT %s AS %s", call, name)
+	}
+	if urlQuery.IsDebug() {
+		return s.debug(query, callArgs...)
+	}
+	if urlQuery.IsExplain() {
+		return s.explain(r, s.db, urlQuery, query, callArgs...)
+	}
+
+	objects, dbErr := s.db.FetchData(r.Context(), query, callArgs...)
+	if dbErr != nil {
+		log.Errorf("rpc error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+
+	switch {
+	case routine.IsVoid() || (routine.Type == sql.RoutineProcedure && len(objects) == 0):
+		return &j.Response{
+			Code: http.StatusOK,
+			Msg:  fmt.Sprintf("successfully called %s", name),
+		}
+	case routine.ReturnsSet:
+		return objects
+	case len(objects) == 0:
+		return nil
+	case routine.ReturnsRow || routine.Type == sql.RoutineProcedure:
+		return objects[0]
+	default:
+		return objects[0][name]
+	}
+}
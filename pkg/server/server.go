@@ -4,8 +4,10 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,21 +18,87 @@ import (
 	"github.com/rest-go/rest/pkg/sql"
 )
 
+// UserAuthInfo limits the rows the request user can access by the condition
+// of the policy, and the columns by column policies
 type UserAuthInfo struct {
-	column string
-	val    int64
+	cond *auth.Condition
+	// columns are the allowed columns if limitColumns is set
+	columns      []string
+	limitColumns bool
+}
+
+// filter limits the rows of the url query
+func (info *UserAuthInfo) filter(urlQuery *sql.URLQuery) {
+	if info.cond == nil {
+		return
+	}
+	where, args := info.cond.Where()
+	urlQuery.AddCondition(where, args...)
+}
+
+// checkColumns returns an error response if any of the columns is not allowed
+func (info *UserAuthInfo) checkColumns(columns []string) *j.Response {
+	if !info.limitColumns {
+		return nil
+	}
+	allowed := make(map[string]bool, len(info.columns))
+	for _, column := range info.columns {
+		allowed[column] = true
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		if !allowed[column] {
+			return &j.Response{
+				Code: http.StatusForbidden,
+				Msg:  fmt.Sprintf("column not allowed: %s", column),
+			}
+		}
+	}
+	return nil
+}
+
+// readColumns returns the allowed columns of the source to read, columns not
+// in the table are skipped
+func (info *UserAuthInfo) readColumns(table *sql.Table) []string {
+	columns := make([]string, 0, len(info.columns))
+	for _, column := range info.columns {
+		if table == nil || table.HasColumn(column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// source is where the data is read from, e.g. a table or a set-returning
+// function call
+type source struct {
+	from  string     // expression in the FROM clause
+	args  []any      // args used in the FROM clause
+	table *sql.Table // table meta data, nil if the source isn't a table
+	db    *sql.DB    // database to read from, the primary or a replica
 }
 
 // Server is the representation of a restful server which handles CRUD requests
 type Server struct {
-	db          *sql.DB
-	prefix      string
-	authEnabled bool
+	db           *sql.DB
+	replicas     *replicaSet
+	prefix       string
+	authEnabled  bool
+	rls          *RLSConfig
+	maxQueryCost float64
 
-	tablesMu   sync.RWMutex
-	tables     map[string]*sql.Table
-	policiesMu sync.RWMutex
-	policies   map[string]map[string]string // {table:action:exp}
+	tablesConfig   TablesConfig
+	tablesMu       sync.RWMutex
+	tables         map[string]*sql.Table
+	routinesMu     sync.RWMutex
+	routines       map[string]*sql.Routine
+	policiesMu     sync.RWMutex
+	policies       map[string]map[string]string // {table:action:exp}
+	columnPolicies []auth.ColumnPolicy
+
+	queryConfigs map[string]QueryConfig
+	queries      map[string]*namedQuery
+	timeout      TimeoutConfig
 
 	done chan struct{}
 }
@@ -38,32 +106,67 @@ type Server struct {
 // New returns a Handler pointer
 func New(dbConfig *DBConfig, options ...Option) *Server {
 	log.Infof("start server with config %v", dbConfig)
-	db, err := sql.Open(dbConfig.URL)
+	db, err := sql.Open(dbConfig.URL, dbConfig.options()...)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defaultIdleConns := 50
-	defaultOpenConns := 50
-	db.SetConnMaxLifetime(0)
-	db.SetMaxIdleConns(defaultIdleConns)
-	db.SetMaxOpenConns(defaultOpenConns)
-	h := &Server{db: db, done: make(chan struct{})}
+	setPool(db, dbConfig)
+	h := &Server{
+		db:           db,
+		replicas:     newReplicaSet(dbConfig),
+		maxQueryCost: dbConfig.MaxQueryCost,
+		done:         make(chan struct{}),
+	}
 	for _, opt := range options {
 		opt(h)
 	}
+	if h.rls != nil && db.Dialect().SetRole(h.rls.AnonymousRole, "{}") == nil {
+		log.Fatal("row-level security is not supported by database: ", db.DriverName)
+	}
+	h.queries = make(map[string]*namedQuery, len(h.queryConfigs))
+	for name, config := range h.queryConfigs {
+		query, err := compileQuery(name, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h.queries[name] = query
+	}
 	h.updateMeta()
+	h.replicas.watch(h.done)
 	return h
 }
 
+// setPool sets the connection pool of db, 50 connections are used by default
+func setPool(db *sql.DB, config *DBConfig) {
+	const defaultConns = 50
+	maxOpenConns, maxIdleConns := config.MaxOpenConns, config.MaxIdleConns
+	if maxOpenConns == 0 {
+		maxOpenConns = defaultConns
+	}
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultConns
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+}
+
+// Close stops the health check of replicas and closes them
 func (s *Server) Close() {
 	close(s.done)
+	s.replicas.close()
 }
 
 func (s *Server) updateMeta() {
 	updateTask := func() {
 		tables := s.db.FetchTables()
 		ts := make([]string, 0, len(tables))
-		for _, t := range tables {
+		for name, t := range tables {
+			if !s.tablesConfig.IsExposed(name) {
+				delete(tables, name)
+				continue
+			}
 			ts = append(ts, t.String())
 		}
 		log.Tracef("fetch tables from db: \n%s\n", strings.Join(ts, "\n"))
@@ -71,6 +174,16 @@ func (s *Server) updateMeta() {
 		s.tables = tables
 		s.tablesMu.Unlock()
 
+		routines := s.db.FetchRoutines()
+		rs := make([]string, 0, len(routines))
+		for _, r := range routines {
+			rs = append(rs, r.String())
+		}
+		log.Tracef("fetch routines from db: \n%s\n", strings.Join(rs, "\n"))
+		s.routinesMu.Lock()
+		s.routines = routines
+		s.routinesMu.Unlock()
+
 		s.updatePolicies()
 	}
 	updateTask()
@@ -89,7 +202,7 @@ func (s *Server) updateMeta() {
 }
 
 func (s *Server) updatePolicies() {
-	if !s.authEnabled {
+	if !s.authEnabled || s.rls != nil {
 		return
 	}
 
@@ -116,8 +229,25 @@ func (s *Server) updatePolicies() {
 		}
 	}
 	log.Tracef("fetch policies from db: \n%s\n", policies)
+
+	columnPoliciesData, err := s.db.FetchData(ctx, "SELECT table_name, action, columns, expression FROM auth_column_policies")
+	if err != nil {
+		log.Errorf("fetch column policies from db error: %v", err)
+	}
+	columnPolicies := make([]auth.ColumnPolicy, 0, len(columnPoliciesData))
+	for _, policyData := range columnPoliciesData {
+		var policy auth.ColumnPolicy
+		err := j.MapToStruct(policyData, &policy)
+		if err != nil {
+			log.Errorf("get column policy error: %v", err)
+			continue
+		}
+		columnPolicies = append(columnPolicies, policy)
+	}
+	log.Tracef("fetch column policies from db: \n%v\n", columnPolicies)
 	s.policiesMu.Lock()
 	s.policies = policies
+	s.columnPolicies = columnPolicies
 	s.policiesMu.Unlock()
 }
 
@@ -127,12 +257,24 @@ func (s *Server) getTables() map[string]*sql.Table {
 	return s.tables
 }
 
+func (s *Server) getRoutines() map[string]*sql.Routine {
+	s.routinesMu.RLock()
+	defer s.routinesMu.RUnlock()
+	return s.routines
+}
+
 func (s *Server) getPolicies() map[string]map[string]string {
 	s.tablesMu.RLock()
 	defer s.tablesMu.RUnlock()
 	return s.policies
 }
 
+func (s *Server) getColumnPolicies() []auth.ColumnPolicy {
+	s.policiesMu.RLock()
+	defer s.policiesMu.RUnlock()
+	return s.columnPolicies
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Infof("%s %s", r.Method, r.URL.RequestURI())
 	path := strings.TrimPrefix(r.URL.Path, s.prefix)
@@ -149,7 +291,45 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// check table name
 	pk := ""
 	parts := strings.Split(tableName, "/")
+	resource := parts[0]
+	if len(parts) == 2 && (parts[0] == rpcPrefix || parts[0] == queriesPrefix) {
+		resource = tableName
+	}
+	timeout, err := s.requestTimeout(r, resource)
+	if err != nil {
+		res := &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+		j.Write(w, res)
+		return
+	}
+	if timeout > 0 {
+		ctx, cancel := sql.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if s.rls != nil {
+		ctx, err := s.rls.withRole(r.Context(), auth.GetUser(r))
+		if err != nil {
+			j.Write(w, j.ErrResponse(err))
+			return
+		}
+		r = r.WithContext(ctx)
+	}
+
 	if len(parts) == 2 {
+		switch parts[0] {
+		case rpcPrefix:
+			j.Write(w, s.rpc(w, r, parts[1]))
+			return
+		case queriesPrefix:
+			j.Write(w, s.runQuery(w, r, parts[1]))
+			return
+		case adminPrefix:
+			j.Write(w, s.admin(r, parts[1]))
+			return
+		}
 		tableName, pk = parts[0], parts[1]
 	}
 	table, ok := s.getTables()[tableName]
@@ -162,7 +342,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urlQuery := sql.NewURLQuery(r.URL.Query(), s.db.DriverName)
+	urlQuery := sql.NewURLQuery(r.URL.Query(), s.db.Dialect())
 	// check primary key
 	if pk != "" {
 		if table.PrimaryKey == "" {
@@ -177,42 +357,22 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		urlQuery.Set("singular", "")
 	}
 
-	var authInfo *UserAuthInfo
-	if s.authEnabled {
-		action := getAction(urlQuery, r.Method)
-		user := auth.GetUser(r)
-		hasPerm, userIDColumn := user.HasPerm(tableName, action, s.getPolicies())
-		if !hasPerm {
-			var res *j.Response
-			if user.IsAnonymous() {
-				res = &j.Response{
-					Code: http.StatusUnauthorized,
-					Msg:  "login required",
-				}
-			} else {
-				res = &j.Response{
-					Code: http.StatusForbidden,
-					Msg:  "unauthorized",
-				}
-			}
-			j.Write(w, res)
-			return
-		}
-		if userIDColumn != "" {
-			authInfo = &UserAuthInfo{userIDColumn, user.ID}
-		}
+	authInfo, res := s.authorize(r, tableName, getAction(urlQuery, r.Method))
+	if res != nil {
+		j.Write(w, res)
+		return
 	}
 
 	var data any
 	switch r.Method {
 	case "POST":
-		data = s.create(r, tableName, urlQuery, authInfo)
+		data = s.create(r, table, urlQuery, authInfo)
 	case "DELETE":
-		data = s.delete(r, tableName, urlQuery, authInfo)
+		data = s.delete(r, table, urlQuery, authInfo)
 	case "PUT", "PATCH":
-		data = s.update(r, tableName, urlQuery, authInfo)
+		data = s.update(r, table, urlQuery, authInfo)
 	case "GET":
-		data = s.get(r, tableName, urlQuery, authInfo)
+		data = s.get(w, r, &source{from: tableName, table: table, db: s.reader(r)}, urlQuery, authInfo)
 	default:
 		data = &j.Response{
 			Code: http.StatusMethodNotAllowed,
@@ -222,7 +382,75 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	j.Write(w, data)
 }
 
-func (s *Server) create(r *http.Request, tableName string, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
+// requestTimeout returns the statement timeout of a request on the resource,
+// which is a table name or `rpc/<name>`, `_queries/<name>`, timeouts asked by
+// clients are capped by sql.DefaultTimeout if there is no max
+func (s *Server) requestTimeout(r *http.Request, resource string) (time.Duration, error) {
+	timeout := s.timeout.Default
+	if t, ok := s.timeout.Tables[resource]; ok {
+		timeout = t
+	}
+	if v, ok := parsePrefer(r)["timeout"]; ok {
+		t, err := parseTimeout(v)
+		if err != nil {
+			return 0, err
+		}
+		max := s.timeout.Max
+		if max <= 0 {
+			max = sql.DefaultTimeout
+		}
+		if t > max {
+			t = max
+		}
+		timeout = t
+	}
+	if s.timeout.Max > 0 && (timeout <= 0 || timeout > s.timeout.Max) {
+		timeout = s.timeout.Max
+	}
+	return timeout, nil
+}
+
+// authorize checks whether the request user has permission to perform the
+// action on a resource, it returns an error response if permission denied,
+// policies are left to the database with row-level security while scopes of
+// API keys still apply
+func (s *Server) authorize(r *http.Request, resource string, action auth.Action) (*UserAuthInfo, *j.Response) {
+	if !s.authEnabled {
+		return nil, nil
+	}
+	user := auth.GetUser(r)
+	if s.rls != nil {
+		if !user.InScopes(resource, action) {
+			return nil, denied(user)
+		}
+		return nil, nil
+	}
+	cond, hasPerm := user.Check(resource, action, s.getPolicies())
+	if !hasPerm {
+		return nil, denied(user)
+	}
+	columns, limitColumns := user.Columns(resource, action, s.getColumnPolicies())
+	if cond != nil || limitColumns {
+		return &UserAuthInfo{cond, columns, limitColumns}, nil
+	}
+	return nil, nil
+}
+
+// denied returns the response of permission denied for the user
+func denied(user *auth.User) *j.Response {
+	if user.IsAnonymous() {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "login required",
+		}
+	}
+	return &j.Response{
+		Code: http.StatusForbidden,
+		Msg:  "unauthorized",
+	}
+}
+
+func (s *Server) create(r *http.Request, table *sql.Table, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
 	var data sql.PostData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
@@ -233,8 +461,32 @@ func (s *Server) create(r *http.Request, tableName string, urlQuery *sql.URLQuer
 		}
 	}
 	if userInfo != nil {
-		// create for current auth user
-		data.Set(userInfo.column, userInfo.val)
+		for _, object := range data.Objects() {
+			if res := userInfo.checkColumns(keys(object)); res != nil {
+				return res
+			}
+		}
+	}
+	prefs := parsePrefer(r)
+	upsert := prefs["resolution"] == ResolutionMergeDuplicates
+	if upsert {
+		if res := s.authorizeUpsert(r, table.Name, userInfo, &data); res != nil {
+			return res
+		}
+	}
+	if userInfo != nil && userInfo.cond != nil {
+		// create for current auth user, e.g. set owner_id for `owner_id = auth_user.id`
+		for column, val := range userInfo.cond.Defaults() {
+			data.Set(column, val)
+		}
+		for _, object := range data.Objects() {
+			if !userInfo.cond.Allows(object) {
+				return &j.Response{
+					Code: http.StatusForbidden,
+					Msg:  "row violates policy",
+				}
+			}
+		}
 	}
 	valuesQuery, err := data.ValuesQuery()
 	if err != nil {
@@ -247,20 +499,71 @@ func (s *Server) create(r *http.Request, tableName string, urlQuery *sql.URLQuer
 
 	query := fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES %s",
-		tableName,
+		table.Name,
 		strings.Join(valuesQuery.Columns, ","),
 		strings.Join(valuesQuery.Placeholders, ","))
+	dialect := s.db.Dialect()
+	if upsert {
+		if table.PrimaryKey == "" {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  fmt.Sprintf("primary key not found on table: %s", table.Name),
+			}
+		}
+		columns := make([]string, 0, len(valuesQuery.Columns))
+		for _, c := range valuesQuery.Columns {
+			if c != table.PrimaryKey {
+				columns = append(columns, c)
+			}
+		}
+		clause := dialect.Upsert([]string{table.PrimaryKey}, columns)
+		if clause == "" {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  fmt.Sprintf("resolution is not supported by database: %s", s.db.DriverName),
+			}
+		}
+		query += clause
+	}
+	representation := prefs["return"] == ReturnRepresentation
+	if representation {
+		returning, res := s.returning(r, table)
+		if res != nil {
+			return res
+		}
+		clause := dialect.Returning(returning)
+		if clause == "" {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  fmt.Sprintf("return=representation is not supported by database: %s", s.db.DriverName),
+			}
+		}
+		query += clause
+	}
 	args := valuesQuery.Args
 	if urlQuery.IsDebug() {
 		return s.debug(query, args...)
 	}
+	if urlQuery.IsExplain() {
+		return s.explain(r, s.db, urlQuery, query, args...)
+	}
 
+	if representation {
+		objects, dbErr := s.db.FetchData(r.Context(), query, args...)
+		if dbErr != nil {
+			log.Errorf("create error: %v", dbErr)
+			return j.ErrResponse(dbErr)
+		}
+		return objects
+	}
 	rows, dbErr := s.db.ExecQuery(r.Context(), query, args...)
 	if dbErr != nil {
 		log.Errorf("create error: %v", dbErr)
 		return j.ErrResponse(dbErr)
 	}
-	if rows != int64(len(valuesQuery.Placeholders)) {
+	// MySQL reports 2 affected rows for an updated row and 0 for an unchanged
+	// one, so the number is only checked for plain inserts
+	if !upsert && rows != int64(len(valuesQuery.Placeholders)) {
 		return &j.Response{
 			Code: http.StatusInternalServerError,
 			Msg:  fmt.Sprintf("expected to insert %d rows, but affected %d rows", len(valuesQuery.Placeholders), rows),
@@ -273,16 +576,87 @@ func (s *Server) create(r *http.Request, tableName string, urlQuery *sql.URLQuer
 	}
 }
 
-func (s *Server) delete(r *http.Request, tableName string, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
+// authorizeUpsert checks the update permission of upsert as conflicting rows
+// are updated, upsert is denied if rows are limited by policies since the
+// conflicting rows may not be allowed to update
+func (s *Server) authorizeUpsert(r *http.Request, table string, createInfo *UserAuthInfo, data *sql.PostData) *j.Response {
+	updateInfo, res := s.authorize(r, table, auth.ActionUpdate)
+	if res != nil {
+		return res
+	}
+	if (createInfo != nil && createInfo.cond != nil) || (updateInfo != nil && updateInfo.cond != nil) {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "resolution is not allowed on rows limited by policies",
+		}
+	}
+	if updateInfo != nil {
+		for _, object := range data.Objects() {
+			if res := updateInfo.checkColumns(keys(object)); res != nil {
+				return res
+			}
+		}
+	}
+	return nil
+}
+
+// returning returns the columns of new rows to return, they're limited to
+// the columns the user is allowed to read
+func (s *Server) returning(r *http.Request, table *sql.Table) (string, *j.Response) {
+	if !s.authEnabled {
+		return "*", nil
+	}
+	user := auth.GetUser(r)
+	// rows written are read back, which needs the read scope of API keys
+	if !user.InScopes(table.Name, auth.ActionRead) {
+		return "", denied(user)
+	}
+	if s.rls != nil {
+		return "*", nil
+	}
+	columns, limited := user.Columns(table.Name, auth.ActionRead, s.getColumnPolicies())
+	if !limited {
+		return "*", nil
+	}
+	info := &UserAuthInfo{columns: columns, limitColumns: true}
+	if columns = info.readColumns(table); len(columns) == 0 {
+		return "", &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "no column is allowed to read",
+		}
+	}
+	return strings.Join(columns, ","), nil
+}
+
+// keys returns the columns of an object
+func keys(object map[string]any) []string {
+	columns := make([]string, 0, len(object))
+	for column := range object {
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+func (s *Server) delete(r *http.Request, table *sql.Table, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
 	if userInfo != nil {
+		// columns in filters can be probed by deleted rows
+		if res := userInfo.checkColumns(urlQuery.Columns()); res != nil {
+			return res
+		}
 		// filter by current auth user
-		urlQuery.Set(userInfo.column, fmt.Sprintf("eq.%d", userInfo.val))
+		userInfo.filter(urlQuery)
 	}
 
 	var queryBuilder strings.Builder
 	queryBuilder.WriteString("DELETE FROM ")
-	queryBuilder.WriteString(tableName)
-	_, whereQuery, args := urlQuery.WhereQuery(1)
+	queryBuilder.WriteString(table.Name)
+	_, whereQuery, args, err := urlQuery.WhereQuery(1, table)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
 	if whereQuery != "" {
 		queryBuilder.WriteString(" WHERE ")
 		queryBuilder.WriteString(whereQuery)
@@ -298,6 +672,9 @@ If you really want to do it, uses 1=eq.1 to bypass it`,
 	if urlQuery.IsDebug() {
 		return s.debug(query, args...)
 	}
+	if urlQuery.IsExplain() {
+		return s.explain(r, s.db, urlQuery, query, args...)
+	}
 
 	rows, dbErr := s.db.ExecQuery(r.Context(), query, args...)
 	if dbErr != nil {
@@ -311,12 +688,7 @@ If you really want to do it, uses 1=eq.1 to bypass it`,
 	}
 }
 
-func (s *Server) update(r *http.Request, tableName string, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
-	if userInfo != nil {
-		// filter current auth user
-		urlQuery.Set(userInfo.column, fmt.Sprintf("eq.%d", userInfo.val))
-	}
-
+func (s *Server) update(r *http.Request, table *sql.Table, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
 	var data sql.PostData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
@@ -326,6 +698,30 @@ func (s *Server) update(r *http.Request, tableName string, urlQuery *sql.URLQuer
 			Msg:  fmt.Sprintf("failed to parse update json data, %v", err),
 		}
 	}
+	var values map[string]any
+	if objects := data.Objects(); len(objects) > 0 {
+		values = objects[0]
+	}
+	if userInfo != nil {
+		if res := userInfo.checkColumns(append(keys(values), urlQuery.Columns()...)); res != nil {
+			return res
+		}
+	}
+	if userInfo != nil && userInfo.cond != nil {
+		// filter current auth user, and the rows should still meet the
+		// policy after updated
+		userInfo.filter(urlQuery)
+		where, args, ok := userInfo.cond.WhereUpdated(values)
+		if !ok {
+			return &j.Response{
+				Code: http.StatusForbidden,
+				Msg:  "row violates policy",
+			}
+		}
+		if current, _ := userInfo.cond.Where(); where != "" && where != current {
+			urlQuery.AddCondition(where, args...)
+		}
+	}
 	setQuery, err := data.SetQuery(1)
 	if err != nil {
 		log.Warnf("failed to generate set query: %v", err)
@@ -336,10 +732,16 @@ func (s *Server) update(r *http.Request, tableName string, urlQuery *sql.URLQuer
 	}
 
 	var queryBuilder strings.Builder
-	queryBuilder.WriteString(fmt.Sprintf("UPDATE %s SET %s", tableName, setQuery.Query))
+	queryBuilder.WriteString(fmt.Sprintf("UPDATE %s SET %s", table.Name, setQuery.Query))
 
 	args := setQuery.Args
-	_, whereQuery, args2 := urlQuery.WhereQuery(setQuery.Index)
+	_, whereQuery, args2, err := urlQuery.WhereQuery(setQuery.Index, table)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
 	if whereQuery != "" {
 		queryBuilder.WriteString(" WHERE ")
 		queryBuilder.WriteString(whereQuery)
@@ -356,6 +758,9 @@ If you really want to do it, uses 1=eq.1 to bypass it`,
 	if urlQuery.IsDebug() {
 		return s.debug(query, args...)
 	}
+	if urlQuery.IsExplain() {
+		return s.explain(r, s.db, urlQuery, query, args...)
+	}
 
 	rows, dbErr := s.db.ExecQuery(r.Context(), query, args...)
 	if dbErr != nil {
@@ -368,14 +773,28 @@ If you really want to do it, uses 1=eq.1 to bypass it`,
 	}
 }
 
-func (s *Server) get(r *http.Request, tableName string, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
+func (s *Server) get(w http.ResponseWriter, r *http.Request, src *source, urlQuery *sql.URLQuery, userInfo *UserAuthInfo) any {
 	if userInfo != nil {
 		// filter current auth user
-		urlQuery.Set(userInfo.column, fmt.Sprintf("eq.%d", userInfo.val))
+		userInfo.filter(urlQuery)
+		// hidden columns can't be selected, filtered or ordered
+		if res := userInfo.checkColumns(urlQuery.Columns()); res != nil {
+			return res
+		}
+		if userInfo.limitColumns && !r.URL.Query().Has("select") {
+			columns := userInfo.readColumns(src.table)
+			if len(columns) == 0 {
+				return &j.Response{
+					Code: http.StatusForbidden,
+					Msg:  "no column is allowed to read",
+				}
+			}
+			urlQuery.Set("select", strings.Join(columns, ","))
+		}
 	}
 
 	if urlQuery.IsCount() {
-		return s.count(r, tableName, urlQuery)
+		return s.count(r, src, urlQuery)
 	}
 
 	var queryBuilder strings.Builder
@@ -387,35 +806,64 @@ func (s *Server) get(r *http.Request, tableName string, urlQuery *sql.URLQuery,
 			Msg:  err.Error(),
 		}
 	}
-	queryBuilder.WriteString(fmt.Sprintf("SELECT %s FROM %s", selects, tableName))
-	_, whereQuery, args := urlQuery.WhereQuery(1)
+	queryBuilder.WriteString(fmt.Sprintf("SELECT %s FROM %s", selects, src.from))
+	_, whereQuery, whereArgs, err := urlQuery.WhereQuery(uint(len(src.args)+1), src.table)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	args := append(append([]any{}, src.args...), whereArgs...)
 	if whereQuery != "" {
 		queryBuilder.WriteString(" WHERE ")
 		queryBuilder.WriteString(whereQuery)
 	}
 
 	// order
-	order := urlQuery.OrderQuery()
+	order, err := urlQuery.OrderQuery(src.table)
+	if err != nil {
+		log.Warnf("invalid order query %v", err)
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
 	if len(order) > 0 {
 		queryBuilder.WriteString(" ORDER BY ")
 		queryBuilder.WriteString(order)
 	}
 
 	// page operation
-	page, pageSize := urlQuery.Page()
-	queryBuilder.WriteString(" LIMIT ")
-	queryBuilder.WriteString(fmt.Sprintf("%d", pageSize))
-	if page != 1 {
-		queryBuilder.WriteString(" OFFSET ")
-		queryBuilder.WriteString(fmt.Sprintf("%d", (page-1)*pageSize))
+	offset, limit, isRange, err := parseRange(r)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusRequestedRangeNotSatisfiable,
+			Msg:  err.Error(),
+		}
+	}
+	if !isRange || limit == 0 {
+		page, pageSize := urlQuery.Page()
+		if !isRange {
+			offset = (page - 1) * pageSize
+		}
+		limit = pageSize
 	}
+	// fetch one more row to know whether there is more data
+	queryBuilder.WriteString(src.db.Dialect().Limit(limit+1, offset, len(order) > 0))
 
 	query := queryBuilder.String()
 	if urlQuery.IsDebug() {
 		return s.debug(query, args...)
 	}
+	if urlQuery.IsExplain() {
+		return s.explain(r, src.db, urlQuery, query, args...)
+	}
 
-	objects, dbErr := s.db.FetchData(r.Context(), query, args...)
+	if res := s.checkCost(r, src.db, query, args...); res != nil {
+		return res
+	}
+	objects, dbErr := src.db.FetchData(r.Context(), query, args...)
 	if dbErr != nil {
 		log.Errorf("read error: %v", dbErr)
 		return j.ErrResponse(dbErr)
@@ -435,17 +883,58 @@ func (s *Server) get(r *http.Request, tableName string, urlQuery *sql.URLQuery,
 		}
 		return objects[0]
 	}
+
+	hasMore := len(objects) > limit
+	if hasMore {
+		objects = objects[:limit]
+	}
+	prefs := parsePrefer(r)
+	total := int64(-1)
+	countMode, isCount := prefs["count"]
+	if isCount {
+		total, err = s.total(r, countMode, src, whereQuery, args)
+		if err != nil {
+			log.Errorf("fetch total count error: %v", err)
+			return j.ErrResponse(err)
+		}
+	}
+	if isCount || isRange {
+		w.Header().Set(ContentRangeHeader, contentRange(offset, len(objects), total))
+	}
+
+	page := offset/limit + 1
+	if !isRange {
+		if link := linkHeader(r.URL, page, limit, hasMore); link != "" {
+			w.Header().Set(LinkHeader, link)
+		}
+	}
+	if _, ok := prefs["envelope"]; ok {
+		envelope := &j.Envelope{
+			Data:     objects,
+			Page:     page,
+			PageSize: limit,
+			HasMore:  hasMore,
+		}
+		if isCount {
+			envelope.Total = &total
+		}
+		return envelope
+	}
 	return objects
 }
 
-func (s *Server) count(r *http.Request, tableName string, urlQuery *sql.URLQuery) any {
-	query := fmt.Sprintf("SELECT COUNT(1) AS count FROM %s", tableName)
-	_, whereQuery, args := urlQuery.WhereQuery(1)
-	if whereQuery != "" {
-		query += fmt.Sprintf(" WHERE %s", whereQuery)
+func (s *Server) count(r *http.Request, src *source, urlQuery *sql.URLQuery) any {
+	_, whereQuery, whereArgs, err := urlQuery.WhereQuery(uint(len(src.args)+1), src.table)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
 	}
+	query := countQuery(src.from, whereQuery)
+	args := append(append([]any{}, src.args...), whereArgs...)
 
-	objects, dbErr := s.db.FetchData(r.Context(), query, args...)
+	objects, dbErr := src.db.FetchData(r.Context(), query, args...)
 	if dbErr != nil {
 		log.Errorf("fetch count error: %v", dbErr)
 		return j.ErrResponse(dbErr)
@@ -453,6 +942,87 @@ func (s *Server) count(r *http.Request, tableName string, urlQuery *sql.URLQuery
 	return objects[0]["count"]
 }
 
+// total returns total rows of a source with count mode in `Prefer` header,
+// it falls back to exact count if the mode is not supported by the database
+func (s *Server) total(r *http.Request, mode string, src *source, whereQuery string, args []any) (int64, error) {
+	var (
+		total int64
+		err   error
+	)
+	ctx := r.Context()
+	switch mode {
+	case CountExact:
+		return src.db.ExactCount(ctx, countQuery(src.from, whereQuery), args...)
+	case CountPlanned:
+		query := fmt.Sprintf("SELECT 1 FROM %s", src.from)
+		if whereQuery != "" {
+			query += fmt.Sprintf(" WHERE %s", whereQuery)
+		}
+		total, err = src.db.PlannedCount(ctx, query, args...)
+	case CountEstimated:
+		if whereQuery != "" || src.table == nil {
+			// statistics of tables can't be applied to filters, use planner instead
+			return s.total(r, CountPlanned, src, whereQuery, args)
+		}
+		total, err = src.db.EstimatedCount(ctx, src.table.Name)
+	default:
+		return 0, sql.NewError(http.StatusBadRequest, fmt.Sprintf("invalid count mode: %s", mode))
+	}
+	if errors.Is(err, sql.ErrNotSupported) {
+		return s.total(r, CountExact, src, whereQuery, args)
+	}
+	return total, err
+}
+
+func countQuery(from, whereQuery string) string {
+	query := fmt.Sprintf("SELECT COUNT(1) AS count FROM %s", from)
+	if whereQuery != "" {
+		query += fmt.Sprintf(" WHERE %s", whereQuery)
+	}
+	return query
+}
+
+// explain returns the query plan from database, explain with analyze is
+// limited to admin users because the query is actually executed
+func (s *Server) explain(r *http.Request, db *sql.DB, urlQuery *sql.URLQuery, query string, args ...any) any {
+	analyze := urlQuery.IsExplainAnalyze()
+	if analyze && s.authEnabled && !auth.GetUser(r).IsAdmin {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "explain analyze is limited to admin users",
+		}
+	}
+	plan, err := db.Explain(r.Context(), query, analyze, args...)
+	if err != nil {
+		log.Errorf("explain error: %v", err)
+		return j.ErrResponse(err)
+	}
+	return plan
+}
+
+// checkCost rejects the query if its cost estimated by the query planner
+// exceeds the max query cost
+func (s *Server) checkCost(r *http.Request, db *sql.DB, query string, args ...any) *j.Response {
+	if s.maxQueryCost <= 0 {
+		return nil
+	}
+	cost, err := db.QueryCost(r.Context(), query, args...)
+	if errors.Is(err, sql.ErrNotSupported) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("get query cost error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if cost > s.maxQueryCost {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  fmt.Sprintf("query cost %.2f exceeds the limit %.2f, please add more filters", cost, s.maxQueryCost),
+		}
+	}
+	return nil
+}
+
 func (s *Server) debug(query string, args ...any) any {
 	return &struct {
 		Query string `json:"query"`
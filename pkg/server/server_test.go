@@ -3,12 +3,15 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/rest-go/rest/pkg/auth"
+	"github.com/rest-go/rest/pkg/sql"
 )
 
 func TestServer(t *testing.T) {
@@ -119,6 +122,19 @@ func TestServer_Create_Delete(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, code)
 	})
 
+	t.Run("delete with invalid filter", func(t *testing.T) {
+		for _, target := range []string{
+			"/customers?pg_sleep(1)=eq.1",
+			"/customers?Id=xx.1",
+			"/customers?Id=1",
+			"/customers?Id=is.maybe",
+		} {
+			code, _, err := request(http.MethodDelete, target, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, code, target)
+		}
+	})
+
 	t.Run("delete", func(t *testing.T) {
 		t.Log("delete customers created above")
 		code, _, err := request(http.MethodDelete, "/customers/100", nil)
@@ -141,6 +157,40 @@ func TestServer_Create_Delete(t *testing.T) {
 	})
 }
 
+func TestServerCreatePrefer(t *testing.T) {
+	post := func(prefer, body string) (int, any) {
+		header := http.Header{"Prefer": []string{prefer}}
+		res, data, err := requestWithHeader(http.MethodPost, "/customers", header, strings.NewReader(body))
+		assert.Nil(t, err)
+		return res.StatusCode, data
+	}
+
+	code, data := post("return=representation", `{"Id": 200, "FirstName": "first", "LastName": "last", "Email": "a@b.c", "Active": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assertLength(t, 1, data)
+	assertEqualField(t, "first", data.([]any)[0], "FirstName")
+
+	code, data = post("", `{"Id": 200, "FirstName": "second", "LastName": "last", "Email": "a@b.c", "Active": true}`)
+	assert.Equal(t, http.StatusConflict, code)
+	problem := data.(map[string]any)
+	assert.Equal(t, sql.ErrCodeUniqueViolation, problem["code"])
+	assert.Equal(t, "customers", problem["table"])
+	assert.Equal(t, "Id", problem["column"])
+
+	code, data = post("resolution=merge-duplicates, return=representation", `{"Id": 200, "FirstName": "second", "LastName": "last", "Email": "a@b.c", "Active": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assertEqualField(t, "second", data.([]any)[0], "FirstName")
+
+	code, _, err := request(http.MethodDelete, "/customers/200", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	header := http.Header{"Prefer": []string{"resolution=merge-duplicates"}}
+	res, _, err := requestWithHeader(http.MethodPost, "/articles", header, strings.NewReader(`{"title": "a"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
 func TestServer_Read(t *testing.T) {
 	t.Run("one", func(t *testing.T) {
 		code, data, err := request(http.MethodGet, "/customers/1", nil)
@@ -161,6 +211,28 @@ func TestServer_Read(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, code)
 	})
 
+	t.Run("invalid filter", func(t *testing.T) {
+		code, _, err := request(http.MethodGet, "/articles?pg_sleep(1)=eq.1", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("select with alias and cast", func(t *testing.T) {
+		code, data, err := request(http.MethodGet, "/customers/1?select=firstName:FirstName,Id::text", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertEqualField(t, "1", data, "Id")
+		m := data.(map[string]any)
+		assert.Contains(t, m, "firstName")
+		assert.NotContains(t, m, "FirstName")
+	})
+
+	t.Run("select with invalid cast", func(t *testing.T) {
+		code, _, err := request(http.MethodGet, "/customers?select=Id::varchar", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
 	t.Run("one singular", func(t *testing.T) {
 		code, data, err := request(http.MethodGet, "/invoices/1?singular", nil)
 		assert.Equal(t, http.StatusOK, code)
@@ -184,6 +256,24 @@ func TestServer_Read(t *testing.T) {
 		t.Log("get invoices: ", data)
 	})
 
+	t.Run("many with multiple orders", func(t *testing.T) {
+		code, data, err := request(http.MethodGet, "/invoices?order=Total.asc.nullslast,Id.desc", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 2, data)
+		assertEqualField(t, "2", data.([]any)[0], "Id")
+	})
+
+	t.Run("many with invalid order", func(t *testing.T) {
+		code, _, err := request(http.MethodGet, "/invoices?order=Id.down", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _, err = request(http.MethodGet, "/invoices?order=NotExist.desc", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
 	t.Run("many with page", func(t *testing.T) {
 		code, data, err := request(http.MethodGet, "/invoices", nil)
 		assert.Nil(t, err)
@@ -247,6 +337,36 @@ func TestServerDebug(t *testing.T) {
 	m = data.(map[string]any)
 	t.Log("get debug data: ", m["query"], m["args"])
 }
+func TestServerExplain(t *testing.T) {
+	code, data, err := request(http.MethodGet, "/customers?explain&Id=eq.1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	t.Log("get explain data: ", data)
+	assert.NotEmpty(t, data)
+
+	code, _, err = request(http.MethodDelete, "/customers?explain&Id=eq.1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	code, _, err = request(http.MethodGet, "/customers/1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code, "explain should not delete data")
+
+	code, _, err = request(http.MethodGet, "/customers?explain=analyze", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	t.Run("analyze is limited to admin users", func(t *testing.T) {
+		s := New(&DBConfig{URL: "sqlite://ci.db"}, EnableAuth(true))
+		defer s.Close()
+		authServer := auth.NewMiddleware([]byte("test-secret"))(s)
+		token, err := auth.GenJWTToken([]byte("test-secret"), map[string]any{"user_id": 1})
+		assert.Nil(t, err)
+		code, _, err := requestHandler(authServer, token, http.MethodGet, "/articles?explain=analyze", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+}
+
 func TestServerCount(t *testing.T) {
 	code, data, err := request(http.MethodGet, "/customers?count", nil)
 	assert.Nil(t, err)
@@ -268,6 +388,172 @@ func TestServerCount(t *testing.T) {
 	assert.Equal(t, float64(1), count, data)
 }
 
+func TestServerPreferCount(t *testing.T) {
+	for _, mode := range []string{"exact", "planned", "estimated"} {
+		t.Run(mode, func(t *testing.T) {
+			header := http.Header{"Prefer": []string{"count=" + mode}}
+			res, data, err := requestWithHeader(http.MethodGet, "/invoices?page_size=1", header, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assertLength(t, 1, data)
+			assert.Equal(t, "0-0/2", res.Header.Get("Content-Range"))
+		})
+	}
+
+	t.Run("with filters", func(t *testing.T) {
+		header := http.Header{"Prefer": []string{"count=estimated"}}
+		res, _, err := requestWithHeader(http.MethodGet, "/invoices?Id=eq.100", header, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "*/0", res.Header.Get("Content-Range"))
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		header := http.Header{"Prefer": []string{"count=unknown"}}
+		res, _, err := requestWithHeader(http.MethodGet, "/invoices", header, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestServerRange(t *testing.T) {
+	header := http.Header{"Range": []string{"1-1"}, "Range-Unit": []string{"items"}}
+	res, data, err := requestWithHeader(http.MethodGet, "/invoices?order=Id", header, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assertLength(t, 1, data)
+	assertEqualField(t, "2", data.([]any)[0], "Id")
+	assert.Equal(t, "1-1/*", res.Header.Get("Content-Range"))
+
+	header = http.Header{"Range": []string{"0-"}, "Prefer": []string{"count=exact"}}
+	res, data, err = requestWithHeader(http.MethodGet, "/invoices", header, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assertLength(t, 2, data)
+	assert.Equal(t, "0-1/2", res.Header.Get("Content-Range"))
+
+	header = http.Header{"Range": []string{"2-1"}}
+	res, _, err = requestWithHeader(http.MethodGet, "/invoices", header, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+}
+
+func TestServerPagination(t *testing.T) {
+	t.Run("link header", func(t *testing.T) {
+		res, data, err := requestWithHeader(http.MethodGet, "/invoices?page_size=1", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assertLength(t, 1, data)
+		assert.Equal(t, `</invoices?page=2&page_size=1>; rel="next"`, res.Header.Get("Link"))
+
+		res, data, err = requestWithHeader(http.MethodGet, "/invoices?page=2&page_size=1", nil, nil)
+		assert.Nil(t, err)
+		assertLength(t, 1, data)
+		assert.Equal(t, `</invoices?page=1&page_size=1>; rel="prev"`, res.Header.Get("Link"))
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		header := http.Header{"Prefer": []string{"envelope, count=exact"}}
+		res, data, err := requestWithHeader(http.MethodGet, "/invoices?page_size=1", header, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		m := data.(map[string]any)
+		assertLength(t, 1, m["data"])
+		assert.Equal(t, float64(1), m["page"])
+		assert.Equal(t, float64(1), m["page_size"])
+		assert.Equal(t, true, m["has_more"])
+		assert.Equal(t, float64(2), m["total"])
+
+		res, data, err = requestWithHeader(http.MethodGet, "/invoices?page=2&page_size=1", header, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		m = data.(map[string]any)
+		assert.Equal(t, float64(2), m["page"])
+		assert.Equal(t, false, m["has_more"])
+	})
+}
+
+func TestServerRPC(t *testing.T) {
+	code, _, err := request(http.MethodPost, "/rpc/not_exist", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _, err = request(http.MethodGet, "/rpc/not_exist", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	t.Run("debug", func(t *testing.T) {
+		s := New(&DBConfig{URL: "sqlite://ci.db"})
+		defer s.Close()
+		s.routinesMu.Lock()
+		s.routines = map[string]*sql.Routine{
+			"add": {
+				Name:       "add",
+				Type:       sql.RoutineFunction,
+				ReturnType: "integer",
+				Params: []*sql.Param{
+					{Name: "a", DataType: "integer", Mode: "IN"},
+					{Name: "b", DataType: "integer", Mode: "IN"},
+				},
+			},
+		}
+		s.routinesMu.Unlock()
+
+		body := strings.NewReader(`{"a": 1, "b": 2}`)
+		code, data, err := requestHandler(s, "", http.MethodPost, "/rpc/add?debug", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertEqualField(t, "SELECT add(?, ?) AS add", data, "query")
+
+		body = strings.NewReader(`{"c": 1}`)
+		code, _, err = requestHandler(s, "", http.MethodPost, "/rpc/add?debug", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+func TestServerQueries(t *testing.T) {
+	s := New(&DBConfig{URL: "sqlite://ci.db"}, Queries(map[string]QueryConfig{
+		"customer_invoices": {
+			SQL:    "SELECT * FROM invoices WHERE CustomerId = :customer_id AND Total > :min_total;",
+			Params: map[string]string{"customer_id": "int", "min_total": "float"},
+		},
+	}))
+	defer s.Close()
+
+	t.Run("happy path", func(t *testing.T) {
+		code, data, err := requestHandler(s, "", http.MethodGet, "/_queries/customer_invoices?customer_id=1&min_total=0", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 2, data)
+	})
+
+	t.Run("with select, filters and order", func(t *testing.T) {
+		target := "/_queries/customer_invoices?customer_id=1&min_total=0&select=Id&Total=lt.2&order=Id.desc"
+		code, data, err := requestHandler(s, "", http.MethodGet, target, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 1, data)
+		assertEqualField(t, "2", data.([]any)[0], "Id")
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		code, _, err := requestHandler(s, "", http.MethodGet, "/_queries/customer_invoices?customer_id=1", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _, err = requestHandler(s, "", http.MethodGet, "/_queries/customer_invoices?customer_id=a&min_total=0", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		code, _, err := requestHandler(s, "", http.MethodGet, "/_queries/not_exist", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
 func TestServerAuth(t *testing.T) {
 	s := New(&DBConfig{URL: "sqlite://ci.db"}, EnableAuth(true))
 	defer s.Close()
@@ -330,4 +616,248 @@ func TestServerAuth(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusOK, code)
 	})
+
+	t.Run("policy expressions limit rows of tenants", func(t *testing.T) {
+		token, err := auth.GenJWTToken([]byte("test-secret"), map[string]any{
+			"user_id": 1, "org_id": 1,
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		code, data, err := requestHandler(authServer, token, http.MethodGet, "/documents?order=id", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 2, data)
+		assertEqualField(t, "public of org 1", data.([]any)[0], "title")
+		assertEqualField(t, "private of user 1", data.([]any)[1], "title")
+
+		// org_id is set by the policy
+		body := strings.NewReader(`{"title": "new", "org_id": 2, "owner_id": 1}`)
+		code, _, err = requestHandler(authServer, token, http.MethodPost, "/documents", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		code, data, err = requestHandler(authServer, token, http.MethodGet, "/documents?title=eq.new", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 1, data)
+		assertEqualField(t, "1", data.([]any)[0], "org_id")
+
+		body = strings.NewReader(`{"title": "others", "owner_id": 2}`)
+		code, data, err = requestHandler(authServer, token, http.MethodPost, "/documents", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+		assertEqualField(t, "row violates policy", data, "msg")
+
+		// upsert could take over conflicting rows of others
+		req := httptest.NewRequest(http.MethodPost, "/documents",
+			strings.NewReader(`{"id": 3, "title": "taken", "owner_id": 1}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Prefer", "resolution=merge-duplicates")
+		res, data, err := serve(authServer, req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assertEqualField(t, "resolution is not allowed on rows limited by policies", data, "msg")
+
+		// filters can't loosen the policy
+		for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+			for _, target := range []string{"/documents?id%3D1%20OR%201=eq.1", "/documents?id%3D1OR(1)=eq.1"} {
+				code, _, err = requestHandler(authServer, token, method, target, strings.NewReader(`{"title": "x"}`))
+				assert.Nil(t, err)
+				assert.Equal(t, http.StatusBadRequest, code, method+" "+target)
+			}
+		}
+
+		// rows can't be moved out of the policy
+		body = strings.NewReader(`{"owner_id": 2}`)
+		code, _, err = requestHandler(authServer, token, http.MethodPut, "/documents?title=eq.new", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		code, data, err = requestHandler(authServer, token, http.MethodGet, "/documents?title=eq.new", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 1, data)
+		assertEqualField(t, "1", data.([]any)[0], "owner_id")
+
+		body = strings.NewReader(`{"org_id": 2}`)
+		code, _, err = requestHandler(authServer, token, http.MethodPut, "/documents?title=eq.new", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+
+		// rows of other users and tenants are not deleted
+		code, data, err = requestHandler(authServer, token, http.MethodDelete, "/documents?public=is.false", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertEqualField(t, "successfully deleted 2 rows", data, "msg")
+		code, data, err = request(http.MethodGet, "/documents?count", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(3), data)
+	})
+
+	t.Run("column policies limit columns to read and write", func(t *testing.T) {
+		token, err := auth.GenJWTToken([]byte("test-secret"), map[string]any{"user_id": 1})
+		if err != nil {
+			t.Error(err)
+		}
+		code, data, err := requestHandler(authServer, token, http.MethodGet, "/profiles", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertLength(t, 1, data)
+		profile := data.([]any)[0].(map[string]any)
+		assert.Equal(t, "user 1", profile["name"])
+		assert.Equal(t, true, profile["is_verified"])
+		assert.NotContains(t, profile, "credit_limit")
+
+		for target, column := range map[string]string{
+			"/profiles?select=name,credit_limit":        "credit_limit",
+			"/profiles?select=*":                        "*",
+			"/profiles?select=limit:credit_limit::text": "credit_limit",
+			"/profiles?credit_limit=gt.10":              "credit_limit",
+			"/profiles?order=credit_limit.desc":         "credit_limit",
+			"/profiles?select=abs(id)-credit_limit":     "credit_limit",
+			"/profiles?abs(id)-credit_limit=gt.10":      "credit_limit",
+		} {
+			code, data, err = requestHandler(authServer, token, http.MethodGet, target, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusForbidden, code, target)
+			assertEqualField(t, "column not allowed: "+column, data, "msg")
+		}
+
+		body := strings.NewReader(`{"name": "new name"}`)
+		code, _, err = requestHandler(authServer, token, http.MethodPatch, "/profiles?id=eq.1", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+
+		body = strings.NewReader(`{"name": "new name", "is_verified": true}`)
+		code, data, err = requestHandler(authServer, token, http.MethodPatch, "/profiles?id=eq.1", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+		assertEqualField(t, "column not allowed: is_verified", data, "msg")
+
+		// columns in filters of writes are also checked
+		body = strings.NewReader(`{"name": "new name"}`)
+		code, data, err = requestHandler(authServer, token, http.MethodPatch, "/profiles?credit_limit=gt.10", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+		assertEqualField(t, "column not allowed: credit_limit", data, "msg")
+		code, data, err = requestHandler(authServer, token, http.MethodDelete, "/profiles?credit_limit=gt.10", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+		assertEqualField(t, "column not allowed: credit_limit", data, "msg")
+
+		body = strings.NewReader(`{"name": "another", "credit_limit": 1000}`)
+		code, data, err = requestHandler(authServer, token, http.MethodPost, "/profiles", body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+		assertEqualField(t, "column not allowed: credit_limit", data, "msg")
+
+		// admin users are not limited
+		token, err = auth.GenJWTToken([]byte("test-secret"), map[string]any{"user_id": 1, "is_admin": true})
+		if err != nil {
+			t.Error(err)
+		}
+		code, data, err = requestHandler(authServer, token, http.MethodGet, "/profiles?select=name,credit_limit", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assertEqualField(t, "new name", data.([]any)[0], "name")
+		assertEqualField(t, "100", data.([]any)[0], "credit_limit")
+	})
+}
+
+func TestServerTimeout(t *testing.T) {
+	s := &Server{timeout: TimeoutConfig{
+		Default: 10 * time.Second,
+		Max:     time.Minute,
+		Tables:  map[string]time.Duration{"invoices": 30 * time.Second, "rpc/report": 0},
+	}}
+	for _, test := range []struct {
+		resource string
+		prefer   string
+		timeout  time.Duration
+	}{
+		{resource: "customers", timeout: 10 * time.Second},
+		{resource: "invoices", timeout: 30 * time.Second},
+		{resource: "customers", prefer: "timeout=5s", timeout: 5 * time.Second},
+		{resource: "customers", prefer: "timeout=2m", timeout: time.Minute},
+		{resource: "rpc/report", timeout: time.Minute},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+test.resource, nil)
+		if test.prefer != "" {
+			req.Header.Set("Prefer", test.prefer)
+		}
+		timeout, err := s.requestTimeout(req, test.resource)
+		assert.Nil(t, err)
+		assert.Equal(t, test.timeout, timeout, test)
+	}
+
+	// timeouts asked by clients are capped without max
+	s.timeout.Max = 0
+	for prefer, expected := range map[string]time.Duration{
+		"timeout=100h": sql.DefaultTimeout,
+		"timeout=5s":   5 * time.Second,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/customers", nil)
+		req.Header.Set("Prefer", prefer)
+		timeout, err := s.requestTimeout(req, "customers")
+		assert.Nil(t, err)
+		assert.Equal(t, expected, timeout, prefer)
+	}
+
+	res, _, err := requestWithHeader(http.MethodGet, "/customers",
+		http.Header{"Prefer": []string{"timeout=5s"}}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, _, err = requestWithHeader(http.MethodGet, "/customers",
+		http.Header{"Prefer": []string{"timeout=abc"}}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestServerAdmin(t *testing.T) {
+	code, data, err := request(http.MethodGet, "/_admin/stats", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	stats := data.(map[string]any)
+	assert.Equal(t, float64(50), stats["max_open_connections"])
+	assert.Contains(t, stats, "in_use")
+
+	code, _, err = request(http.MethodGet, "/_admin/unknown", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _, err = request(http.MethodPost, "/_admin/stats", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	t.Run("stats are limited to admin users", func(t *testing.T) {
+		s := New(&DBConfig{URL: "sqlite://ci.db", MaxOpenConns: 10}, EnableAuth(true))
+		defer s.Close()
+		authServer := auth.NewMiddleware([]byte("test-secret"))(s)
+		token, err := auth.GenJWTToken([]byte("test-secret"), map[string]any{"user_id": 1})
+		assert.Nil(t, err)
+		code, _, err := requestHandler(authServer, token, http.MethodGet, "/_admin/stats", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, code)
+
+		token, err = auth.GenJWTToken([]byte("test-secret"), map[string]any{"user_id": 1, "is_admin": true})
+		assert.Nil(t, err)
+		code, data, err := requestHandler(authServer, token, http.MethodGet, "/_admin/stats", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(10), data.(map[string]any)["max_open_connections"])
+	})
+}
+
+func TestServerTables(t *testing.T) {
+	s := New(&DBConfig{URL: "sqlite://ci.db"}, Prefix("/db1"), Tables(TablesConfig{Exclude: []string{"invoices"}}))
+	defer s.Close()
+
+	code, _, err := requestHandler(s, "", http.MethodGet, "/db1/customers", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _, err = requestHandler(s, "", http.MethodGet, "/db1/invoices", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, code)
 }
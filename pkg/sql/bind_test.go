@@ -8,10 +8,14 @@ import (
 
 func TestBind(t *testing.T) {
 	origin := "SElECT * FROM A WHERE a = ? AND b = ?"
-	a := Rebind("mysql", origin)
+	a := Rebind(MyDialect{}, origin)
 	assert.Equal(t, origin, a)
 
 	psql := "SElECT * FROM A WHERE a = $1 AND b = $2"
-	b := Rebind("postgres", origin)
+	b := Rebind(PGDialect{}, origin)
 	assert.Equal(t, psql, b)
+
+	mssql := "SElECT * FROM A WHERE a = @p1 AND b = @p2"
+	c := Rebind(MSSQLDialect{}, origin)
+	assert.Equal(t, mssql, c)
 }
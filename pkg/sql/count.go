@@ -0,0 +1,102 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSupported is returned when an operation is not supported by the driver
+var ErrNotSupported = errors.New("not supported by the driver")
+
+// ExactCount returns the exact number of rows for the count query, e.g.
+// SELECT COUNT(1) AS count FROM table WHERE ...
+func (db *DB) ExactCount(ctx context.Context, query string, args ...any) (int64, error) {
+	object, err := db.FetchOne(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(object["count"])
+}
+
+// PlannedCount returns the number of rows estimated by the query planner for
+// the query, it's much faster than an exact count on large tables
+func (db *DB) PlannedCount(ctx context.Context, query string, args ...any) (int64, error) {
+	return db.dialect.PlannedCount(query, db.planFunc(ctx, args))
+}
+
+// planFunc runs plan statements with the args of the query
+func (db *DB) planFunc(ctx context.Context, args []any) PlanFunc {
+	return func(query string) ([]map[string]any, error) {
+		return db.FetchData(ctx, query, args...)
+	}
+}
+
+// EstimatedCount returns the number of rows in the table from the statistics
+// of the database, filters are not taken into account
+func (db *DB) EstimatedCount(ctx context.Context, tableName string) (int64, error) {
+	query := db.Dialect().GetEstimatedCountSQL()
+	if query == "" {
+		return 0, ErrNotSupported
+	}
+	object, err := db.FetchOne(ctx, query, tableName)
+	if err != nil {
+		return 0, err
+	}
+	count, err := toInt64(object["count"])
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		// table is never analyzed
+		return 0, ErrNotSupported
+	}
+	return count, nil
+}
+
+// pgPlan is the top level plan of PG `EXPLAIN (FORMAT JSON)` output
+type pgPlan struct {
+	Rows      float64 `json:"Plan Rows"`
+	TotalCost float64 `json:"Total Cost"`
+}
+
+// pgPlanOf runs `EXPLAIN (FORMAT JSON)` against the query and returns the
+// top level plan
+func pgPlanOf(query string, plan PlanFunc) (*pgPlan, error) {
+	objects, err := plan("EXPLAIN (FORMAT JSON) " + query)
+	if err != nil {
+		return nil, err
+	}
+	return parsePGPlan(objects)
+}
+
+func parsePGPlan(objects []map[string]any) (*pgPlan, error) {
+	if len(objects) == 0 {
+		return nil, errors.New("no plan found")
+	}
+	var rawPlan string
+	for _, v := range objects[0] {
+		rawPlan = fmt.Sprint(v)
+	}
+	var plans []struct {
+		Plan pgPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(rawPlan), &plans); err != nil {
+		return nil, fmt.Errorf("failed to parse plan, %w", err)
+	}
+	if len(plans) == 0 {
+		return nil, errors.New("no plan found")
+	}
+	return &plans[0].Plan, nil
+}
+
+func toInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("unexpected count value: %v", v)
+}
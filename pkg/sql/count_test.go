@@ -0,0 +1,44 @@
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePGPlan(t *testing.T) {
+	objects := []map[string]any{
+		{"QUERY PLAN": `[{"Plan": {"Node Type": "Seq Scan", "Plan Rows": 1234, "Total Cost": 35.5}}]`},
+	}
+	plan, err := parsePGPlan(objects)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1234), plan.Rows)
+	assert.Equal(t, 35.5, plan.TotalCost)
+
+	_, err = parsePGPlan(nil)
+	assert.NotNil(t, err)
+
+	_, err = parsePGPlan([]map[string]any{{"QUERY PLAN": "invalid"}})
+	assert.NotNil(t, err)
+}
+
+func TestDBCount(t *testing.T) {
+	db, err := setupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	count, err := db.ExactCount(ctx, "SELECT COUNT(1) AS count FROM customers WHERE Id > ?", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = db.PlannedCount(ctx, "SELECT 1 FROM customers")
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	_, err = db.EstimatedCount(ctx, "customers")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
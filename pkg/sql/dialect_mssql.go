@@ -0,0 +1,149 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+var (
+	mssqlConstraintRe = regexp.MustCompile(`constraint ['"]([^'"]+)['"]`)
+	mssqlColumnRe     = regexp.MustCompile(`column (?:name )?'([^']+)'`)
+)
+
+var mssqlCastTypes = castTypes(
+	"char", "varchar", "nchar", "nvarchar", "uniqueidentifier",
+	"int", "smallint", "bigint", "decimal", "numeric", "float", "real",
+	"bit", "date", "time", "datetime", "datetime2", "datetimeoffset",
+)
+
+// MSSQLDialect is the dialect of SQL Server
+type MSSQLDialect struct {
+	MSSQLHelper
+}
+
+func (d MSSQLDialect) Open(url string, options *Options) (driverName, dsn string, err error) {
+	return "sqlserver", url, nil
+}
+
+func (d MSSQLDialect) Quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (d MSSQLDialect) Placeholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+// Limit uses `OFFSET ... FETCH` which requires an ORDER BY clause, so an
+// order on nothing is added if the query isn't ordered
+func (d MSSQLDialect) Limit(limit, offset int, ordered bool) string {
+	clause := fmt.Sprintf(" OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", offset, limit)
+	if !ordered {
+		clause = " ORDER BY (SELECT NULL)" + clause
+	}
+	return clause
+}
+
+// Order emulates NULLS FIRST/LAST with CASE as SQL Server doesn't support
+// them and boolean expressions in ORDER BY
+func (d MSSQLDialect) Order(column, direction, nulls string) string {
+	switch nulls {
+	case NullsFirst:
+		return fmt.Sprintf("CASE WHEN %s IS NULL THEN 0 ELSE 1 END,%s", column, standardOrder(column, direction, ""))
+	case NullsLast:
+		return fmt.Sprintf("CASE WHEN %s IS NULL THEN 1 ELSE 0 END,%s", column, standardOrder(column, direction, ""))
+	}
+	return standardOrder(column, direction, "")
+}
+
+// JSONPath builds JSON path with JSON_VALUE for scalar values and falls back
+// to JSON_QUERY for objects and arrays, e.g.
+// `data->a->0` => `COALESCE(JSON_VALUE(data, '$.a[0]'), JSON_QUERY(data, '$.a[0]'))`
+func (d MSSQLDialect) JSONPath(column string) (jsonPath, asName string) {
+	parts := strings.Split(column, "->")
+	columnName := parts[0]
+	var pathBuilder strings.Builder
+	pathBuilder.WriteString("$")
+	for _, part := range parts[1:] {
+		part = strings.Trim(strings.Trim(strings.TrimPrefix(part, ">"), `'`), `"`)
+		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
+			pathBuilder.WriteString(fmt.Sprintf("[%s]", part))
+		} else {
+			// use last non number filed as name
+			asName = part
+			pathBuilder.WriteString("." + part)
+		}
+	}
+	path := pathBuilder.String()
+	jsonPath = fmt.Sprintf("COALESCE(JSON_VALUE(%s, '%s'), JSON_QUERY(%s, '%s'))", columnName, path, columnName, path)
+	return
+}
+
+func (d MSSQLDialect) CanCast(typ string) bool {
+	_, ok := mssqlCastTypes[typ]
+	return ok
+}
+
+// Upsert returns empty as SQL Server requires MERGE for upsert
+func (d MSSQLDialect) Upsert(keys, columns []string) string {
+	return ""
+}
+
+// Returning returns empty as the OUTPUT clause of SQL Server isn't at the end
+// of a statement
+func (d MSSQLDialect) Returning(columns string) string {
+	return ""
+}
+
+func (d MSSQLDialect) PrimaryKey() string {
+	return "BIGINT PRIMARY KEY IDENTITY"
+}
+
+func (d MSSQLDialect) NamedArg(name string) string {
+	return ""
+}
+
+func (d MSSQLDialect) TableFunctions() bool {
+	return false
+}
+
+func (d MSSQLDialect) Explain(query string, analyze bool) (string, error) {
+	return "", NewError(http.StatusBadRequest, "explain is not supported by sqlserver")
+}
+
+func (d MSSQLDialect) PlannedCount(query string, plan PlanFunc) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (d MSSQLDialect) QueryCost(query string, plan PlanFunc) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// StatementTimeout relies on the context which cancels the query on server
+func (d MSSQLDialect) StatementTimeout(query string, ms int64) ([]Statement, string) {
+	return noTimeout(query, ms)
+}
+
+func (d MSSQLDialect) SetRole(role, claims string) []Statement {
+	return nil
+}
+
+// ParseError finds constraint and column names in messages like
+// "Violation of PRIMARY KEY constraint 'PK_users'" as SQL Server doesn't
+// provide them
+func (d MSSQLDialect) ParseError(err error) *Error {
+	var mssqlError mssql.Error
+	if !errors.As(err, &mssqlError) {
+		return nil
+	}
+	return newError(mssqlErrCode(mssqlError.Number, mssqlError.Message), ErrorDetails{
+		DriverCode: int(mssqlError.Number),
+		Constraint: matchError(mssqlConstraintRe, mssqlError.Message),
+		Column:     matchError(mssqlColumnRe, mssqlError.Message),
+	})
+}
@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	myKeyRe        = regexp.MustCompile(`for key '([^']+)'`)
+	myConstraintRe = regexp.MustCompile("(?i)constraint [`']([^`']+)[`']")
+	myColumnRe     = regexp.MustCompile(`(?i)(?:column|field) '([^']+)'`)
+)
+
+var myCastTypes = castTypes(
+	"char", "binary",
+	"signed", "unsigned", "decimal", "double", "float",
+	"date", "time", "datetime",
+	"json",
+)
+
+// MyDialect is the dialect of MySQL
+type MyDialect struct {
+	MyHelper
+}
+
+func (d MyDialect) Open(url string, options *Options) (driverName, dsn string, err error) {
+	return "mysql", strings.TrimPrefix(url, "mysql://"), nil
+}
+
+func (d MyDialect) Quote(identifier string) string {
+	return "`" + identifier + "`"
+}
+
+func (d MyDialect) Placeholder(n int) string {
+	return questionPlaceholder(n)
+}
+
+func (d MyDialect) Limit(limit, offset int, ordered bool) string {
+	return limitOffset(limit, offset)
+}
+
+// Order emulates NULLS FIRST/LAST which isn't supported by MySQL by ordering
+// on `IS NULL` first, NULL values are treated as the lowest in MySQL
+func (d MyDialect) Order(column, direction, nulls string) string {
+	switch nulls {
+	case NullsFirst:
+		return fmt.Sprintf("%s IS NULL DESC,%s", column, standardOrder(column, direction, ""))
+	case NullsLast:
+		return fmt.Sprintf("%s IS NULL ASC,%s", column, standardOrder(column, direction, ""))
+	}
+	return standardOrder(column, direction, "")
+}
+
+// JSONPath builds JSON path with `->` and `$` path, e.g.
+// `data->a->>0` => `data->'$.a[0]'`
+func (d MyDialect) JSONPath(column string) (jsonPath, asName string) {
+	parts := strings.Split(column, "->")
+	columnName := parts[0]
+	parts = parts[1:]
+	for i, part := range parts {
+		part = strings.Trim(strings.Trim(strings.TrimPrefix(part, ">"), `'`), `"`)
+		isIndex := false
+		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
+			isIndex = true
+		}
+		if isIndex {
+			part = fmt.Sprintf("[%s]", part)
+		} else {
+			// use last non number filed as name
+			asName = part
+			// add dot to non number field
+			part = "." + part
+		}
+		parts[i] = part
+	}
+	jsonPath = fmt.Sprintf("%s->'$%s'", columnName, strings.Join(parts, ""))
+	return
+}
+
+func (d MyDialect) CanCast(typ string) bool {
+	_, ok := myCastTypes[typ]
+	return ok
+}
+
+// Upsert returns `ON DUPLICATE KEY UPDATE` clause, the keys are decided by
+// the unique indexes of the table in MySQL
+func (d MyDialect) Upsert(keys, columns []string) string {
+	sets := make([]string, 0, len(columns))
+	for _, c := range columns {
+		sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", c, c))
+	}
+	if len(sets) == 0 {
+		// nothing to update, keep the row as it is
+		sets = append(sets, fmt.Sprintf("%s = %s", keys[0], keys[0]))
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (d MyDialect) Returning(columns string) string {
+	return ""
+}
+
+func (d MyDialect) PrimaryKey() string {
+	return "BIGINT PRIMARY KEY AUTO_INCREMENT"
+}
+
+func (d MyDialect) NamedArg(name string) string {
+	return ""
+}
+
+func (d MyDialect) TableFunctions() bool {
+	return false
+}
+
+func (d MyDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		// EXPLAIN ANALYZE only supports TREE format
+		return "EXPLAIN ANALYZE " + query, nil
+	}
+	return "EXPLAIN FORMAT=JSON " + query, nil
+}
+
+// PlannedCount returns the rows of the first table in the plan
+func (d MyDialect) PlannedCount(query string, plan PlanFunc) (int64, error) {
+	objects, err := plan("EXPLAIN " + query)
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, errors.New("no plan found")
+	}
+	return toInt64(objects[0]["rows"])
+}
+
+func (d MyDialect) QueryCost(query string, plan PlanFunc) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// StatementTimeout adds the MAX_EXECUTION_TIME hint to SELECT queries
+func (d MyDialect) StatementTimeout(query string, ms int64) ([]Statement, string) {
+	return nil, addMaxExecutionTime(query, ms)
+}
+
+func (d MyDialect) SetRole(role, claims string) []Statement {
+	return nil
+}
+
+// ParseError finds constraint and column names in messages like
+// "Duplicate entry '1' for key 'users.PRIMARY'" as MySQL doesn't provide them
+func (d MyDialect) ParseError(err error) *Error {
+	var myError *mysql.MySQLError
+	if !errors.As(err, &myError) {
+		return nil
+	}
+	constraint := matchError(myKeyRe, myError.Message)
+	if constraint == "" {
+		constraint = matchError(myConstraintRe, myError.Message)
+	}
+	return newError(myErrCode(int(myError.Number)), ErrorDetails{
+		SQLState:   string(myError.SQLState[:]),
+		DriverCode: int(myError.Number),
+		Constraint: constraint,
+		Column:     matchError(myColumnRe, myError.Message),
+	})
+}
@@ -0,0 +1,163 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var pgCastTypes = castTypes(
+	"text", "varchar", "char", "uuid",
+	"int", "integer", "smallint", "bigint",
+	"numeric", "decimal", "real", "float", "float4", "float8",
+	"bool", "boolean",
+	"date", "time", "timestamp", "timestamptz", "interval",
+	"json", "jsonb",
+)
+
+// PGDialect is the dialect of PostgreSQL
+type PGDialect struct {
+	PGHelper
+}
+
+func (d PGDialect) Open(url string, options *Options) (driverName, dsn string, err error) {
+	dsn, err = pgDSN(url, options)
+	return "pgx", dsn, err
+}
+
+func (d PGDialect) Quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (d PGDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (d PGDialect) Limit(limit, offset int, ordered bool) string {
+	return limitOffset(limit, offset)
+}
+
+func (d PGDialect) Order(column, direction, nulls string) string {
+	return standardOrder(column, direction, nulls)
+}
+
+// JSONPath builds JSON path with `->` and `->>` operators, e.g.
+// `data->a->>0` => `data->'a'->>0`
+func (d PGDialect) JSONPath(column string) (jsonPath, asName string) {
+	parts := strings.Split(column, "->")
+	for i, part := range parts {
+		if i == 0 {
+			// skip column name
+			continue
+		}
+		doubleArrow := false
+		if strings.HasPrefix(part, ">") {
+			doubleArrow = true
+			part = part[1:]
+		}
+		part = strings.Trim(strings.Trim(part, `'`), `"`)
+		isIndex := false
+		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
+			isIndex = true
+		}
+		if !isIndex {
+			// use last non number filed as name
+			asName = part
+			// add quote for non number field
+			part = fmt.Sprintf(`'%s'`, part)
+		}
+		if doubleArrow {
+			part = ">" + part
+		}
+		parts[i] = part
+	}
+	jsonPath = strings.Join(parts, "->")
+	return
+}
+
+func (d PGDialect) CanCast(typ string) bool {
+	_, ok := pgCastTypes[typ]
+	return ok
+}
+
+func (d PGDialect) Upsert(keys, columns []string) string {
+	return upsertExcluded(keys, columns)
+}
+
+func (d PGDialect) Returning(columns string) string {
+	return " RETURNING " + columns
+}
+
+func (d PGDialect) PrimaryKey() string {
+	return "BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY"
+}
+
+func (d PGDialect) NamedArg(name string) string {
+	return name + " => ?"
+}
+
+func (d PGDialect) TableFunctions() bool {
+	return true
+}
+
+func (d PGDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		return "EXPLAIN (FORMAT JSON, ANALYZE) " + query, nil
+	}
+	return "EXPLAIN (FORMAT JSON) " + query, nil
+}
+
+func (d PGDialect) PlannedCount(query string, plan PlanFunc) (int64, error) {
+	p, err := pgPlanOf(query, plan)
+	if err != nil {
+		return 0, err
+	}
+	return int64(p.Rows), nil
+}
+
+func (d PGDialect) QueryCost(query string, plan PlanFunc) (float64, error) {
+	p, err := pgPlanOf(query, plan)
+	if err != nil {
+		return 0, err
+	}
+	return p.TotalCost, nil
+}
+
+// StatementTimeout sets `statement_timeout` in the transaction
+func (d PGDialect) StatementTimeout(query string, ms int64) ([]Statement, string) {
+	return []Statement{{Query: fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)}}, query
+}
+
+// SetRole runs `SET LOCAL ROLE` and sets the claims to
+// `request.jwt.claims` so that row-level security policies can use them
+func (d PGDialect) SetRole(role, claims string) []Statement {
+	// the role can't be a placeholder, it's quoted
+	name := `"` + strings.ReplaceAll(role, `"`, `""`) + `"`
+	return []Statement{
+		{Query: "SET LOCAL ROLE " + name},
+		{Query: "SELECT set_config('request.jwt.claims', ?, true)", Args: []any{claims}},
+	}
+}
+
+func (d PGDialect) ParseError(err error) *Error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+	errCode := pgErrCode(pgErr.Code)
+	// 23503 is used for both inserting a row without the referenced one and
+	// deleting a row which is still referenced
+	if pgErr.Code == PGForeignKeyViolation && strings.HasPrefix(pgErr.Message, "update or delete") {
+		errCode = ErrCodeRowReferenced
+	}
+	return newError(errCode, ErrorDetails{
+		SQLState:   pgErr.Code,
+		Table:      pgErr.TableName,
+		Constraint: pgErr.ConstraintName,
+		Column:     pgErr.ColumnName,
+		Hint:       pgErr.Hint,
+	})
+}
@@ -0,0 +1,114 @@
+package sql
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+var (
+	sqliteColumnRe     = regexp.MustCompile(`constraint failed: (\w+)\.(\w+)`)
+	sqliteConstraintRe = regexp.MustCompile(`CHECK constraint failed: (\w+)`)
+)
+
+var sqliteCastTypes = castTypes("text", "integer", "real", "numeric", "blob")
+
+// SQLiteDialect is the dialect of SQLite
+type SQLiteDialect struct {
+	SQLiteHelper
+}
+
+func (d SQLiteDialect) Open(url string, options *Options) (driverName, dsn string, err error) {
+	return "sqlite", sqliteDSN(strings.TrimPrefix(url, "sqlite://"), options), nil
+}
+
+func (d SQLiteDialect) Quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (d SQLiteDialect) Placeholder(n int) string {
+	return questionPlaceholder(n)
+}
+
+func (d SQLiteDialect) Limit(limit, offset int, ordered bool) string {
+	return limitOffset(limit, offset)
+}
+
+func (d SQLiteDialect) Order(column, direction, nulls string) string {
+	return standardOrder(column, direction, nulls)
+}
+
+// JSONPath builds JSON path with `->` and `->>` operators which are
+// compatible with both MySQL and PG
+func (d SQLiteDialect) JSONPath(column string) (jsonPath, asName string) {
+	return PGDialect{}.JSONPath(column)
+}
+
+func (d SQLiteDialect) CanCast(typ string) bool {
+	_, ok := sqliteCastTypes[typ]
+	return ok
+}
+
+func (d SQLiteDialect) Upsert(keys, columns []string) string {
+	return upsertExcluded(keys, columns)
+}
+
+func (d SQLiteDialect) Returning(columns string) string {
+	return " RETURNING " + columns
+}
+
+func (d SQLiteDialect) PrimaryKey() string {
+	return "INTEGER PRIMARY KEY"
+}
+
+func (d SQLiteDialect) NamedArg(name string) string {
+	return ""
+}
+
+func (d SQLiteDialect) TableFunctions() bool {
+	return false
+}
+
+func (d SQLiteDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		return "", NewError(http.StatusBadRequest, "explain analyze is not supported by sqlite")
+	}
+	return "EXPLAIN QUERY PLAN " + query, nil
+}
+
+func (d SQLiteDialect) PlannedCount(query string, plan PlanFunc) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (d SQLiteDialect) QueryCost(query string, plan PlanFunc) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// StatementTimeout relies on the context as SQLite runs in process
+func (d SQLiteDialect) StatementTimeout(query string, ms int64) ([]Statement, string) {
+	return noTimeout(query, ms)
+}
+
+func (d SQLiteDialect) SetRole(role, claims string) []Statement {
+	return nil
+}
+
+// ParseError finds table and column names in messages like
+// "NOT NULL constraint failed: users.name" as SQLite doesn't provide them
+func (d SQLiteDialect) ParseError(err error) *Error {
+	var sqliteError *sqlite.Error
+	if !errors.As(err, &sqliteError) {
+		return nil
+	}
+	msg := sqliteError.Error()
+	details := ErrorDetails{DriverCode: sqliteError.Code()}
+	if m := sqliteColumnRe.FindStringSubmatch(msg); m != nil {
+		details.Table, details.Column = m[1], m[2]
+	} else {
+		details.Constraint = matchError(sqliteConstraintRe, msg)
+	}
+	return newError(sqliteErrCode(sqliteError.Code(), msg), details)
+}
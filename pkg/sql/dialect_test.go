@@ -0,0 +1,153 @@
+package sql
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialectLimit(t *testing.T) {
+	for _, test := range []struct {
+		driver  string
+		ordered bool
+		limit   int
+		offset  int
+		query   string
+	}{
+		{driver: "postgres", limit: 10, query: " LIMIT 10"},
+		{driver: "sqlite", limit: 10, offset: 20, query: " LIMIT 10 OFFSET 20"},
+		{driver: "sqlserver", ordered: true, limit: 10, offset: 20, query: " OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY"},
+		{driver: "sqlserver", limit: 10, query: " ORDER BY (SELECT NULL) OFFSET 0 ROWS FETCH NEXT 10 ROWS ONLY"},
+	} {
+		assert.Equal(t, test.query, mustDialect(test.driver).Limit(test.limit, test.offset, test.ordered))
+	}
+}
+
+func TestDialectUpsert(t *testing.T) {
+	keys, columns := []string{"id"}, []string{"a", "b"}
+	for _, test := range []struct {
+		driver  string
+		columns []string
+		query   string
+	}{
+		{driver: "postgres", columns: columns, query: " ON CONFLICT (id) DO UPDATE SET a = EXCLUDED.a, b = EXCLUDED.b"},
+		{driver: "postgres", query: " ON CONFLICT (id) DO NOTHING"},
+		{driver: "sqlite", columns: columns, query: " ON CONFLICT (id) DO UPDATE SET a = EXCLUDED.a, b = EXCLUDED.b"},
+		{driver: "mysql", columns: columns, query: " ON DUPLICATE KEY UPDATE a = VALUES(a), b = VALUES(b)"},
+		{driver: "mysql", query: " ON DUPLICATE KEY UPDATE id = id"},
+		{driver: "sqlserver", columns: columns, query: ""},
+	} {
+		assert.Equal(t, test.query, mustDialect(test.driver).Upsert(keys, test.columns), test.driver)
+	}
+}
+
+func TestDialectReturning(t *testing.T) {
+	assert.Equal(t, " RETURNING *", mustDialect("postgres").Returning("*"))
+	assert.Equal(t, " RETURNING *", mustDialect("sqlite").Returning("*"))
+	assert.Equal(t, "", mustDialect("mysql").Returning("*"))
+	assert.Equal(t, "", mustDialect("sqlserver").Returning("*"))
+}
+
+// testDialect is a third-party dialect which reuses SQLite with its own
+// url scheme
+type testDialect struct {
+	SQLiteDialect
+}
+
+func (d testDialect) Open(url string, options *Options) (driverName, dsn string, err error) {
+	return d.SQLiteDialect.Open(strings.Replace(url, "test://", "sqlite://", 1), options)
+}
+
+func (d testDialect) Quote(identifier string) string {
+	return "[" + identifier + "]"
+}
+
+func TestRegisterDialect(t *testing.T) {
+	RegisterDialect("test", testDialect{})
+	assert.Panics(t, func() { RegisterDialect("test", testDialect{}) })
+	assert.Panics(t, func() { RegisterDialect("nil", nil) })
+	assert.Contains(t, Dialects(), "test")
+
+	_, ok := GetDialect("unknown")
+	assert.False(t, ok)
+	_, err := Open("unknown://db")
+	assert.NotNil(t, err)
+
+	db, err := Open("test://ci.db")
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.FetchData(context.Background(), "SELECT 1 AS a")
+	assert.Nil(t, err)
+
+	q := NewURLQuery(map[string][]string{"select": {"name:Name"}}, testDialect{})
+	query, err := q.SelectQuery()
+	assert.Nil(t, err)
+	assert.Equal(t, "Name AS [name]", query)
+}
+
+func mustDialect(name string) Dialect {
+	dialect, ok := GetDialect(name)
+	if !ok {
+		panic("unknown dialect: " + name)
+	}
+	return dialect
+}
+
+func TestDialectStatementTimeout(t *testing.T) {
+	query := "SELECT * FROM t"
+	settings, hinted := mustDialect("postgres").StatementTimeout(query, 5000)
+	assert.Equal(t, []Statement{{Query: "SET LOCAL statement_timeout = 5000"}}, settings)
+	assert.Equal(t, query, hinted)
+
+	settings, hinted = mustDialect("mysql").StatementTimeout(query, 5000)
+	assert.Nil(t, settings)
+	assert.Equal(t, "SELECT /*+ MAX_EXECUTION_TIME(5000) */ * FROM t", hinted)
+
+	for _, driver := range []string{"sqlite", "sqlserver"} {
+		settings, hinted = mustDialect(driver).StatementTimeout(query, 5000)
+		assert.Nil(t, settings)
+		assert.Equal(t, query, hinted)
+	}
+}
+
+func TestDialectSetRole(t *testing.T) {
+	settings := mustDialect("postgres").SetRole(`a"b`, `{"user_id":1}`)
+	assert.Equal(t, []Statement{
+		{Query: `SET LOCAL ROLE "a""b"`},
+		{Query: "SELECT set_config('request.jwt.claims', ?, true)", Args: []any{`{"user_id":1}`}},
+	}, settings)
+	for _, driver := range []string{"mysql", "sqlite", "sqlserver"} {
+		assert.Nil(t, mustDialect(driver).SetRole("anon", "{}"), driver)
+	}
+}
+
+func TestDialectPlan(t *testing.T) {
+	plan := func(query string) ([]map[string]any, error) {
+		if strings.HasPrefix(query, "EXPLAIN (FORMAT JSON) ") {
+			return []map[string]any{{"QUERY PLAN": `[{"Plan": {"Plan Rows": 42, "Total Cost": 1.5}}]`}}, nil
+		}
+		return []map[string]any{{"rows": int64(7)}}, nil
+	}
+	count, err := mustDialect("postgres").PlannedCount("SELECT 1", plan)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), count)
+	cost, err := mustDialect("postgres").QueryCost("SELECT 1", plan)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, cost)
+
+	count, err = mustDialect("mysql").PlannedCount("SELECT 1", plan)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), count)
+	_, err = mustDialect("mysql").QueryCost("SELECT 1", plan)
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	_, err = mustDialect("sqlserver").PlannedCount("SELECT 1", plan)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = mustDialect("sqlserver").Explain("SELECT 1", false)
+	var sqlErr Error
+	assert.ErrorAs(t, err, &sqlErr)
+	assert.Equal(t, http.StatusBadRequest, sqlErr.Code)
+}
@@ -1,14 +1,12 @@
 package sql
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
-
-	"github.com/go-sql-driver/mysql"
-	"github.com/jackc/pgconn"
-	"modernc.org/sqlite"
 )
 
 const (
@@ -16,85 +14,381 @@ const (
 	// https://www.postgresql.org/docs/current/errcodes-appendix.html
 	PGIntegrityConstraintViolation = "23"
 	PGSyntaxError                  = "42"
+	PGQueryCanceled                = "57014"
+	PGNotNullViolation             = "23502"
+	PGForeignKeyViolation          = "23503"
+	PGUniqueViolation              = "23505"
+	PGCheckViolation               = "23514"
+	PGExclusionViolation           = "23P01"
+	PGSyntaxErrorStatement         = "42601"
+	PGInsufficientPrivilege        = "42501"
+	PGUndefinedColumn              = "42703"
+	PGUndefinedFunction            = "42883"
+	PGUndefinedTable               = "42P01"
+	PGInvalidTextRepresentation    = "22P02"
+	PGStringDataRightTruncation    = "22001"
+	PGSerializationFailure         = "40001"
+	PGDeadlockDetected             = "40P01"
+	PGLockNotAvailable             = "55P03"
 
 	// MySQL errors
 	// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
-	MYErrNoDefaultForField = 1364
+	MYErrNoDefaultForField    = 1364
+	MYErrQueryTimeout         = 3024
+	MYErrDBAccessDenied       = 1044
+	MYErrBadNull              = 1048
+	MYErrBadField             = 1054
+	MYErrDupEntry             = 1062
+	MYErrParse                = 1064
+	MYErrTableAccessDenied    = 1142
+	MYErrColumnAccessDenied   = 1143
+	MYErrNoSuchTable          = 1146
+	MYErrLockWaitTimeout      = 1205
+	MYErrLockDeadlock         = 1213
+	MYErrNoReferencedRowOld   = 1216
+	MYErrRowIsReferencedOld   = 1217
+	MYErrOutOfRange           = 1264
+	MYErrTruncatedWrongValue  = 1292
+	MYErrTruncatedValue       = 1366
+	MYErrDataTooLong          = 1406
+	MYErrRowIsReferenced      = 1451
+	MYErrNoReferencedRow      = 1452
+	MYErrDupEntryWithKeyName  = 1586
+	MYErrLockNowait           = 3572
+	MYErrCheckViolated        = 3819
+	MYErrTooManyConnections   = 1040
+	MYErrSpecificAccessDenied = 1227
+
+	// SQL Server errors
+	// https://learn.microsoft.com/en-us/sql/relational-databases/errors-events/database-engine-events-and-errors
+	MSSQLErrSyntax              = 102
+	MSSQLErrInvalidColumn       = 207
+	MSSQLErrInvalidObject       = 208
+	MSSQLErrPermissionDenied    = 229
+	MSSQLErrColumnPermission    = 230
+	MSSQLErrNotNULL             = 515
+	MSSQLErrConstraint          = 547
+	MSSQLErrDeadlock            = 1205
+	MSSQLErrLockTimeout         = 1222
+	MSSQLErrUniqueIndex         = 2601
+	MSSQLErrUniqueConstraint    = 2627
+	MSSQLErrStringTruncated     = 2628
+	MSSQLErrSnapshotConflict    = 3960
+	MSSQLErrConversionFailure   = 245
+	MSSQLErrConversionOverflow  = 8114
+	MSSQLErrStringTruncatedOld  = 8152
+	MSSQLErrInvalidObjectSchema = 4902
 
 	// SQLite errors
 	// https://www.sqlite.org/rescode.html
+	SQLiteError                = 1
+	SQLitePerm                 = 3
+	SQLiteBusy                 = 5
+	SQLiteLocked               = 6
+	SQLiteInterrupt            = 9
+	SQLiteConstraint           = 19
+	SQLiteTooBig               = 18
+	SQLiteAuth                 = 23
+	SQLiteBusySnapshot         = 517
 	SQLiteConstraintNotNULL    = 1299
 	SQLiteConstraintPrimaryKey = 1555
 	SQLiteConstraintUnique     = 2067
+	SQLiteConstraintForeignKey = 787
+	SQLiteConstraintCheck      = 275
+)
+
+// Stable codes of errors for clients to match on instead of messages
+const (
+	ErrCodeUniqueViolation      = "unique_violation"
+	ErrCodeForeignKeyViolation  = "foreign_key_violation" // the referenced row doesn't exist
+	ErrCodeRowReferenced        = "row_referenced"        // the row is referenced by a foreign key
+	ErrCodeNotNullViolation     = "not_null_violation"
+	ErrCodeCheckViolation       = "check_violation"
+	ErrCodeConstraintViolation  = "constraint_violation" // other integrity constraints
+	ErrCodeSyntaxError          = "syntax_error"
+	ErrCodeUndefinedTable       = "undefined_table"
+	ErrCodeUndefinedColumn      = "undefined_column"
+	ErrCodeUndefinedFunction    = "undefined_function"
+	ErrCodeInvalidValue         = "invalid_value"
+	ErrCodeValueTooLong         = "value_too_long"
+	ErrCodePermissionDenied     = "permission_denied"
+	ErrCodeLockTimeout          = "lock_timeout"
+	ErrCodeSerializationFailure = "serialization_failure"
+	ErrCodeDeadlockDetected     = "deadlock_detected"
+	ErrCodeUnavailable          = "database_unavailable"
+	ErrCodeStatementTimeout     = "statement_timeout"
+	ErrCodeDatabaseError        = "database_error"
+)
+
+// errorStatus is the HTTP code of a stable error code and whether the
+// request may succeed if it's retried
+type errorStatus struct {
+	code      int
+	retryable bool
+}
+
+var errorStatuses = map[string]errorStatus{
+	ErrCodeUniqueViolation:      {http.StatusConflict, false},
+	ErrCodeForeignKeyViolation:  {http.StatusUnprocessableEntity, false},
+	ErrCodeRowReferenced:        {http.StatusConflict, false},
+	ErrCodeNotNullViolation:     {http.StatusUnprocessableEntity, false},
+	ErrCodeCheckViolation:       {http.StatusUnprocessableEntity, false},
+	ErrCodeConstraintViolation:  {http.StatusConflict, false},
+	ErrCodeSyntaxError:          {http.StatusBadRequest, false},
+	ErrCodeUndefinedTable:       {http.StatusBadRequest, false},
+	ErrCodeUndefinedColumn:      {http.StatusBadRequest, false},
+	ErrCodeUndefinedFunction:    {http.StatusBadRequest, false},
+	ErrCodeInvalidValue:         {http.StatusBadRequest, false},
+	ErrCodeValueTooLong:         {http.StatusUnprocessableEntity, false},
+	ErrCodePermissionDenied:     {http.StatusForbidden, false},
+	ErrCodeLockTimeout:          {http.StatusServiceUnavailable, true},
+	ErrCodeSerializationFailure: {http.StatusConflict, true},
+	ErrCodeDeadlockDetected:     {http.StatusConflict, true},
+	ErrCodeUnavailable:          {http.StatusServiceUnavailable, true},
+	ErrCodeStatementTimeout:     {http.StatusGatewayTimeout, false},
+	ErrCodeDatabaseError:        {http.StatusInternalServerError, false},
+}
+
+// pgErrCodes maps PG SQLSTATE to stable error codes, pgErrClasses is used
+// for the rest of SQLSTATE by the first two characters
+var (
+	pgErrCodes = map[string]string{
+		PGUniqueViolation:           ErrCodeUniqueViolation,
+		PGForeignKeyViolation:       ErrCodeForeignKeyViolation,
+		PGNotNullViolation:          ErrCodeNotNullViolation,
+		PGCheckViolation:            ErrCodeCheckViolation,
+		PGExclusionViolation:        ErrCodeConstraintViolation,
+		PGSyntaxErrorStatement:      ErrCodeSyntaxError,
+		PGInsufficientPrivilege:     ErrCodePermissionDenied,
+		PGUndefinedTable:            ErrCodeUndefinedTable,
+		PGUndefinedColumn:           ErrCodeUndefinedColumn,
+		PGUndefinedFunction:         ErrCodeUndefinedFunction,
+		PGInvalidTextRepresentation: ErrCodeInvalidValue,
+		PGStringDataRightTruncation: ErrCodeValueTooLong,
+		PGSerializationFailure:      ErrCodeSerializationFailure,
+		PGDeadlockDetected:          ErrCodeDeadlockDetected,
+		PGLockNotAvailable:          ErrCodeLockTimeout,
+		PGQueryCanceled:             ErrCodeStatementTimeout,
+	}
+	pgErrClasses = map[string]string{
+		"08":                           ErrCodeUnavailable,  // connection exception
+		"22":                           ErrCodeInvalidValue, // data exception
+		PGIntegrityConstraintViolation: ErrCodeConstraintViolation,
+		"40":                           ErrCodeSerializationFailure, // transaction rollback
+		PGSyntaxError:                  ErrCodeSyntaxError,
+		"53":                           ErrCodeUnavailable, // insufficient resources
+		"57":                           ErrCodeUnavailable, // operator intervention
+	}
+)
+
+// myErrCodes maps MySQL error numbers to stable error codes
+var myErrCodes = map[int]string{
+	MYErrDupEntry:             ErrCodeUniqueViolation,
+	MYErrDupEntryWithKeyName:  ErrCodeUniqueViolation,
+	MYErrNoReferencedRow:      ErrCodeForeignKeyViolation,
+	MYErrNoReferencedRowOld:   ErrCodeForeignKeyViolation,
+	MYErrRowIsReferenced:      ErrCodeRowReferenced,
+	MYErrRowIsReferencedOld:   ErrCodeRowReferenced,
+	MYErrBadNull:              ErrCodeNotNullViolation,
+	MYErrNoDefaultForField:    ErrCodeNotNullViolation,
+	MYErrCheckViolated:        ErrCodeCheckViolation,
+	MYErrParse:                ErrCodeSyntaxError,
+	MYErrNoSuchTable:          ErrCodeUndefinedTable,
+	MYErrBadField:             ErrCodeUndefinedColumn,
+	MYErrTruncatedValue:       ErrCodeInvalidValue,
+	MYErrTruncatedWrongValue:  ErrCodeInvalidValue,
+	MYErrOutOfRange:           ErrCodeInvalidValue,
+	MYErrDataTooLong:          ErrCodeValueTooLong,
+	MYErrDBAccessDenied:       ErrCodePermissionDenied,
+	MYErrTableAccessDenied:    ErrCodePermissionDenied,
+	MYErrColumnAccessDenied:   ErrCodePermissionDenied,
+	MYErrSpecificAccessDenied: ErrCodePermissionDenied,
+	MYErrLockWaitTimeout:      ErrCodeLockTimeout,
+	MYErrLockNowait:           ErrCodeLockTimeout,
+	MYErrLockDeadlock:         ErrCodeDeadlockDetected,
+	MYErrTooManyConnections:   ErrCodeUnavailable,
+	MYErrQueryTimeout:         ErrCodeStatementTimeout,
+}
+
+// mssqlErrCodes maps SQL Server error numbers to stable error codes, 547 is
+// used by foreign key and check constraints which is told by the message
+var mssqlErrCodes = map[int32]string{
+	MSSQLErrUniqueIndex:         ErrCodeUniqueViolation,
+	MSSQLErrUniqueConstraint:    ErrCodeUniqueViolation,
+	MSSQLErrConstraint:          ErrCodeCheckViolation,
+	MSSQLErrNotNULL:             ErrCodeNotNullViolation,
+	MSSQLErrSyntax:              ErrCodeSyntaxError,
+	MSSQLErrInvalidObject:       ErrCodeUndefinedTable,
+	MSSQLErrInvalidObjectSchema: ErrCodeUndefinedTable,
+	MSSQLErrInvalidColumn:       ErrCodeUndefinedColumn,
+	MSSQLErrConversionFailure:   ErrCodeInvalidValue,
+	MSSQLErrConversionOverflow:  ErrCodeInvalidValue,
+	MSSQLErrStringTruncated:     ErrCodeValueTooLong,
+	MSSQLErrStringTruncatedOld:  ErrCodeValueTooLong,
+	MSSQLErrPermissionDenied:    ErrCodePermissionDenied,
+	MSSQLErrColumnPermission:    ErrCodePermissionDenied,
+	MSSQLErrLockTimeout:         ErrCodeLockTimeout,
+	MSSQLErrDeadlock:            ErrCodeDeadlockDetected,
+	MSSQLErrSnapshotConflict:    ErrCodeSerializationFailure,
+}
+
+// sqliteErrCodes maps SQLite extended result codes to stable error codes,
+// the primary result code in the lowest byte is used if the extended one
+// isn't found, and SQLITE_ERROR is told by sqliteErrMessages
+var (
+	sqliteErrCodes = map[int]string{
+		SQLiteConstraintPrimaryKey: ErrCodeUniqueViolation,
+		SQLiteConstraintUnique:     ErrCodeUniqueViolation,
+		SQLiteConstraintForeignKey: ErrCodeForeignKeyViolation,
+		SQLiteConstraintNotNULL:    ErrCodeNotNullViolation,
+		SQLiteConstraintCheck:      ErrCodeCheckViolation,
+		SQLiteConstraint:           ErrCodeConstraintViolation,
+		SQLiteTooBig:               ErrCodeValueTooLong,
+		SQLitePerm:                 ErrCodePermissionDenied,
+		SQLiteAuth:                 ErrCodePermissionDenied,
+		SQLiteBusySnapshot:         ErrCodeSerializationFailure,
+		SQLiteBusy:                 ErrCodeLockTimeout,
+		SQLiteLocked:               ErrCodeLockTimeout,
+		SQLiteInterrupt:            ErrCodeStatementTimeout,
+	}
+	sqliteErrMessages = []struct {
+		re      *regexp.Regexp
+		errCode string
+	}{
+		{regexp.MustCompile(`no such table`), ErrCodeUndefinedTable},
+		{regexp.MustCompile(`no such column`), ErrCodeUndefinedColumn},
+		{regexp.MustCompile(`no such function`), ErrCodeUndefinedFunction},
+		{regexp.MustCompile(`syntax error|unrecognized token`), ErrCodeSyntaxError},
+	}
 )
 
+// ErrorDetails are the machine-readable details of a database error
+type ErrorDetails struct {
+	SQLState   string `json:"sqlstate,omitempty"`    // SQLSTATE of PG and MySQL
+	DriverCode int    `json:"driver_code,omitempty"` // error number of MySQL, SQLite and SQL Server
+	Table      string `json:"table,omitempty"`
+	Constraint string `json:"constraint,omitempty"`
+	Column     string `json:"column,omitempty"`
+	Hint       string `json:"hint,omitempty"`
+	Retryable  bool   `json:"retryable,omitempty"` // e.g. serialization failure
+}
+
 // Error converts database error to http code
 type Error struct {
-	Code int
-	Msg  string
+	Code    int    // http code
+	Msg     string // message including the raw database message
+	ErrCode string // stable code, e.g. unique_violation
+	ErrorDetails
+
+	summary string // message without the raw database message
 }
 
 func (e Error) Error() string {
 	return e.Msg
 }
 
-func NewError(code int, msg string) Error {
-	return Error{code, msg}
+// SafeMsg returns the message without the raw database message, which may
+// reveal the schema or data
+func (e Error) SafeMsg() string {
+	if e.summary != "" {
+		return e.summary
+	}
+	return e.Msg
 }
 
-func convertError(hint string, err error) Error {
-	code := http.StatusInternalServerError
-	msg := err.Error()
+func NewError(code int, msg string) Error {
+	return Error{Code: code, Msg: msg}
+}
 
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		code = pgErrCodeToHTTPCode(pgErr.Code)
+func convertError(msg string, err error) Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		dbErr := newError(ErrCodeStatementTimeout, ErrorDetails{})
+		dbErr.Msg = fmt.Sprintf("%s, statement timeout exceeded", msg)
+		dbErr.summary = msg
+		return *dbErr
 	}
 
-	var myError *mysql.MySQLError
-	if errors.As(err, &myError) {
-		code = myErrCodeToHTTPCode(int(myError.Number))
+	dbErr := parseError(err)
+	if dbErr == nil {
+		dbErr = newError(ErrCodeDatabaseError, ErrorDetails{})
 	}
+	dbErr.Msg = fmt.Sprintf("%s, %s", msg, err.Error())
+	dbErr.summary = msg
+	return *dbErr
+}
 
-	var sqliteError *sqlite.Error
-	if errors.As(err, &sqliteError) {
-		code = sqliteErrCodeToHTTPCode(sqliteError.Code())
+// matchError returns the first submatch of re in the message of a driver
+// error, it's used to find constraint or column names which are only in
+// messages of some drivers
+func matchError(re *regexp.Regexp, msg string) string {
+	if m := re.FindStringSubmatch(msg); len(m) > 1 {
+		return m[1]
 	}
+	return ""
+}
 
-	return Error{
-		Code: code,
-		Msg:  fmt.Sprintf("%s, %s", hint, msg),
+// newError returns Error of a stable error code, the HTTP code and whether
+// it's retryable are decided by the code
+func newError(errCode string, details ErrorDetails) *Error {
+	status, ok := errorStatuses[errCode]
+	if !ok {
+		errCode, status = ErrCodeDatabaseError, errorStatuses[ErrCodeDatabaseError]
 	}
+	details.Retryable = status.retryable
+	return &Error{Code: status.code, ErrCode: errCode, ErrorDetails: details}
 }
 
-// pgErrCodeToHTTPCode converts PG Error to HTTP code
-func pgErrCodeToHTTPCode(code string) int {
-	if strings.HasPrefix(code, PGIntegrityConstraintViolation) {
-		return http.StatusBadRequest
+// pgErrCode converts PG SQLSTATE to stable error code
+func pgErrCode(code string) string {
+	if errCode, ok := pgErrCodes[code]; ok {
+		return errCode
 	}
-	if strings.HasPrefix(code, PGSyntaxError) {
-		return http.StatusBadRequest
+	if len(code) >= 2 {
+		if errCode, ok := pgErrClasses[code[:2]]; ok {
+			return errCode
+		}
 	}
-	return http.StatusInternalServerError
+	return ErrCodeDatabaseError
 }
 
-// sqliteErrCodeToHTTPCode converts SQLite Error to HTTP code
-func sqliteErrCodeToHTTPCode(code int) int {
-	switch code {
-	case SQLiteConstraintNotNULL:
-		return http.StatusBadRequest
-	case SQLiteConstraintPrimaryKey, SQLiteConstraintUnique:
-		return http.StatusConflict
+// myErrCode converts MySQL error number to stable error code
+func myErrCode(code int) string {
+	if errCode, ok := myErrCodes[code]; ok {
+		return errCode
 	}
+	return ErrCodeDatabaseError
+}
 
-	return http.StatusInternalServerError
+// mssqlErrCode converts SQL Server error number to stable error code
+func mssqlErrCode(code int32, msg string) string {
+	if code == MSSQLErrConstraint {
+		switch {
+		case strings.Contains(msg, "REFERENCE constraint"):
+			return ErrCodeRowReferenced
+		case strings.Contains(msg, "FOREIGN KEY constraint"):
+			return ErrCodeForeignKeyViolation
+		}
+	}
+	if errCode, ok := mssqlErrCodes[code]; ok {
+		return errCode
+	}
+	return ErrCodeDatabaseError
 }
 
-// myErrCodeToHTTPCode converts MySQL Error to HTTP code
-func myErrCodeToHTTPCode(code int) int {
-	switch code { //nolint:gocritic
-	case MYErrNoDefaultForField:
-		return http.StatusBadRequest
+// sqliteErrCode converts SQLite extended result code to stable error code
+func sqliteErrCode(code int, msg string) string {
+	if errCode, ok := sqliteErrCodes[code]; ok {
+		return errCode
+	}
+	if errCode, ok := sqliteErrCodes[code&0xff]; ok {
+		return errCode
+	}
+	if code&0xff == SQLiteError {
+		for _, m := range sqliteErrMessages {
+			if m.re.MatchString(msg) {
+				return m.errCode
+			}
+		}
 	}
-	return http.StatusInternalServerError
+	return ErrCodeDatabaseError
 }
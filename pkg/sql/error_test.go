@@ -1,11 +1,15 @@
 package sql
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"testing"
 
 	"github.com/go-sql-driver/mysql"
-	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v5/pgconn"
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/stretchr/testify/assert"
 	"modernc.org/sqlite"
 )
@@ -16,19 +20,260 @@ func TestNewError(t *testing.T) {
 }
 
 func TestErrorPG(t *testing.T) {
-	err := &pgconn.PgError{}
-	dbErr := convertError("hint", err)
-	assert.Equal(t, http.StatusInternalServerError, dbErr.Code)
+	for _, test := range []struct {
+		err     *pgconn.PgError
+		code    int
+		errCode string
+	}{
+		{&pgconn.PgError{}, http.StatusInternalServerError, ErrCodeDatabaseError},
+		{&pgconn.PgError{Code: PGUniqueViolation}, http.StatusConflict, ErrCodeUniqueViolation},
+		{
+			&pgconn.PgError{Code: PGForeignKeyViolation, Message: `insert or update on table "orders" violates foreign key constraint`},
+			http.StatusUnprocessableEntity, ErrCodeForeignKeyViolation,
+		},
+		{
+			&pgconn.PgError{Code: PGForeignKeyViolation, Message: `update or delete on table "users" violates foreign key constraint`},
+			http.StatusConflict, ErrCodeRowReferenced,
+		},
+		{&pgconn.PgError{Code: PGNotNullViolation}, http.StatusUnprocessableEntity, ErrCodeNotNullViolation},
+		{&pgconn.PgError{Code: PGCheckViolation}, http.StatusUnprocessableEntity, ErrCodeCheckViolation},
+		{&pgconn.PgError{Code: PGExclusionViolation}, http.StatusConflict, ErrCodeConstraintViolation},
+		{&pgconn.PgError{Code: PGSyntaxErrorStatement}, http.StatusBadRequest, ErrCodeSyntaxError},
+		{&pgconn.PgError{Code: PGUndefinedColumn}, http.StatusBadRequest, ErrCodeUndefinedColumn},
+		{&pgconn.PgError{Code: "42P10"}, http.StatusBadRequest, ErrCodeSyntaxError},
+		{&pgconn.PgError{Code: PGInvalidTextRepresentation}, http.StatusBadRequest, ErrCodeInvalidValue},
+		{&pgconn.PgError{Code: PGStringDataRightTruncation}, http.StatusUnprocessableEntity, ErrCodeValueTooLong},
+		{&pgconn.PgError{Code: PGInsufficientPrivilege}, http.StatusForbidden, ErrCodePermissionDenied},
+		{&pgconn.PgError{Code: PGLockNotAvailable}, http.StatusServiceUnavailable, ErrCodeLockTimeout},
+		{&pgconn.PgError{Code: PGSerializationFailure}, http.StatusConflict, ErrCodeSerializationFailure},
+		{&pgconn.PgError{Code: PGDeadlockDetected}, http.StatusConflict, ErrCodeDeadlockDetected},
+		{&pgconn.PgError{Code: "57P01"}, http.StatusServiceUnavailable, ErrCodeUnavailable},
+	} {
+		dbErr := convertError("hint", test.err)
+		assert.Equal(t, test.code, dbErr.Code, test.err.Code)
+		assert.Equal(t, test.errCode, dbErr.ErrCode, test.err.Code)
+	}
 }
 
 func TestErrorMy(t *testing.T) {
-	err := &mysql.MySQLError{}
-	dbErr := convertError("hint", err)
-	assert.Equal(t, http.StatusInternalServerError, dbErr.Code)
+	for _, test := range []struct {
+		number  uint16
+		code    int
+		errCode string
+	}{
+		{0, http.StatusInternalServerError, ErrCodeDatabaseError},
+		{MYErrDupEntry, http.StatusConflict, ErrCodeUniqueViolation},
+		{MYErrNoReferencedRow, http.StatusUnprocessableEntity, ErrCodeForeignKeyViolation},
+		{MYErrRowIsReferenced, http.StatusConflict, ErrCodeRowReferenced},
+		{MYErrBadNull, http.StatusUnprocessableEntity, ErrCodeNotNullViolation},
+		{MYErrNoDefaultForField, http.StatusUnprocessableEntity, ErrCodeNotNullViolation},
+		{MYErrCheckViolated, http.StatusUnprocessableEntity, ErrCodeCheckViolation},
+		{MYErrParse, http.StatusBadRequest, ErrCodeSyntaxError},
+		{MYErrNoSuchTable, http.StatusBadRequest, ErrCodeUndefinedTable},
+		{MYErrDataTooLong, http.StatusUnprocessableEntity, ErrCodeValueTooLong},
+		{MYErrTableAccessDenied, http.StatusForbidden, ErrCodePermissionDenied},
+		{MYErrLockWaitTimeout, http.StatusServiceUnavailable, ErrCodeLockTimeout},
+		{MYErrLockDeadlock, http.StatusConflict, ErrCodeDeadlockDetected},
+		{MYErrTooManyConnections, http.StatusServiceUnavailable, ErrCodeUnavailable},
+	} {
+		dbErr := convertError("hint", &mysql.MySQLError{Number: test.number})
+		assert.Equal(t, test.code, dbErr.Code, test.number)
+		assert.Equal(t, test.errCode, dbErr.ErrCode, test.number)
+	}
 }
 
 func TestErrorSQLite(t *testing.T) {
-	err := &sqlite.Error{}
-	dbErr := convertError("hint", err)
+	dbErr := convertError("hint", &sqlite.Error{})
 	assert.Equal(t, http.StatusInternalServerError, dbErr.Code)
+
+	for _, test := range []struct {
+		code    int
+		msg     string
+		errCode string
+	}{
+		{SQLiteConstraintUnique, "UNIQUE constraint failed: users.email", ErrCodeUniqueViolation},
+		{SQLiteConstraintForeignKey, "FOREIGN KEY constraint failed", ErrCodeForeignKeyViolation},
+		{SQLiteConstraint, "constraint failed", ErrCodeConstraintViolation},
+		{SQLiteBusy, "database is locked", ErrCodeLockTimeout},
+		{SQLiteBusySnapshot, "database is locked", ErrCodeSerializationFailure},
+		{SQLiteError, "no such column: nme", ErrCodeUndefinedColumn},
+		{SQLiteError, "no such table: userss", ErrCodeUndefinedTable},
+		{SQLiteError, `near "SELEC": syntax error`, ErrCodeSyntaxError},
+		{SQLiteError, "unknown", ErrCodeDatabaseError},
+	} {
+		assert.Equal(t, test.errCode, sqliteErrCode(test.code, test.msg), test.msg)
+	}
+}
+
+func TestErrorTimeout(t *testing.T) {
+	for _, err := range []error{
+		context.DeadlineExceeded,
+		fmt.Errorf("timeout: %w", context.DeadlineExceeded),
+		&pgconn.PgError{Code: PGQueryCanceled},
+		&mysql.MySQLError{Number: MYErrQueryTimeout},
+	} {
+		dbErr := convertError("hint", err)
+		assert.Equal(t, http.StatusGatewayTimeout, dbErr.Code, err)
+	}
+}
+
+func TestErrorMSSQL(t *testing.T) {
+	for _, test := range []struct {
+		number  int32
+		msg     string
+		code    int
+		errCode string
+	}{
+		{MSSQLErrUniqueConstraint, "", http.StatusConflict, ErrCodeUniqueViolation},
+		{MSSQLErrUniqueIndex, "", http.StatusConflict, ErrCodeUniqueViolation},
+		{MSSQLErrNotNULL, "", http.StatusUnprocessableEntity, ErrCodeNotNullViolation},
+		{
+			MSSQLErrConstraint, `The INSERT statement conflicted with the CHECK constraint "CK_age".`,
+			http.StatusUnprocessableEntity, ErrCodeCheckViolation,
+		},
+		{
+			MSSQLErrConstraint, `The INSERT statement conflicted with the FOREIGN KEY constraint "FK_user".`,
+			http.StatusUnprocessableEntity, ErrCodeForeignKeyViolation,
+		},
+		{
+			MSSQLErrConstraint, `The DELETE statement conflicted with the REFERENCE constraint "FK_user".`,
+			http.StatusConflict, ErrCodeRowReferenced,
+		},
+		{MSSQLErrInvalidColumn, "", http.StatusBadRequest, ErrCodeUndefinedColumn},
+		{MSSQLErrPermissionDenied, "", http.StatusForbidden, ErrCodePermissionDenied},
+		{MSSQLErrLockTimeout, "", http.StatusServiceUnavailable, ErrCodeLockTimeout},
+		{MSSQLErrDeadlock, "", http.StatusConflict, ErrCodeDeadlockDetected},
+		{MSSQLErrSnapshotConflict, "", http.StatusConflict, ErrCodeSerializationFailure},
+		{0, "", http.StatusInternalServerError, ErrCodeDatabaseError},
+	} {
+		dbErr := convertError("hint", mssql.Error{Number: test.number, Message: test.msg})
+		assert.Equal(t, test.code, dbErr.Code, test.number)
+		assert.Equal(t, test.errCode, dbErr.ErrCode, test.number)
+	}
+}
+
+func TestErrorRetryable(t *testing.T) {
+	for errCode, status := range errorStatuses {
+		dbErr := newError(errCode, ErrorDetails{})
+		assert.Equal(t, status.code, dbErr.Code)
+		assert.Equal(t, status.retryable, dbErr.Retryable)
+	}
+	assert.True(t, convertError("hint", &pgconn.PgError{Code: PGSerializationFailure}).Retryable)
+	assert.False(t, convertError("hint", &pgconn.PgError{Code: PGUniqueViolation}).Retryable)
+}
+
+func TestErrorDetails(t *testing.T) {
+	for _, test := range []struct {
+		err     error
+		errCode string
+		details ErrorDetails
+	}{
+		{
+			err: &pgconn.PgError{
+				Code: PGUniqueViolation, TableName: "users", ConstraintName: "users_pkey",
+			},
+			errCode: ErrCodeUniqueViolation,
+			details: ErrorDetails{SQLState: PGUniqueViolation, Table: "users", Constraint: "users_pkey"},
+		},
+		{
+			err:     &pgconn.PgError{Code: PGUndefinedColumn, Hint: "Perhaps you meant to reference the column"},
+			errCode: ErrCodeUndefinedColumn,
+			details: ErrorDetails{SQLState: PGUndefinedColumn, Hint: "Perhaps you meant to reference the column"},
+		},
+		{
+			err: &mysql.MySQLError{
+				Number: MYErrDupEntry, SQLState: [5]byte{'2', '3', '0', '0', '0'},
+				Message: "Duplicate entry '1' for key 'users.PRIMARY'",
+			},
+			errCode: ErrCodeUniqueViolation,
+			details: ErrorDetails{SQLState: "23000", DriverCode: MYErrDupEntry, Constraint: "users.PRIMARY"},
+		},
+		{
+			err:     &mysql.MySQLError{Number: MYErrBadNull, Message: "Column 'name' cannot be null"},
+			errCode: ErrCodeNotNullViolation,
+			details: ErrorDetails{SQLState: "\x00\x00\x00\x00\x00", DriverCode: MYErrBadNull, Column: "name"},
+		},
+		{
+			err: mssql.Error{
+				Number:  MSSQLErrUniqueConstraint,
+				Message: "Violation of PRIMARY KEY constraint 'PK_users'. Cannot insert duplicate key in object 'dbo.users'.",
+			},
+			errCode: ErrCodeUniqueViolation,
+			details: ErrorDetails{DriverCode: MSSQLErrUniqueConstraint, Constraint: "PK_users"},
+		},
+		{
+			err: mssql.Error{
+				Number:  MSSQLErrConstraint,
+				Message: `The INSERT statement conflicted with the FOREIGN KEY constraint "FK_orders_users".`,
+			},
+			errCode: ErrCodeForeignKeyViolation,
+			details: ErrorDetails{DriverCode: MSSQLErrConstraint, Constraint: "FK_orders_users"},
+		},
+		{
+			err:     mssql.Error{Number: MSSQLErrInvalidColumn, Message: "Invalid column name 'nme'."},
+			errCode: ErrCodeUndefinedColumn,
+			details: ErrorDetails{DriverCode: MSSQLErrInvalidColumn, Column: "nme"},
+		},
+		{
+			err:     context.DeadlineExceeded,
+			errCode: ErrCodeStatementTimeout,
+		},
+		{
+			err:     errors.New("unknown"),
+			errCode: ErrCodeDatabaseError,
+		},
+	} {
+		dbErr := convertError("hint", test.err)
+		assert.Equal(t, test.errCode, dbErr.ErrCode, test.err)
+		assert.Equal(t, test.details, dbErr.ErrorDetails, test.err)
+	}
+}
+
+func TestErrorSQLiteDetails(t *testing.T) {
+	db, err := Open("sqlite://ci.db")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	_, err = db.ExecQuery(ctx, `DROP TABLE IF EXISTS error_details`)
+	assert.Nil(t, err)
+	_, err = db.ExecQuery(ctx, `CREATE TABLE error_details (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		age INTEGER CONSTRAINT positive_age CHECK (age > 0)
+	)`)
+	assert.Nil(t, err)
+	defer db.ExecQuery(ctx, `DROP TABLE error_details`) //nolint:errcheck
+
+	_, err = db.ExecQuery(ctx, `INSERT INTO error_details (id, name) VALUES (1, 'a')`)
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		query   string
+		errCode string
+		details ErrorDetails
+	}{
+		{
+			query:   `INSERT INTO error_details (id, name) VALUES (1, 'b')`,
+			errCode: ErrCodeUniqueViolation,
+			details: ErrorDetails{DriverCode: SQLiteConstraintPrimaryKey, Table: "error_details", Column: "id"},
+		},
+		{
+			query:   `INSERT INTO error_details (id) VALUES (2)`,
+			errCode: ErrCodeNotNullViolation,
+			details: ErrorDetails{DriverCode: SQLiteConstraintNotNULL, Table: "error_details", Column: "name"},
+		},
+		{
+			query:   `INSERT INTO error_details (id, name, age) VALUES (3, 'c', -1)`,
+			errCode: ErrCodeCheckViolation,
+			details: ErrorDetails{DriverCode: SQLiteConstraintCheck, Constraint: "positive_age"},
+		},
+	} {
+		_, err := db.ExecQuery(ctx, test.query)
+		var dbErr Error
+		assert.True(t, errors.As(err, &dbErr), test.query)
+		assert.Equal(t, test.errCode, dbErr.ErrCode, test.query)
+		assert.Equal(t, test.details, dbErr.ErrorDetails, test.query)
+		assert.Equal(t, "failed to exec sql", dbErr.SafeMsg())
+	}
 }
@@ -0,0 +1,60 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rest-go/rest/pkg/log"
+)
+
+// Explain runs EXPLAIN against the query and returns the plan, the query is
+// actually executed with analyze, so it runs in a transaction which is always
+// rolled back to avoid any changes in database. The statement timeout and role
+// in ctx are applied like other queries
+func (db *DB) Explain(ctx context.Context, query string, analyze bool, args ...any) (any, error) {
+	explainQuery, err := db.dialect.Explain(query, analyze)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("explain query, query: %v, args: %v", explainQuery, args)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, convertError("failed to begin transaction", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+	settings, explainQuery := db.settings(ctx, explainQuery)
+	if err := db.apply(ctx, tx, settings); err != nil {
+		return nil, err
+	}
+
+	objects, err := fetchData(ctx, tx, Rebind(db.dialect, explainQuery), args...)
+	if err != nil {
+		return nil, err
+	}
+	// plans of one row and column are JSON or text, e.g. SQLite returns a
+	// row for each step instead
+	if len(objects) != 1 || len(objects[0]) != 1 {
+		return objects, nil
+	}
+
+	// the plan is in the only column of the only row
+	var rawPlan string
+	for _, v := range objects[0] {
+		rawPlan = fmt.Sprint(v)
+	}
+	var plan any
+	if err := json.Unmarshal([]byte(rawPlan), &plan); err != nil {
+		// plan in text format, e.g. MySQL EXPLAIN ANALYZE
+		return rawPlan, nil //nolint:nilerr
+	}
+	return plan, nil
+}
+
+// QueryCost returns the total cost of a query estimated by the query planner,
+// the plan is fetched with the settings in ctx as well
+func (db *DB) QueryCost(ctx context.Context, query string, args ...any) (float64, error) {
+	return db.dialect.QueryCost(query, db.planFunc(ctx, args))
+}
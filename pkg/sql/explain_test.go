@@ -0,0 +1,46 @@
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBExplain(t *testing.T) {
+	db, err := setupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	plan, err := db.Explain(ctx, "SELECT * FROM customers WHERE Id = ?", false, 1)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, plan)
+
+	_, err = db.Explain(ctx, "SELECT * FROM customers", true)
+	assert.NotNil(t, err)
+
+	_, err = db.QueryCost(ctx, "SELECT * FROM customers")
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	t.Run("role", func(t *testing.T) {
+		ctx, err := WithRole(ctx, "anon", nil)
+		assert.Nil(t, err)
+		db.dialect = roleDialect{}
+		defer func() { db.dialect = SQLiteDialect{} }()
+		_, err = db.Explain(ctx, "SELECT * FROM customers", false)
+		assert.ErrorContains(t, err, "failed to apply settings")
+	})
+}
+
+// roleDialect sets roles by a statement which SQLite doesn't support
+type roleDialect struct {
+	SQLiteDialect
+}
+
+func (roleDialect) SetRole(name, claims string) []Statement {
+	return []Statement{{Query: "SET LOCAL ROLE " + name}}
+}
@@ -3,17 +3,21 @@ package sql
 import (
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/microsoft/go-mssqldb"
 	_ "modernc.org/sqlite"
 )
 
 type Helper interface {
 	GetTablesSQL() string
 	GetColumnsSQL(string) string
-}
-
-var helpers = map[string]Helper{
-	"postgres": PGHelper{},
-	"pgx":      PGHelper{},
-	"mysql":    MyHelper{},
-	"sqlite":   SQLiteHelper{},
+	// GetEstimatedCountSQL returns a query to get the estimated rows of a
+	// table, empty if it's not supported
+	GetEstimatedCountSQL() string
+	// GetRoutinesSQL returns a query to get all the functions and procedures
+	// with columns: specific_name, name, type, return_type, returns_set,
+	// returns_row, empty if it's not supported
+	GetRoutinesSQL() string
+	// GetParamsSQL returns a query to get all the parameters of routines with
+	// columns: specific_name, name, data_type, mode
+	GetParamsSQL() string
 }
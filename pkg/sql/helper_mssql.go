@@ -0,0 +1,50 @@
+package sql
+
+import "fmt"
+
+type MSSQLHelper struct{}
+
+func (h MSSQLHelper) GetTablesSQL() string {
+	return `
+	SELECT TABLE_NAME AS name
+	FROM INFORMATION_SCHEMA.TABLES
+	WHERE (TABLE_TYPE = 'BASE TABLE' OR TABLE_TYPE = 'VIEW') AND TABLE_SCHEMA = SCHEMA_NAME();
+	`
+}
+
+func (h MSSQLHelper) GetColumnsSQL(tableName string) string {
+	return fmt.Sprintf(`
+	SELECT
+		c.COLUMN_NAME AS column_name,
+		c.DATA_TYPE AS data_type,
+		CAST(CASE WHEN c.IS_NULLABLE = 'NO' THEN 1 ELSE 0 END AS BIT) AS notnull,
+		CAST(CASE WHEN k.COLUMN_NAME IS NULL THEN 0 ELSE 1 END AS BIT) AS pk
+	FROM INFORMATION_SCHEMA.COLUMNS c
+	LEFT JOIN (
+		SELECT ku.TABLE_SCHEMA, ku.TABLE_NAME, ku.COLUMN_NAME
+		FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc
+		JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE ku
+			ON tc.CONSTRAINT_SCHEMA = ku.CONSTRAINT_SCHEMA AND tc.CONSTRAINT_NAME = ku.CONSTRAINT_NAME
+		WHERE tc.CONSTRAINT_TYPE = 'PRIMARY KEY'
+	) k ON c.TABLE_SCHEMA = k.TABLE_SCHEMA AND c.TABLE_NAME = k.TABLE_NAME AND c.COLUMN_NAME = k.COLUMN_NAME
+	WHERE c.TABLE_SCHEMA = SCHEMA_NAME() AND c.TABLE_NAME = '%s'
+	ORDER BY c.ORDINAL_POSITION;
+	`, tableName)
+}
+
+func (h MSSQLHelper) GetEstimatedCountSQL() string {
+	return `
+	SELECT SUM(p.rows) AS count
+	FROM sys.partitions p
+	WHERE p.object_id = OBJECT_ID(?) AND p.index_id IN (0, 1);
+	`
+}
+
+// GetRoutinesSQL returns empty as routines are not supported for SQL Server
+func (h MSSQLHelper) GetRoutinesSQL() string {
+	return ""
+}
+
+func (h MSSQLHelper) GetParamsSQL() string {
+	return ""
+}
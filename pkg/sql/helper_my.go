@@ -23,3 +23,39 @@ func (h MyHelper) GetColumnsSQL(tableName string) string {
 	WHERE table_schema = DATABASE() AND table_name = '%s';
 	`, tableName)
 }
+
+func (h MyHelper) GetEstimatedCountSQL() string {
+	return `
+	SELECT TABLE_ROWS AS count
+	FROM information_schema.TABLES
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?;
+	`
+}
+
+func (h MyHelper) GetRoutinesSQL() string {
+	return `
+	SELECT
+		SPECIFIC_NAME AS specific_name,
+		ROUTINE_NAME AS name,
+		ROUTINE_TYPE AS type,
+		DATA_TYPE AS return_type,
+		ROUTINE_TYPE = 'PROCEDURE' AS returns_set,
+		false AS returns_row
+	FROM information_schema.ROUTINES
+	WHERE ROUTINE_SCHEMA = DATABASE()
+	ORDER BY SPECIFIC_NAME;
+	`
+}
+
+func (h MyHelper) GetParamsSQL() string {
+	return `
+	SELECT
+		SPECIFIC_NAME AS specific_name,
+		PARAMETER_NAME AS name,
+		DATA_TYPE AS data_type,
+		PARAMETER_MODE AS mode
+	FROM information_schema.PARAMETERS
+	WHERE SPECIFIC_SCHEMA = DATABASE() AND ORDINAL_POSITION > 0
+	ORDER BY SPECIFIC_NAME, ORDINAL_POSITION;
+	`
+}
@@ -41,3 +41,43 @@ func (h PGHelper) GetColumnsSQL(tableName string) string {
 	ORDER BY c.ordinal_position;
 	`, tableName)
 }
+
+func (h PGHelper) GetEstimatedCountSQL() string {
+	return `SELECT reltuples::bigint AS count FROM pg_class WHERE oid = to_regclass(?)`
+}
+
+func (h PGHelper) GetRoutinesSQL() string {
+	return `
+	SELECT
+		r.specific_name::text,
+		r.routine_name::text AS name,
+		r.routine_type::text AS type,
+		r.data_type::text AS return_type,
+		p.proretset AS returns_set,
+		(t.typtype = 'c' OR p.prorettype = 'record'::regtype) AS returns_row
+	FROM information_schema.routines r
+	JOIN pg_catalog.pg_proc p
+	ON
+		p.oid = substring(r.specific_name from '_([0-9]+)$')::oid
+	JOIN pg_catalog.pg_type t
+	ON
+		t.oid = p.prorettype
+	WHERE
+		r.specific_schema = current_schema() AND
+		r.routine_type IN ('FUNCTION', 'PROCEDURE')
+	ORDER BY r.specific_name
+	`
+}
+
+func (h PGHelper) GetParamsSQL() string {
+	return `
+	SELECT
+		specific_name::text,
+		parameter_name::text AS name,
+		data_type::text,
+		parameter_mode::text AS mode
+	FROM information_schema.parameters
+	WHERE specific_schema = current_schema()
+	ORDER BY specific_name, ordinal_position
+	`
+}
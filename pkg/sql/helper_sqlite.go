@@ -26,3 +26,17 @@ func (h SQLiteHelper) GetColumnsSQL(tableName string) string {
 		FROM PRAGMA_TABLE_INFO('%s')
 	`, tableName)
 }
+
+func (h SQLiteHelper) GetEstimatedCountSQL() string {
+	// sqlite doesn't maintain row statistics of tables
+	return ""
+}
+
+func (h SQLiteHelper) GetRoutinesSQL() string {
+	// sqlite doesn't support stored routines
+	return ""
+}
+
+func (h SQLiteHelper) GetParamsSQL() string {
+	return ""
+}
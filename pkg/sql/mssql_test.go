@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver is a database/sql driver which records queries and returns
+// canned rows, it's used to test drivers without a database server
+type fakeDriver struct {
+	mu      sync.Mutex
+	queries []string
+	args    [][]driver.Value
+	// results are the rows returned for queries containing the key
+	results map[string]*fakeRows
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+func (d *fakeDriver) query(query string, args []driver.Value) driver.Rows {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = append(d.queries, query)
+	d.args = append(d.args, args)
+	for key, rows := range d.results {
+		if strings.Contains(query, key) {
+			return &fakeRows{columns: rows.columns, values: rows.values}
+		}
+	}
+	return &fakeRows{}
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c.d, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *fakeConn) Commit() error                             { return nil }
+func (c *fakeConn) Rollback() error                           { return nil }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.query(s.query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.d.query(s.query, args), nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) { return &fakeConn{d}, nil }
+func (d *fakeDriver) Driver() driver.Driver                        { return d }
+
+func openFakeDB(driverName string, d *fakeDriver) *DB {
+	return &DB{DB: stdSQL.OpenDB(d), DriverName: driverName, dialect: mustDialect(driverName)}
+}
+
+func TestMSSQLFetchData(t *testing.T) {
+	d := &fakeDriver{results: map[string]*fakeRows{
+		"FROM customers": {
+			columns: []string{"id", "name"},
+			values:  [][]driver.Value{{int64(1), "a"}},
+		},
+	}}
+	db := openFakeDB("sqlserver", d)
+	defer db.Close()
+
+	objects, err := db.FetchData(context.Background(), "SELECT * FROM customers WHERE id = ? AND name = ?", 1, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, "SELECT * FROM customers WHERE id = @p1 AND name = @p2", d.queries[0])
+	assert.Equal(t, []driver.Value{int64(1), "a"}, d.args[0])
+}
+
+func TestMSSQLFetchTables(t *testing.T) {
+	d := &fakeDriver{results: map[string]*fakeRows{
+		"INFORMATION_SCHEMA.TABLES": {
+			columns: []string{"name"},
+			values:  [][]driver.Value{{"customers"}},
+		},
+		"INFORMATION_SCHEMA.COLUMNS": {
+			columns: []string{"column_name", "data_type", "notnull", "pk"},
+			values: [][]driver.Value{
+				{"id", "int", true, true},
+				{"name", "nvarchar", false, false},
+			},
+		},
+	}}
+	db := openFakeDB("sqlserver", d)
+	defer db.Close()
+
+	tables := db.FetchTables()
+	assert.Equal(t, 1, len(tables))
+	table := tables["customers"]
+	assert.Equal(t, "id", table.PrimaryKey)
+	assert.Equal(t, 2, len(table.Columns))
+	assert.Contains(t, d.queries[1], "c.TABLE_NAME = 'customers'")
+}
+
+func TestMSSQLEstimatedCount(t *testing.T) {
+	d := &fakeDriver{results: map[string]*fakeRows{
+		"sys.partitions": {
+			columns: []string{"count"},
+			values:  [][]driver.Value{{int64(42)}},
+		},
+	}}
+	db := openFakeDB("sqlserver", d)
+	defer db.Close()
+
+	count, err := db.EstimatedCount(context.Background(), "customers")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), count)
+	assert.Contains(t, d.queries[0], "OBJECT_ID(@p1)")
+
+	_, err = db.PlannedCount(context.Background(), "SELECT 1 FROM customers")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,46 @@
+package sql
+
+import (
+	"regexp"
+	"strings"
+)
+
+var namedParamExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// CompileNamedQuery converts a query with named parameters to a query with
+// `?` placeholders and returns names of the parameters in order, e.g.
+// `SELECT * FROM t WHERE a = :a AND b = :b` => `SELECT * FROM t WHERE a = ? AND b = ?`, [a, b]
+// type casts like `::text` and quoted strings are kept as they are
+func CompileNamedQuery(query string) (string, []string) {
+	var (
+		queryBuilder strings.Builder
+		names        []string
+		quote        byte
+	)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			// type cast
+			queryBuilder.WriteString("::")
+			i++
+			continue
+		case c == ':':
+			name := namedParamExp.FindString(query[i+1:])
+			if name != "" {
+				names = append(names, name)
+				queryBuilder.WriteByte('?')
+				i += len(name)
+				continue
+			}
+		}
+		queryBuilder.WriteByte(c)
+	}
+	return queryBuilder.String(), names
+}
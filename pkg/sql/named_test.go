@@ -0,0 +1,39 @@
+package sql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileNamedQuery(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		compiled string
+		names    []string
+	}{
+		{
+			query:    "SELECT * FROM customers",
+			compiled: "SELECT * FROM customers",
+		},
+		{
+			query:    "SELECT * FROM customers WHERE region = :region AND total > :min_total",
+			compiled: "SELECT * FROM customers WHERE region = ? AND total > ?",
+			names:    []string{"region", "min_total"},
+		},
+		{
+			query:    "SELECT total::text FROM t WHERE a = :a OR b = :a",
+			compiled: "SELECT total::text FROM t WHERE a = ? OR b = ?",
+			names:    []string{"a", "a"},
+		},
+		{
+			query:    "SELECT ':not_param' AS a, \"b:c\" FROM t WHERE d=:d",
+			compiled: "SELECT ':not_param' AS a, \"b:c\" FROM t WHERE d=?",
+			names:    []string{"d"},
+		},
+	} {
+		compiled, names := CompileNamedQuery(test.query)
+		assert.Equal(t, test.compiled, compiled)
+		assert.Equal(t, test.names, names)
+	}
+}
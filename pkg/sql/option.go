@@ -0,0 +1,84 @@
+package sql
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Option configures the driver when opening a database
+type Option func(*Options)
+
+// Options are driver settings passed to Dialect.Open
+type Options struct {
+	Pragmas [][2]string       // SQLite pragmas in order
+	Params  map[string]string // PG runtime parameters
+}
+
+// Pragma sets a SQLite pragma on every connection, e.g.
+// Pragma("journal_mode", "WAL"), it's ignored by other drivers
+func Pragma(name, value string) Option {
+	return func(o *Options) {
+		o.Pragmas = append(o.Pragmas, [2]string{name, value})
+	}
+}
+
+// RuntimeParam sets a PG run-time parameter on every connection, e.g.
+// RuntimeParam("application_name", "rest"), it's ignored by other drivers
+func RuntimeParam(name, value string) Option {
+	return func(o *Options) {
+		if o.Params == nil {
+			o.Params = map[string]string{}
+		}
+		o.Params[name] = value
+	}
+}
+
+// sqliteDSN appends pragmas to a SQLite dsn, e.g.
+// `my.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)`
+func sqliteDSN(dsn string, o *Options) string {
+	pragmas := o.Pragmas
+	// increase busy timeout to avoid database lock in concurrent goroutine
+	// https://www.sqlite.org/c3ref/busy_timeout.html
+	// https://github.com/mattn/go-sqlite3/issues/274#issuecomment-232942571
+	if !strings.Contains(dsn, "busy_timeout") && !hasPragma(pragmas, "busy_timeout") {
+		pragmas = append([][2]string{{"busy_timeout", "5000"}}, pragmas...)
+	}
+	for _, pragma := range pragmas {
+		sep := "&"
+		if !strings.Contains(dsn, "?") {
+			sep = "?"
+		}
+		dsn += fmt.Sprintf("%s_pragma=%s", sep, url.QueryEscape(fmt.Sprintf("%s(%s)", pragma[0], pragma[1])))
+	}
+	return dsn
+}
+
+func hasPragma(pragmas [][2]string, name string) bool {
+	for _, pragma := range pragmas {
+		if strings.EqualFold(pragma[0], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pgDSN sets run-time parameters in the query of a PG url, parameters which
+// are already in the url take precedence
+func pgDSN(dsn string, o *Options) (string, error) {
+	if len(o.Params) == 0 {
+		return dsn, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid db url: %v", err)
+	}
+	query := u.Query()
+	for name, value := range o.Params {
+		if !query.Has(name) {
+			query.Set(name, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
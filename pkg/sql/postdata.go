@@ -144,6 +144,11 @@ func (pd *PostData) Set(column string, val any) {
 	}
 }
 
+// Objects returns the objects to write
+func (pd *PostData) Objects() []map[string]any {
+	return pd.objects
+}
+
 func identKeys(m map[string]any, keys []string) bool {
 	if len(m) != len(keys) {
 		return false
@@ -0,0 +1,41 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+type roleKey struct{}
+
+// role is the database role and JWT claims of a request
+type role struct {
+	name   string
+	claims string
+}
+
+var roleExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$-]*$`)
+
+// WithRole returns a context running queries as the role on PG, `SET LOCAL
+// ROLE` and `set_config('request.jwt.claims', claims, true)` are run in the
+// transaction of each query so that row-level security policies apply, e.g.
+//
+//	CREATE POLICY owner ON todos
+//	USING (user_id = (current_setting('request.jwt.claims', true)::json->>'user_id')::bigint)
+//
+// Dialects without Dialect.SetRole ignore the role
+func WithRole(ctx context.Context, name string, claims map[string]any) (context.Context, error) {
+	if !roleExp.MatchString(name) {
+		return ctx, NewError(http.StatusBadRequest, fmt.Sprintf("invalid role: %s", name))
+	}
+	if claims == nil {
+		claims = map[string]any{}
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return ctx, NewError(http.StatusBadRequest, fmt.Sprintf("invalid claims: %v", err))
+	}
+	return context.WithValue(ctx, roleKey{}, &role{name, string(data)}), nil
+}
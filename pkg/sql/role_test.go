@@ -0,0 +1,33 @@
+package sql
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRole(t *testing.T) {
+	for _, name := range []string{"", "anon; DROP TABLE customers", `a"b`, "1st"} {
+		_, err := WithRole(context.Background(), name, nil)
+		assert.NotNil(t, err, name)
+		assert.Equal(t, http.StatusBadRequest, err.(Error).Code)
+	}
+
+	ctx, err := WithRole(context.Background(), "authenticated", map[string]any{"user_id": 1})
+	assert.Nil(t, err)
+	r := ctx.Value(roleKey{}).(*role)
+	assert.Equal(t, "authenticated", r.name)
+	assert.Equal(t, `{"user_id":1}`, r.claims)
+
+	// the role is ignored by SQLite
+	db, err := setupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	objects, err := db.FetchData(ctx, "SELECT * FROM customers")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(objects))
+}
@@ -0,0 +1,188 @@
+package sql
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rest-go/rest/pkg/log"
+)
+
+// Routine types
+const (
+	RoutineFunction  = "FUNCTION"
+	RoutineProcedure = "PROCEDURE"
+)
+
+// Param represents an input parameter of a routine
+type Param struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+	Mode     string `json:"mode"` // IN, OUT or INOUT
+}
+
+// Routine represents a stored function or procedure in database
+type Routine struct {
+	Name       string
+	Type       string // FUNCTION or PROCEDURE
+	ReturnType string
+	ReturnsSet bool // returns a set of rows or scalars
+	ReturnsRow bool // returns a composite type
+	Params     []*Param
+}
+
+func (r *Routine) String() string {
+	params := make([]string, 0, len(r.Params))
+	for _, p := range r.Params {
+		params = append(params, fmt.Sprintf("%s %s %s", p.Mode, p.Name, p.DataType))
+	}
+	return fmt.Sprintf("%s %s(%s) %s", r.Type, r.Name, strings.Join(params, ", "), r.ReturnType)
+}
+
+// HasParam returns whether the routine accepts an input parameter
+func (r *Routine) HasParam(name string) bool {
+	for _, p := range r.Params {
+		if p.Name == name && p.Mode != "OUT" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUnnamedParam returns whether the routine has an input parameter without
+// a name, which can't be passed by the named arguments of a call
+func (r *Routine) hasUnnamedParam() bool {
+	for _, p := range r.Params {
+		if p.Name == "" && p.Mode != "OUT" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsVoid returns whether the routine is a function returns nothing
+func (r *Routine) IsVoid() bool {
+	return r.Type == RoutineFunction && strings.EqualFold(r.ReturnType, "void")
+}
+
+// Call returns an expression to call the routine with named arguments and the
+// args in order, e.g. `fn(a => ?, b => ?)` on PG and `fn(?, ?)` on MySQL
+func (r *Routine) Call(dialect Dialect, args map[string]any) (string, []any, error) {
+	for name := range args {
+		if !r.HasParam(name) {
+			return "", nil, NewError(http.StatusBadRequest, fmt.Sprintf("unknown argument for %s: %s", r.Name, name))
+		}
+	}
+
+	placeholders := make([]string, 0, len(r.Params))
+	values := make([]any, 0, len(r.Params))
+	for _, p := range r.Params {
+		named := dialect.NamedArg(p.Name)
+		if named == "" && r.Type == RoutineProcedure && (p.Mode == "OUT" || p.Mode == "INOUT") {
+			// OUT parameters of procedures must be variables in positional
+			// notation, e.g. `CALL proc(?, @out)` on MySQL
+			return "", nil, NewError(http.StatusBadRequest,
+				fmt.Sprintf("procedure with OUT parameters is not supported: %s", r.Name))
+		}
+		if p.Mode == "OUT" {
+			continue
+		}
+		v, ok := args[p.Name]
+		if named != "" {
+			// omitted arguments use default values in named notation
+			if ok {
+				placeholders = append(placeholders, named)
+				values = append(values, v)
+			}
+			continue
+		}
+		// positional notation doesn't support default values
+		if !ok {
+			return "", nil, NewError(http.StatusBadRequest, fmt.Sprintf("missing argument for %s: %s", r.Name, p.Name))
+		}
+		placeholders = append(placeholders, "?")
+		values = append(values, v)
+	}
+	return fmt.Sprintf("%s(%s)", r.Name, strings.Join(placeholders, ", ")), values, nil
+}
+
+// FetchRoutines return all the functions and procedures in current database
+// along with their parameters
+func (db *DB) FetchRoutines() map[string]*Routine {
+	helper := db.Dialect()
+	routinesQuery, paramsQuery := helper.GetRoutinesSQL(), helper.GetParamsSQL()
+	routines := map[string]*Routine{}
+	if routinesQuery == "" {
+		return routines
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, routinesQuery)
+	if err != nil {
+		log.Errorf("fetch routines error: %v", err)
+		return routines
+	}
+	defer rows.Close()
+
+	specificNames := map[string]*Routine{}
+	for rows.Next() {
+		var (
+			specificName string
+			returnType   stdSQL.NullString
+			routine      Routine
+		)
+		err := rows.Scan(&specificName, &routine.Name, &routine.Type, &returnType,
+			&routine.ReturnsSet, &routine.ReturnsRow)
+		if err != nil {
+			log.Errorf("scan routine error: %v", err)
+			return routines
+		}
+		if _, ok := routines[routine.Name]; ok {
+			log.Warnf("overloaded routine is not supported, skip %s", specificName)
+			continue
+		}
+		routine.ReturnType = returnType.String
+		routines[routine.Name] = &routine
+		specificNames[specificName] = &routine
+	}
+
+	if err := db.fetchParams(ctx, paramsQuery, specificNames); err != nil {
+		log.Errorf("fetch routine params error: %v", err)
+	}
+	for name, routine := range routines {
+		if routine.hasUnnamedParam() {
+			log.Warnf("routine with unnamed parameters is not supported, skip %s", name)
+			delete(routines, name)
+		}
+	}
+	return routines
+}
+
+func (db *DB) fetchParams(ctx context.Context, query string, routines map[string]*Routine) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			specificName string
+			name, mode   stdSQL.NullString
+			param        Param
+		)
+		if err := rows.Scan(&specificName, &name, &param.DataType, &mode); err != nil {
+			return err
+		}
+		routine, ok := routines[specificName]
+		if !ok {
+			continue
+		}
+		param.Name, param.Mode = name.String, mode.String
+		routine.Params = append(routine.Params, &param)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,87 @@
+package sql
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutineCall(t *testing.T) {
+	routine := &Routine{
+		Name: "add",
+		Type: RoutineFunction,
+		Params: []*Param{
+			{Name: "a", DataType: "integer", Mode: "IN"},
+			{Name: "b", DataType: "integer", Mode: "IN"},
+			{Name: "total", DataType: "integer", Mode: "OUT"},
+		},
+	}
+	assert.True(t, routine.HasParam("a"))
+	assert.False(t, routine.HasParam("total"))
+	assert.False(t, routine.IsVoid())
+
+	t.Run("postgres", func(t *testing.T) {
+		call, args, err := routine.Call(PGDialect{}, map[string]any{"b": 2})
+		assert.Nil(t, err)
+		assert.Equal(t, "add(b => ?)", call)
+		assert.Equal(t, []any{2}, args)
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		call, args, err := routine.Call(MyDialect{}, map[string]any{"b": 2, "a": 1})
+		assert.Nil(t, err)
+		assert.Equal(t, "add(?, ?)", call)
+		assert.Equal(t, []any{1, 2}, args)
+
+		_, _, err = routine.Call(MyDialect{}, map[string]any{"b": 2})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unknown argument", func(t *testing.T) {
+		_, _, err := routine.Call(PGDialect{}, map[string]any{"total": 2})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unnamed parameters", func(t *testing.T) {
+		assert.False(t, routine.hasUnnamedParam())
+		unnamed := &Routine{
+			Name: "double",
+			Type: RoutineFunction,
+			Params: []*Param{
+				{DataType: "integer", Mode: "IN"},
+				{DataType: "integer", Mode: "OUT"},
+			},
+		}
+		assert.True(t, unnamed.hasUnnamedParam())
+	})
+
+	t.Run("procedure with OUT parameters", func(t *testing.T) {
+		procedure := &Routine{
+			Name: "count_orders",
+			Type: RoutineProcedure,
+			Params: []*Param{
+				{Name: "customer_id", DataType: "int", Mode: "IN"},
+				{Name: "total", DataType: "int", Mode: "OUT"},
+			},
+		}
+		_, _, err := procedure.Call(MyDialect{}, map[string]any{"customer_id": 1})
+		var sqlErr Error
+		assert.ErrorAs(t, err, &sqlErr)
+		assert.Equal(t, http.StatusBadRequest, sqlErr.Code)
+
+		call, args, err := procedure.Call(PGDialect{}, map[string]any{"customer_id": 1})
+		assert.Nil(t, err)
+		assert.Equal(t, "count_orders(customer_id => ?)", call)
+		assert.Equal(t, []any{1}, args)
+	})
+}
+
+func TestDBFetchRoutines(t *testing.T) {
+	db, err := setupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routines := db.FetchRoutines()
+	assert.Equal(t, 0, len(routines))
+}
@@ -22,9 +22,10 @@ const (
 	Postgres DriverType = iota
 	MySQL
 	SQLite
+	SQLServer
 )
 
-// DefaultTimeout for all database operations
+// DefaultTimeout for database operations without a deadline in context
 const DefaultTimeout = 2 * time.Minute
 
 // DB is a wrapper of the golang database/sql DB struct with a DriverName to
@@ -32,39 +33,50 @@ const DefaultTimeout = 2 * time.Minute
 type DB struct {
 	*stdSQL.DB
 	DriverName string
+
+	dialect Dialect
+}
+
+// Open connects to database by specify database url and ping it, driver
+// specific settings can be set by options, e.g. SQLite pragmas
+func Open(url string, opts ...Option) (*DB, error) {
+	db, err := Connect(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping db error: %v", err)
+	}
+	return db, nil
 }
 
-// Open connects to database by specify database url and ping it
-func Open(url string) (*DB, error) {
+// Connect is Open without pinging the database, connections are established
+// on use, e.g. for replicas which may be down at the moment
+func Connect(url string, opts ...Option) (*DB, error) {
 	parts := strings.SplitN(url, "://", 2)
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid db url, no driver: %s", url)
 	}
 
-	driverName, dsn := parts[0], parts[1]
-	driver := driverName
-	if driverName == "postgres" {
-		driver = "pgx"
-		dsn = url
+	dialect, ok := GetDialect(parts[0])
+	if !ok {
+		return nil, fmt.Errorf("unsupported database: %s, supported: %v", parts[0], Dialects())
 	}
-	if driver == "sqlite" {
-		// increase busy timeout to avoid database lock in concurrent goroutine
-		// https://www.sqlite.org/c3ref/busy_timeout.html
-		// https://github.com/mattn/go-sqlite3/issues/274#issuecomment-232942571
-		if !strings.Contains(dsn, "busy_timeout") {
-			dsn += "?_pragma=busy_timeout(5000)"
-		}
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
 	}
-	db, err := stdSQL.Open(driver, dsn)
+	driver, dsn, err := dialect.Open(url, o)
 	if err != nil {
-		return nil, fmt.Errorf("open db error: %v", err)
+		return nil, err
 	}
-	err = db.Ping()
+	db, err := stdSQL.Open(driver, dsn)
 	if err != nil {
-		return nil, fmt.Errorf("ping db error: %v", err)
+		return nil, fmt.Errorf("open db error: %v", err)
 	}
-
-	return &DB{DB: db, DriverName: driverName}, nil
+	return &DB{DB: db, DriverName: parts[0], dialect: dialect}, nil
 }
 
 func (db *DB) Close() {
@@ -78,7 +90,7 @@ func (db *DB) fetchColumns(tableName string) ([]*Column, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
-	helper := helpers[db.DriverName]
+	helper := db.Dialect()
 	columnsQuery := helper.GetColumnsSQL(tableName)
 	rows, err := db.QueryContext(ctx, columnsQuery)
 	if err != nil {
@@ -113,7 +125,7 @@ func (db *DB) FetchTables() map[string]*Table {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
-	helper := helpers[db.DriverName]
+	helper := db.Dialect()
 	query := helper.GetTablesSQL()
 	rows, err := db.FetchData(ctx, query)
 	if err != nil {
@@ -134,31 +146,50 @@ func (db *DB) FetchTables() map[string]*Table {
 
 // ExecQuery execute and query in database and return rows affected or an error
 func (db *DB) ExecQuery(ctx context.Context, query string, args ...any) (int64, error) {
-	query = Rebind(db.DriverName, query)
+	query = Rebind(db.dialect, query)
 	log.Debugf("exec query, query: %v, args: %v", query, args)
-	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
-	result, err := db.ExecContext(ctx, query, args...)
-	if err != nil {
-		return 0, convertError("failed to exec sql", err)
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return 0, convertError("rows affected error", err)
-	}
-	return rows, nil
+	var rows int64
+	err := db.run(ctx, query, func(q queryer, query string) error {
+		result, err := q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return convertError("failed to exec sql", err)
+		}
+		rows, err = result.RowsAffected()
+		if err != nil {
+			return convertError("rows affected error", err)
+		}
+		return nil
+	})
+	return rows, err
 }
 
 // FetchData execute query and fetch data from database, it always return an array
 // or error
 func (db *DB) FetchData(ctx context.Context, query string, args ...any) ([]map[string]any, error) {
-	query = Rebind(db.DriverName, query)
+	query = Rebind(db.dialect, query)
 	log.Debugf("fetch data, query: %v, args: %v", query, args)
-	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
-	rows, err := db.QueryContext(ctx, query, args...)
+	var objects []map[string]any
+	err := db.run(ctx, query, func(q queryer, query string) (err error) {
+		objects, err = fetchData(ctx, q, query, args...)
+		return err
+	})
+	return objects, err
+}
+
+// queryer is implemented by both database/sql DB and Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*stdSQL.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (stdSQL.Result, error)
+}
+
+func fetchData(ctx context.Context, q queryer, query string, args ...any) ([]map[string]any, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, convertError("failed to run query", err)
 	}
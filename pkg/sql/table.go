@@ -24,6 +24,26 @@ type Table struct {
 	Columns    []*Column
 }
 
+// HasColumnFold returns whether a column exists in the table case-insensitively
+func (t *Table) HasColumnFold(name string) bool {
+	for _, c := range t.Columns {
+		if strings.EqualFold(c.ColumnName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasColumn returns whether a column exists in the table
+func (t *Table) HasColumn(name string) bool {
+	for _, c := range t.Columns {
+		if c.ColumnName == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Table) String() string {
 	var columnsBuilder strings.Builder
 	columnsBuilder.WriteString("(\n")
@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+type timeoutKey struct{}
+
+var selectExp = regexp.MustCompile(`(?i)^\s*SELECT\s`)
+
+// WithTimeout returns a context which is canceled after the timeout, the
+// timeout is also set as statement timeout on database server so that the
+// query is stopped there as well
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, timeoutKey{}, timeout)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// withDefaultTimeout applies DefaultTimeout if there is no deadline in ctx
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
+
+// run calls fn with the statement timeout in ctx set on database server by
+// the dialect, e.g. `SET LOCAL statement_timeout` in a transaction on PG and
+// the `MAX_EXECUTION_TIME` hint on MySQL, SQLite only relies on the context.
+// The role in ctx is set in the transaction as well, see WithRole
+func (db *DB) run(ctx context.Context, query string, fn func(q queryer, query string) error) error {
+	settings, query := db.settings(ctx, query)
+	if len(settings) == 0 {
+		return fn(db.DB, query)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return convertError("failed to begin transaction", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := db.apply(ctx, tx, settings); err != nil {
+		return err
+	}
+	if err := fn(tx, query); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return convertError("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// settings returns the statement timeout and role settings in ctx for the
+// query, and the query with hints of the dialect if any
+func (db *DB) settings(ctx context.Context, query string) ([]Statement, string) {
+	var settings []Statement
+	if timeout, ok := ctx.Value(timeoutKey{}).(time.Duration); ok && timeout > 0 {
+		settings, query = db.dialect.StatementTimeout(query, timeout.Milliseconds())
+	}
+	if r, ok := ctx.Value(roleKey{}).(*role); ok {
+		settings = append(settings, db.dialect.SetRole(r.name, r.claims)...)
+	}
+	return settings, query
+}
+
+// apply runs the settings of the dialect in a transaction
+func (db *DB) apply(ctx context.Context, q queryer, settings []Statement) error {
+	for _, s := range settings {
+		if _, err := q.ExecContext(ctx, Rebind(db.dialect, s.Query), s.Args...); err != nil {
+			return convertError("failed to apply settings", err)
+		}
+	}
+	return nil
+}
+
+// addMaxExecutionTime adds the MAX_EXECUTION_TIME optimizer hint to a MySQL
+// SELECT query, other statements are not supported by the hint
+func addMaxExecutionTime(query string, ms int64) string {
+	loc := selectExp.FindStringIndex(query)
+	if loc == nil {
+		return query
+	}
+	return fmt.Sprintf("%s/*+ MAX_EXECUTION_TIME(%d) */ %s", query[:loc[1]], ms, query[loc[1]:])
+}
@@ -0,0 +1,46 @@
+package sql
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddMaxExecutionTime(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		result string
+	}{
+		{"SELECT * FROM t", "SELECT /*+ MAX_EXECUTION_TIME(5000) */ * FROM t"},
+		{" select\na FROM t", " select\n/*+ MAX_EXECUTION_TIME(5000) */ a FROM t"},
+		{"SELECT COUNT(1) FROM (SELECT a FROM t) AS t", "SELECT /*+ MAX_EXECUTION_TIME(5000) */ COUNT(1) FROM (SELECT a FROM t) AS t"},
+		{"UPDATE t SET a = 1", "UPDATE t SET a = 1"},
+		{"CALL p()", "CALL p()"},
+	} {
+		assert.Equal(t, test.result, addMaxExecutionTime(test.query, 5000))
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	db, err := setupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	objects, err := db.FetchData(ctx, "SELECT * FROM customers")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(objects))
+
+	ctx, cancel = WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = db.FetchData(ctx, "SELECT * FROM customers")
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, err.(Error).Code)
+}
@@ -2,13 +2,21 @@ package sql
 
 import (
 	"database/sql"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
 )
 
 type TypeConverter func(any) any
 
+const (
+	NullsFirst = "NULLS FIRST"
+	NullsLast  = "NULLS LAST"
+)
+
 var (
 	numericRegexp = regexp.MustCompile(`^(INT|FLOAT)\d+`)
 	// Various data types
@@ -36,12 +44,17 @@ var (
 		"REAL":             func() any { return new(sql.NullFloat64) },
 		"DOUBLE":           func() any { return new(sql.NullFloat64) },
 		"DOUBLE PRECISION": func() any { return new(sql.NullFloat64) },
+		"MONEY":            func() any { return new(sql.NullFloat64) },
+		"SMALLMONEY":       func() any { return new(sql.NullFloat64) },
 
 		"BOOL":    func() any { return new(sql.NullBool) },
 		"BOOLEAN": func() any { return new(sql.NullBool) },
+		"BIT":     func() any { return new(nullBit) },
 
 		"JSON": func() any { return new(sql.NullString) },
 
+		"UNIQUEIDENTIFIER": func() any { return new([]byte) },
+
 		"CHAR":      func() any { return new(sql.NullString) },
 		"VARCHAR":   func() any { return new(sql.NullString) },
 		"NVARCHAR":  func() any { return new(sql.NullString) },
@@ -54,6 +67,13 @@ var (
 		"DATE":      func() any { return new(sql.NullString) },
 		"DATETIME":  func() any { return new(sql.NullString) },
 		"TIMESTAMP": func() any { return new(sql.NullString) },
+
+		"NCHAR":          func() any { return new(sql.NullString) },
+		"NTEXT":          func() any { return new(sql.NullString) },
+		"TIME":           func() any { return new(sql.NullString) },
+		"DATETIME2":      func() any { return new(sql.NullString) },
+		"DATETIMEOFFSET": func() any { return new(sql.NullString) },
+		"SMALLDATETIME":  func() any { return new(sql.NullString) },
 	}
 
 	TypeConverters = map[string]TypeConverter{
@@ -73,9 +93,21 @@ var (
 		"REAL":             func(i any) any { return i.(*sql.NullFloat64).Float64 },
 		"DOUBLE":           func(i any) any { return i.(*sql.NullFloat64).Float64 },
 		"DOUBLE PRECISION": func(i any) any { return i.(*sql.NullFloat64).Float64 },
+		"MONEY":            func(i any) any { return i.(*sql.NullFloat64).Float64 },
+		"SMALLMONEY":       func(i any) any { return i.(*sql.NullFloat64).Float64 },
 
 		"BOOL":    func(i any) any { return i.(*sql.NullBool).Bool },
 		"BOOLEAN": func(i any) any { return i.(*sql.NullBool).Bool },
+		"BIT":     func(i any) any { return i.(*nullBit).value },
+
+		// SQL Server returns uniqueidentifier in its own byte order
+		"UNIQUEIDENTIFIER": func(i any) any {
+			var id mssql.UniqueIdentifier
+			if err := id.Scan(*i.(*[]byte)); err != nil {
+				return ""
+			}
+			return id.String()
+		},
 
 		"CHAR":      func(i any) any { return i.(*sql.NullString).String },
 		"VARCHAR":   func(i any) any { return i.(*sql.NullString).String },
@@ -90,6 +122,13 @@ var (
 		"DATETIME":  func(i any) any { return i.(*sql.NullString).String },
 		"TIMESTAMP": func(i any) any { return i.(*sql.NullString).String },
 
+		"NCHAR":          func(i any) any { return i.(*sql.NullString).String },
+		"NTEXT":          func(i any) any { return i.(*sql.NullString).String },
+		"TIME":           func(i any) any { return i.(*sql.NullString).String },
+		"DATETIME2":      func(i any) any { return i.(*sql.NullString).String },
+		"DATETIMEOFFSET": func(i any) any { return i.(*sql.NullString).String },
+		"SMALLDATETIME":  func(i any) any { return i.(*sql.NullString).String },
+
 		"JSON": func(i any) any {
 			rawData := i.(*sql.NullString).String
 			if s, err := strconv.ParseFloat(rawData, 64); err == nil {
@@ -117,13 +156,53 @@ var (
 		"cd":    " <@ ",
 	}
 
+	OrderDirections = map[string]string{
+		"asc":  "ASC",
+		"desc": "DESC",
+	}
+
+	NullsOrders = map[string]string{
+		"nullsfirst": NullsFirst,
+		"nullslast":  NullsLast,
+	}
+
 	ReservedWords = map[string]struct{}{
-		"select": {},
-		"order":  {},
-		"count":  {},
+		"select":    {},
+		"order":     {},
+		"count":     {},
+		"explain":   {},
+		"page":      {},
+		"page_size": {},
+		"debug":     {},
+		"singular":  {},
+		"mine":      {},
 	}
 )
 
+// nullBit scans BIT columns, SQL Server returns them as bool while MySQL
+// returns bit-fields as bytes, which are kept as strings like other unknown
+// types
+type nullBit struct {
+	value any
+}
+
+// Scan implements the sql.Scanner interface, NULL is false
+func (b *nullBit) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		b.value = false
+	case bool:
+		b.value = v
+	case int64:
+		b.value = v != 0
+	case []byte:
+		b.value = string(v)
+	default:
+		return fmt.Errorf("couldn't convert %v into bit", src)
+	}
+	return nil
+}
+
 func getTypeAndConverter(t string) (any, TypeConverter) {
 	t = normalize(t)
 	if f, ok := Types[t]; ok {
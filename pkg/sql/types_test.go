@@ -51,7 +51,7 @@ func TestTypeFloat(t *testing.T) {
 }
 
 func TestTypeBool(t *testing.T) {
-	for _, typeName := range []string{"bool", "Boolean"} {
+	for _, typeName := range []string{"bool", "Boolean", "BIT"} {
 		t.Log("test bool type: ", typeName)
 		obj, converter := getTypeAndConverter(typeName)
 		err := obj.(sql.Scanner).Scan(true)
@@ -60,12 +60,23 @@ func TestTypeBool(t *testing.T) {
 		val := converter(obj)
 		assert.Equal(t, true, val.(bool))
 	}
+
+	// MySQL returns bit-fields as bytes
+	obj, converter := getTypeAndConverter("BIT")
+	err := obj.(sql.Scanner).Scan([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, "\x01", converter(obj))
+
+	err = obj.(sql.Scanner).Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, false, converter(obj))
 }
 
 func TestTypeString(t *testing.T) {
 	for _, typeName := range []string{
 		"BINARY", "BLOB", "CHAR", "CLOB", "DATE", "DATETIME", "ENUM",
 		"NVARCHAR(40)", "TEXT", "timestamp", "UUID", "VARCHAR(40)", "XML",
+		"NCHAR", "NTEXT", "DATETIME2", "DATETIMEOFFSET",
 	} {
 		t.Log("test string type: ", typeName)
 		obj, converter := getTypeAndConverter(typeName)
@@ -85,3 +96,16 @@ func TestTypeUnknown(t *testing.T) {
 	val := converter(obj)
 	assert.Equal(t, "to be or not to be, that's a question", val.(string))
 }
+
+func TestTypeUniqueIdentifier(t *testing.T) {
+	obj, converter := getTypeAndConverter("UNIQUEIDENTIFIER")
+	// SQL Server stores the first three groups in little-endian
+	*obj.(*[]byte) = []byte{
+		0x67, 0x45, 0x23, 0x01, 0xab, 0x89, 0xef, 0xcd,
+		0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+	}
+	assert.Equal(t, "01234567-89AB-CDEF-0123-456789ABCDEF", converter(obj))
+
+	obj, converter = getTypeAndConverter("UNIQUEIDENTIFIER")
+	assert.Equal(t, "", converter(obj))
+}
@@ -7,10 +7,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/rest-go/rest/pkg/log"
 )
 
+// DefaultPageSize is the page size if it's not specified in the query
+const DefaultPageSize = 100
+
 var (
 	allowedFunctions = []string{
 		// math functions
@@ -25,21 +26,33 @@ var (
 	}
 	allowedFunctionExp = regexp.MustCompile(strings.Join(allowedFunctions, "|"))
 	funcExp            = regexp.MustCompile(`(.*?)\(`)
-	invalidIdentifier  = regexp.MustCompile("[ ;'\"]")
-	jsonPathFunc       = map[string]func(column string) (jsonPath, asName string){
-		"postgres": buildPGJSONPath,
-		"mysql":    buildMysqlJSONPath,
-		"sqlite":   buildSqliteJSONPath,
-	}
+	invalidIdentifier  = regexp.MustCompile("[ ;'\"]|--|/\\*")
+	validAlias         = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// identifierExp matches identifiers with the JSON path arrow before them
+	// or the parenthesis of function after them
+	identifierExp = regexp.MustCompile(`(->>?\s*)?([A-Za-z0-9_.$]+)(\s*\()?`)
 )
 
 type URLQuery struct {
-	values url.Values
-	driver string
+	values  url.Values
+	dialect Dialect
+	// conditions are extra where clauses with args, e.g. from policies
+	conditions []condition
+}
+
+type condition struct {
+	query string
+	args  []any
+}
+
+func NewURLQuery(values url.Values, dialect Dialect) *URLQuery {
+	return &URLQuery{values: values, dialect: dialect}
 }
 
-func NewURLQuery(values url.Values, driver string) *URLQuery {
-	return &URLQuery{values, driver}
+// AddCondition adds a where clause with `?` placeholders, it's joined with
+// other conditions by AND
+func (q *URLQuery) AddCondition(query string, args ...any) {
+	q.conditions = append(q.conditions, condition{query, args})
 }
 
 func (q *URLQuery) Set(key, value string) {
@@ -61,7 +74,7 @@ func (q *URLQuery) SelectQuery() (string, error) {
 	columns := strings.Split(selectVal, ",")
 	for i, c := range columns {
 		// TODO: fail fast if there are duplicate column names
-		column, err := q.buildColumn(c, true)
+		column, err := q.buildSelectColumn(c)
 		if err != nil {
 			return "", err
 		}
@@ -70,24 +83,34 @@ func (q *URLQuery) SelectQuery() (string, error) {
 	return strings.Join(columns, ","), nil
 }
 
-// OrderQuery returns sql order query string
-func (q *URLQuery) OrderQuery() string {
+// OrderQuery returns sql order query string, e.g. `a.desc.nullslast,b.asc`,
+// columns are validated against the table if it's provided
+func (q *URLQuery) OrderQuery(table *Table) (string, error) {
 	orders := q.values["order"]
 	if len(orders) == 0 {
-		return ""
+		return "", nil
 	}
 	if invalidIdentifier.MatchString(orders[0]) {
-		log.Warn("invalid character in order: ", orders[0])
-		return ""
+		return "", fmt.Errorf("invalid character in order: %s", orders[0])
 	}
 
-	return strings.ReplaceAll(orders[0], ".", " ")
+	items := strings.Split(orders[0], ",")
+	for i, item := range items {
+		order, err := q.buildOrder(item, table)
+		if err != nil {
+			return "", err
+		}
+		items[i] = order
+	}
+	return strings.Join(items, ","), nil
 }
 
-// WhereQuery returns sql and args for where clause
-func (q *URLQuery) WhereQuery(index uint) (newIndex uint, query string, args []any) {
-	if len(q.values) == 0 {
-		return index, "", nil
+// WhereQuery returns sql and args for where clause, filters are grouped in
+// parentheses before other conditions so that they can't loosen them, columns
+// are validated against the table if it's provided
+func (q *URLQuery) WhereQuery(index uint, table *Table) (newIndex uint, query string, args []any, err error) {
+	if len(q.values) == 0 && len(q.conditions) == 0 {
+		return index, "", nil, nil
 	}
 
 	var queryBuilder strings.Builder
@@ -98,24 +121,28 @@ func (q *URLQuery) WhereQuery(index uint) (newIndex uint, query string, args []a
 			continue
 		}
 		for _, vv := range v {
-			vals := strings.Split(vv, ".")
+			// dropping a filter would widen the rows to write, so invalid
+			// ones are rejected
+			vals := strings.SplitN(vv, ".", 2)
 			if len(vals) != 2 {
-				continue
+				return index, "", nil, fmt.Errorf("invalid filter %s=%s, expect <op>.<value>", k, vv)
 			}
 			op, val := vals[0], vals[1]
 			operator, ok := Operators[op]
 			if !ok {
-				log.Warnf("unsupported op: %s", op)
-				continue
+				return index, "", nil, fmt.Errorf("unsupported op: %s", op)
 			}
 
 			if !first {
 				queryBuilder.WriteString(" AND ")
 			}
 
-			column, err := q.buildColumn(k, false)
+			if err := q.checkFilter(k, table); err != nil {
+				return index, "", nil, err
+			}
+			column, _, err := q.buildColumn(k)
 			if err != nil {
-				return index, "", nil
+				return index, "", nil, fmt.Errorf("invalid filter %s, %w", k, err)
 			}
 			queryBuilder.WriteString(column)
 			if op == "in" {
@@ -133,7 +160,7 @@ func (q *URLQuery) WhereQuery(index uint) (newIndex uint, query string, args []a
 					queryBuilder.WriteString(operator)
 					queryBuilder.WriteString(val)
 				} else {
-					log.Warnf("unsupported is value: %s", val)
+					return index, "", nil, fmt.Errorf("unsupported is value: %s", val)
 				}
 			} else {
 				queryBuilder.WriteString(operator)
@@ -146,18 +173,114 @@ func (q *URLQuery) WhereQuery(index uint) (newIndex uint, query string, args []a
 			first = false
 		}
 	}
+	if !first && len(q.conditions) > 0 {
+		query = queryBuilder.String()
+		queryBuilder.Reset()
+		queryBuilder.WriteString("(")
+		queryBuilder.WriteString(query)
+		queryBuilder.WriteString(")")
+	}
+	for _, c := range q.conditions {
+		if !first {
+			queryBuilder.WriteString(" AND ")
+		}
+		queryBuilder.WriteString("(")
+		queryBuilder.WriteString(c.query)
+		queryBuilder.WriteString(")")
+		args = append(args, c.args...)
+		index += uint(len(c.args))
+		first = false
+	}
 
-	return index, queryBuilder.String(), args
+	return index, queryBuilder.String(), args, nil
+}
+
+// checkFilter validates the column of a filter, every column in it must exist
+// in the table if it's provided, case-insensitively as unquoted identifiers
+// are
+func (q *URLQuery) checkFilter(k string, table *Table) error {
+	if invalidIdentifier.MatchString(k) {
+		return fmt.Errorf("invalid character in filter: %s", k)
+	}
+	if table == nil {
+		return nil
+	}
+	for _, column := range baseColumns(k) {
+		if !table.HasColumnFold(column) {
+			return fmt.Errorf("filter column does not exist: %s", column)
+		}
+	}
+	return nil
 }
 
+// Columns returns the columns referenced by select, filters and order of the
+// query, e.g. `title` of `select=len:length(title)` and `data` of
+// `data->>country=eq.US`
+func (q *URLQuery) Columns() []string {
+	var columns []string
+	if selects := q.values["select"]; len(selects) > 0 {
+		for _, c := range strings.Split(selects[0], ",") {
+			if i := strings.Index(c, ":"); i != -1 && !strings.HasPrefix(c[i:], "::") {
+				c = c[i+1:]
+			}
+			if i := strings.LastIndex(c, "::"); i != -1 {
+				c = c[:i]
+			}
+			columns = append(columns, baseColumns(c)...)
+		}
+	}
+	for k, v := range q.values {
+		if _, ok := ReservedWords[k]; ok {
+			continue
+		}
+		for _, vv := range v {
+			if vals := strings.SplitN(vv, ".", 2); len(vals) == 2 {
+				if _, ok := Operators[vals[0]]; ok {
+					columns = append(columns, baseColumns(k)...)
+					break
+				}
+			}
+		}
+	}
+	if orders := q.values["order"]; len(orders) > 0 {
+		for _, item := range strings.Split(orders[0], ",") {
+			columns = append(columns, baseColumns(strings.Split(item, ".")[0])...)
+		}
+	}
+	return columns
+}
+
+// baseColumns returns every identifier of an item except function names,
+// JSON path keys and numbers, e.g. `a` and `b` of `length(a->c)-b`
+func baseColumns(c string) []string {
+	if strings.TrimSpace(c) == "*" {
+		return []string{"*"}
+	}
+	var columns []string
+	for _, match := range identifierExp.FindAllStringSubmatch(c, -1) {
+		jsonKey, name, function := match[1], match[2], match[3]
+		if jsonKey != "" || function != "" || (name[0] >= '0' && name[0] <= '9') {
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// Page returns page and page size in the query, invalid values fall back to
+// the default ones
 func (q *URLQuery) Page() (page, pageSize int) {
 	page = 1
-	pageSize = 100
+	pageSize = DefaultPageSize
 	if p, ok := q.values["page"]; ok {
-		page, _ = strconv.Atoi(p[0])
+		if v, err := strconv.Atoi(p[0]); err == nil && v > 0 {
+			page = v
+		}
 	}
 	if p, ok := q.values["page_size"]; ok {
-		pageSize, _ = strconv.Atoi(p[0])
+		if v, err := strconv.Atoi(p[0]); err == nil && v > 0 {
+			pageSize = v
+		}
 	}
 	return page, pageSize
 }
@@ -167,6 +290,17 @@ func (q *URLQuery) IsDebug() bool {
 	return ok
 }
 
+func (q *URLQuery) IsExplain() bool {
+	_, ok := q.values["explain"]
+	return ok
+}
+
+// IsExplainAnalyze returns whether to explain with analyze by `explain=analyze`
+func (q *URLQuery) IsExplainAnalyze() bool {
+	explain, ok := q.values["explain"]
+	return ok && len(explain) > 0 && explain[0] == "analyze"
+}
+
 func (q *URLQuery) IsCount() bool {
 	_, ok := q.values["count"]
 	return ok
@@ -182,13 +316,86 @@ func (q *URLQuery) IsMine() bool {
 	return ok
 }
 
-func (q *URLQuery) buildColumn(c string, as bool) (string, error) {
-	columnName := c
-	asName := ""
+// buildOrder builds a single order item in format of
+// `column[.direction][.nullsfirst|.nullslast]`
+func (q *URLQuery) buildOrder(item string, table *Table) (string, error) {
+	parts := strings.Split(item, ".")
+	if len(parts) > 3 { //nolint:gomnd
+		return "", fmt.Errorf("invalid order: %s", item)
+	}
+	column := parts[0]
+	if table != nil && !table.HasColumn(strings.SplitN(column, "->", 2)[0]) {
+		return "", fmt.Errorf("order column does not exist: %s", column)
+	}
+	column, _, err := q.buildColumn(column)
+	if err != nil {
+		return "", err
+	}
+
+	direction, nulls := "", ""
+	for _, part := range parts[1:] {
+		part = strings.ToLower(part)
+		if d, ok := OrderDirections[part]; ok && direction == "" && nulls == "" {
+			direction = d
+		} else if n, ok := NullsOrders[part]; ok && nulls == "" {
+			nulls = n
+		} else {
+			return "", fmt.Errorf("invalid order direction: %s", part)
+		}
+	}
+
+	return q.dialect.Order(column, direction, nulls), nil
+}
+
+// buildSelectColumn builds a projection column, the column can be renamed
+// with `alias:column` and casted with `column::type`, e.g.
+// `customerName:first_name`, `total::text` or `total:amount::text`
+func (q *URLQuery) buildSelectColumn(c string) (string, error) {
+	alias := ""
+	if i := strings.Index(c, ":"); i != -1 && !strings.HasPrefix(c[i:], "::") {
+		alias, c = c[:i], c[i+1:]
+		if !validAlias.MatchString(alias) {
+			return "", fmt.Errorf("invalid alias: %s", alias)
+		}
+	}
+	castType := ""
+	if i := strings.LastIndex(c, "::"); i != -1 {
+		c, castType = c[:i], strings.ToLower(c[i+2:])
+	}
+
+	columnName, asName, err := q.buildColumn(c)
+	if err != nil {
+		return "", err
+	}
+
+	if castType != "" {
+		if !q.dialect.CanCast(castType) {
+			return "", fmt.Errorf("cast type not allowed: %s", castType)
+		}
+		columnName = fmt.Sprintf("CAST(%s AS %s)", columnName, castType)
+		if asName == "" {
+			// keep the original column name as output key, it's quoted to
+			// preserve the case like aliases
+			asName = q.quote(c)
+		}
+	}
+
+	if alias != "" {
+		columnName += fmt.Sprintf(" AS %s", q.quote(alias))
+	} else if asName != "" {
+		columnName += fmt.Sprintf(" AS %s", asName)
+	}
+	return columnName, nil
+}
+
+// buildColumn builds a column from JSON path or function, it returns the
+// column and a name to be used as output key if necessary
+func (q *URLQuery) buildColumn(c string) (columnName, asName string, err error) {
+	columnName = c
 
 	// JSON path
 	if strings.Contains(c, "->") {
-		columnName, asName = jsonPathFunc[q.driver](c)
+		columnName, asName = q.dialect.JSONPath(c)
 	}
 
 	// function
@@ -196,7 +403,7 @@ func (q *URLQuery) buildColumn(c string, as bool) (string, error) {
 		for _, match := range funcExp.FindAllStringSubmatch(columnName, -1) {
 			funcName := strings.ToLower(match[1])
 			if !allowedFunctionExp.MatchString(funcName) {
-				return "", errors.New("function not allowed")
+				return "", "", errors.New("function not allowed")
 			}
 			if asName == "" {
 				asName = funcName
@@ -204,69 +411,10 @@ func (q *URLQuery) buildColumn(c string, as bool) (string, error) {
 		}
 	}
 
-	if as && asName != "" {
-		columnName += fmt.Sprintf(" AS %s", asName)
-	}
-	return columnName, nil
-}
-
-func buildMysqlJSONPath(column string) (jsonPath, asName string) {
-	parts := strings.Split(column, "->")
-	columnName := parts[0]
-	parts = parts[1:]
-	for i, part := range parts {
-		part = strings.Trim(strings.Trim(strings.TrimPrefix(part, ">"), `'`), `"`)
-		isIndex := false
-		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
-			isIndex = true
-		}
-		if isIndex {
-			part = fmt.Sprintf("[%s]", part)
-		} else {
-			// use last non number filed as name
-			asName = part
-			// add dot to non number field
-			part = "." + part
-		}
-		parts[i] = part
-	}
-	jsonPath = fmt.Sprintf("%s->'$%s'", columnName, strings.Join(parts, ""))
-	return
-}
-
-func buildPGJSONPath(column string) (jsonPath, asName string) {
-	parts := strings.Split(column, "->")
-	for i, part := range parts {
-		if i == 0 {
-			// skip column name
-			continue
-		}
-		doubleArrow := false
-		if strings.HasPrefix(part, ">") {
-			doubleArrow = true
-			part = part[1:]
-		}
-		part = strings.Trim(strings.Trim(part, `'`), `"`)
-		isIndex := false
-		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
-			isIndex = true
-		}
-		if !isIndex {
-			// use last non number filed as name
-			asName = part
-			// add quote for non number field
-			part = fmt.Sprintf(`'%s'`, part)
-		}
-		if doubleArrow {
-			part = ">" + part
-		}
-		parts[i] = part
-	}
-	jsonPath = strings.Join(parts, "->")
-	return
+	return columnName, asName, nil
 }
 
-func buildSqliteJSONPath(column string) (jsonPath, asName string) {
-	// sqlite compatible with MySQL and PG
-	return buildPGJSONPath(column)
+// quote quotes an identifier so that the case of it is preserved
+func (q *URLQuery) quote(identifier string) string {
+	return q.dialect.Quote(identifier)
 }
@@ -3,6 +3,7 @@ package sql
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,18 +38,27 @@ func TestURLQueryJSON(t *testing.T) {
 			selectQuery: "object->1->'field1'->'field2'->>2 AS field2",
 			whereQuery:  "object->1->'field1'->'field2'->>2 = ?",
 		},
+		{
+			driver:   "sqlserver",
+			jsonPath: "object->1->field1->field2->>2",
+			selectQuery: "COALESCE(JSON_VALUE(object, '$[1].field1.field2[2]'), " +
+				"JSON_QUERY(object, '$[1].field1.field2[2]')) AS field2",
+			whereQuery: "COALESCE(JSON_VALUE(object, '$[1].field1.field2[2]'), " +
+				"JSON_QUERY(object, '$[1].field1.field2[2]')) = ?",
+		},
 	} {
 		t.Run(test.driver+" select", func(t *testing.T) {
 			v := url.Values{"select": []string{test.jsonPath}}
-			q := NewURLQuery(v, test.driver)
+			q := NewURLQuery(v, mustDialect(test.driver))
 			query, err := q.SelectQuery()
 			assert.Nil(t, err)
 			assert.Equal(t, test.selectQuery, query)
 		})
 		t.Run(test.driver+" where", func(t *testing.T) {
 			v := url.Values{test.jsonPath: []string{"eq.1"}}
-			q := NewURLQuery(v, test.driver)
-			index, query, args := q.WhereQuery(1)
+			q := NewURLQuery(v, mustDialect(test.driver))
+			index, query, args, err := q.WhereQuery(1, nil)
+			assert.Nil(t, err)
 			assert.Equal(t, uint(2), index)
 			assert.Equal(t, test.whereQuery, query)
 			assert.Equal(t, []any{"1"}, args)
@@ -64,20 +74,20 @@ func TestURLQuerySet(t *testing.T) {
 
 func TestURLQuerySelectQuery(t *testing.T) {
 	v := url.Values{}
-	q := NewURLQuery(v, "")
+	q := NewURLQuery(v, PGDialect{})
 	query, err := q.SelectQuery()
 	assert.Nil(t, err)
 	assert.Equal(t, "*", query)
 
 	v = url.Values{"select": []string{"a,b"}}
-	q = NewURLQuery(v, "")
+	q = NewURLQuery(v, PGDialect{})
 	query, err = q.SelectQuery()
 	assert.Nil(t, err)
 	assert.Equal(t, "a,b", query)
 
 	t.Run("allowed func", func(t *testing.T) {
 		v := url.Values{"select": []string{"MAX(a)"}}
-		q := NewURLQuery(v, "")
+		q := NewURLQuery(v, PGDialect{})
 		query, err := q.SelectQuery()
 		assert.Nil(t, err)
 		assert.Equal(t, "MAX(a) AS max", query)
@@ -85,54 +95,236 @@ func TestURLQuerySelectQuery(t *testing.T) {
 
 	t.Run("not allowed func", func(t *testing.T) {
 		v := url.Values{"select": []string{"setting(a)"}}
-		q := NewURLQuery(v, "")
+		q := NewURLQuery(v, PGDialect{})
 		query, err := q.SelectQuery()
 		assert.NotNil(t, err)
 		assert.Equal(t, "", query)
 	})
 }
 
+func TestURLQuerySelectAliasAndCast(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		driver      string
+		selectVal   string
+		selectQuery string
+		hasErr      bool
+	}{
+		{
+			name:        "alias",
+			driver:      "postgres",
+			selectVal:   "customerName:first_name,id",
+			selectQuery: `first_name AS "customerName",id`,
+		},
+		{
+			name:        "alias mysql",
+			driver:      "mysql",
+			selectVal:   "customerName:first_name",
+			selectQuery: "first_name AS `customerName`",
+		},
+		{
+			name:        "cast",
+			driver:      "postgres",
+			selectVal:   "total::text",
+			selectQuery: `CAST(total AS text) AS "total"`,
+		},
+		{
+			name:        "cast keeps the case of the column",
+			driver:      "mysql",
+			selectVal:   "createdAt::char",
+			selectQuery: "CAST(createdAt AS char) AS `createdAt`",
+		},
+		{
+			name:        "alias and cast",
+			driver:      "sqlite",
+			selectVal:   "amount:total::TEXT",
+			selectQuery: `CAST(total AS text) AS "amount"`,
+		},
+		{
+			name:        "alias function",
+			driver:      "sqlite",
+			selectVal:   "maxTotal:max(total)",
+			selectQuery: `max(total) AS "maxTotal"`,
+		},
+		{
+			name:        "cast JSON path",
+			driver:      "postgres",
+			selectVal:   "data->>a::int",
+			selectQuery: "CAST(data->>'a' AS int) AS a",
+		},
+		{
+			name:      "cast type not allowed",
+			driver:    "mysql",
+			selectVal: "total::text",
+			hasErr:    true,
+		},
+		{
+			name:      "invalid alias",
+			driver:    "postgres",
+			selectVal: "1a:total",
+			hasErr:    true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			v := url.Values{"select": []string{test.selectVal}}
+			q := NewURLQuery(v, mustDialect(test.driver))
+			query, err := q.SelectQuery()
+			if test.hasErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.selectQuery, query)
+		})
+	}
+}
+
 func TestURLQueryOrderQuery(t *testing.T) {
+	table := &Table{
+		Name: "t",
+		Columns: []*Column{
+			{ColumnName: "a"}, {ColumnName: "b"}, {ColumnName: "data"},
+		},
+	}
+
 	v := url.Values{}
-	q := NewURLQuery(v, "")
-	query := q.OrderQuery()
+	q := NewURLQuery(v, PGDialect{})
+	query, err := q.OrderQuery(table)
+	assert.Nil(t, err)
 	assert.Equal(t, "", query)
 
-	v = url.Values{"order": []string{"a.desc,b.asc"}}
-	q = NewURLQuery(v, "")
-	query = q.OrderQuery()
-	assert.Equal(t, "a desc,b asc", query)
-
-	v = url.Values{"order": []string{"a.desc,b.asc;xxx"}}
-	q = NewURLQuery(v, "")
-	query = q.OrderQuery()
-	assert.Equal(t, "", query)
+	for _, test := range []struct {
+		name   string
+		driver string
+		order  string
+		query  string
+		hasErr bool
+	}{
+		{
+			name:   "multiple columns",
+			driver: "sqlite",
+			order:  "a.desc,b.asc",
+			query:  "a DESC,b ASC",
+		},
+		{
+			name:   "without direction",
+			driver: "sqlite",
+			order:  "a,b",
+			query:  "a,b",
+		},
+		{
+			name:   "nulls order",
+			driver: "postgres",
+			order:  "a.desc.nullslast,b.nullsfirst",
+			query:  "a DESC NULLS LAST,b NULLS FIRST",
+		},
+		{
+			name:   "nulls order emulated on mysql",
+			driver: "mysql",
+			order:  "a.desc.nullslast,b.asc.nullsfirst",
+			query:  "a IS NULL ASC,a DESC,b IS NULL DESC,b ASC",
+		},
+		{
+			name:   "nulls order emulated on sqlserver",
+			driver: "sqlserver",
+			order:  "a.desc.nullslast,b.nullsfirst",
+			query:  "CASE WHEN a IS NULL THEN 1 ELSE 0 END,a DESC,CASE WHEN b IS NULL THEN 0 ELSE 1 END,b",
+		},
+		{
+			name:   "JSON path",
+			driver: "postgres",
+			order:  "data->>a.desc",
+			query:  "data->>'a' DESC",
+		},
+		{
+			name:   "invalid character",
+			driver: "sqlite",
+			order:  "a.desc,b.asc;xxx",
+			hasErr: true,
+		},
+		{
+			name:   "invalid direction",
+			driver: "sqlite",
+			order:  "a.down",
+			hasErr: true,
+		},
+		{
+			name:   "nulls before direction",
+			driver: "sqlite",
+			order:  "a.nullslast.desc",
+			hasErr: true,
+		},
+		{
+			name:   "column not exist",
+			driver: "sqlite",
+			order:  "c.desc",
+			hasErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			v := url.Values{"order": []string{test.order}}
+			q := NewURLQuery(v, mustDialect(test.driver))
+			query, err := q.OrderQuery(table)
+			if test.hasErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.query, query)
+		})
+	}
 }
 
 // WhereQuery returns sql and args for where clause
 func TestURLQueryWhereQuery(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		v := url.Values{}
-		q := NewURLQuery(v, "sqlite")
-		index, query, args := q.WhereQuery(1)
+		q := NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(1), index)
 		assert.Equal(t, "", query)
 		assert.Equal(t, 0, len(args))
 	})
 
-	t.Run("skip no op query", func(t *testing.T) {
-		v := url.Values{"select": []string{"*"}, "count": []string{""}, "noop": []string{"noop.1"}, "invalid_val": []string{"a.b.c=1"}}
-		q := NewURLQuery(v, "sqlite")
-		index, query, args := q.WhereQuery(1)
+	t.Run("skip reserved words", func(t *testing.T) {
+		v := url.Values{"select": []string{"*"}, "count": []string{""}, "page": []string{"2"}, "mine": []string{""}}
+		q := NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(1), index)
 		assert.Equal(t, "", query)
 		assert.Equal(t, 0, len(args))
 	})
 
+	t.Run("invalid filters are rejected", func(t *testing.T) {
+		for _, v := range []url.Values{
+			{"noop": []string{"noop.1"}},
+			{"title": []string{"xx.1"}},
+			{"title": []string{"hello"}},
+			{"n": []string{"is.maybe"}},
+		} {
+			q := NewURLQuery(v, SQLiteDialect{})
+			q.AddCondition("owner_id = ?", 1)
+			_, query, _, err := q.WhereQuery(1, nil)
+			assert.NotNil(t, err, v)
+			assert.Equal(t, "", query)
+		}
+	})
+
+	t.Run("values with dots", func(t *testing.T) {
+		q := NewURLQuery(url.Values{"email": []string{"eq.a@example.com"}}, SQLiteDialect{})
+		_, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "email = ?", query)
+		assert.Equal(t, []any{"a@example.com"}, args)
+	})
+
 	t.Run("skip invalid character", func(t *testing.T) {
 		v := url.Values{"select": []string{"a;xxx"}}
-		q := NewURLQuery(v, "sqlite")
-		index, query, args := q.WhereQuery(1)
+		q := NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(1), index)
 		assert.Equal(t, "", query)
 		assert.Equal(t, 0, len(args))
@@ -144,30 +336,34 @@ func TestURLQueryWhereQuery(t *testing.T) {
 				continue
 			}
 			v := url.Values{"a": []string{fmt.Sprintf("%s.1", op)}}
-			q := NewURLQuery(v, "sqlite")
-			index, query, args := q.WhereQuery(1)
+			q := NewURLQuery(v, SQLiteDialect{})
+			index, query, args, err := q.WhereQuery(1, nil)
+			assert.Nil(t, err)
 			assert.Equal(t, uint(2), index)
 			assert.Equal(t, fmt.Sprintf("a%s?", operator), query)
 			assert.Equal(t, 1, len(args))
 		}
 
 		v := url.Values{"a": []string{"in.(1,2)"}}
-		q := NewURLQuery(v, "sqlite")
-		index, query, args := q.WhereQuery(1)
+		q := NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(3), index)
 		assert.Equal(t, "a IN (?,?)", query)
 		assert.Equal(t, 2, len(args))
 
 		v = url.Values{"a": []string{"is.null"}}
-		q = NewURLQuery(v, "sqlite")
-		index, query, args = q.WhereQuery(1)
+		q = NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err = q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(1), index)
 		assert.Equal(t, "a is null", query)
 		assert.Equal(t, 0, len(args))
 
 		v = url.Values{"a": []string{"gt.1", "lt.100"}}
-		q = NewURLQuery(v, "sqlite")
-		index, query, args = q.WhereQuery(1)
+		q = NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err = q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(3), index)
 		assert.Equal(t, "a > ? AND a < ?", query)
 		assert.Equal(t, 2, len(args))
@@ -175,64 +371,155 @@ func TestURLQueryWhereQuery(t *testing.T) {
 
 	t.Run("AND", func(t *testing.T) {
 		v := url.Values{"a": []string{"eq.1"}, "b": []string{"eq.2"}}
-		q := NewURLQuery(v, "sqlite")
-		index, query, args := q.WhereQuery(1)
+		q := NewURLQuery(v, SQLiteDialect{})
+		index, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
 		assert.Equal(t, uint(3), index)
 		assert.Contains(t, query, " AND ")
 		assert.Equal(t, 2, len(args))
 	})
+
+	t.Run("conditions", func(t *testing.T) {
+		q := NewURLQuery(url.Values{}, SQLiteDialect{})
+		q.AddCondition("org_id = ? OR public = ?", 1, true)
+		index, query, args, err := q.WhereQuery(1, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(3), index)
+		assert.Equal(t, "(org_id = ? OR public = ?)", query)
+		assert.Equal(t, []any{1, true}, args)
+
+		q = NewURLQuery(url.Values{"a": []string{"eq.1"}}, SQLiteDialect{})
+		q.AddCondition("b IS NULL")
+		q.AddCondition("c = ?", 2)
+		index, query, args, err = q.WhereQuery(1, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(3), index)
+		assert.Equal(t, "(a = ?) AND (b IS NULL) AND (c = ?)", query)
+		assert.Equal(t, []any{"1", 2}, args)
+	})
+
+	t.Run("filters can't loosen conditions", func(t *testing.T) {
+		table := &Table{Name: "todos", Columns: []*Column{{ColumnName: "id"}, {ColumnName: "owner_id"}}}
+		for _, key := range []string{"id=1 OR 1", "id/**/OR/**/1", "id--", "id=1OR(1)", "unknown"} {
+			q := NewURLQuery(url.Values{key: []string{"eq.1"}}, SQLiteDialect{})
+			q.AddCondition("owner_id = ?", 1)
+			_, _, _, err := q.WhereQuery(1, table)
+			assert.NotNil(t, err, key)
+		}
+
+		q := NewURLQuery(url.Values{"id": []string{"gt.1", "lt.10"}, "abs(id)-owner_id": []string{"eq.1"}}, SQLiteDialect{})
+		q.AddCondition("owner_id = ? OR public", 1)
+		_, query, _, err := q.WhereQuery(1, table)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(query, "("), query)
+		assert.True(t, strings.HasSuffix(query, ") AND (owner_id = ? OR public)"), query)
+	})
+
+	t.Run("function not allowed", func(t *testing.T) {
+		q := NewURLQuery(url.Values{"pg_sleep(1)": []string{"eq.1"}}, SQLiteDialect{})
+		q.AddCondition("owner_id = ?", 1)
+		_, query, args, err := q.WhereQuery(1, nil)
+		assert.NotNil(t, err)
+		assert.Equal(t, "", query)
+		assert.Nil(t, args)
+	})
+}
+
+func TestURLQueryColumns(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		columns []string
+	}{
+		{"", nil},
+		{"select=*", []string{"*"}},
+		{"select=id,name:first_name,total::text,len:length(title),count(*)", []string{"id", "first_name", "total", "title"}},
+		{"select=data->>country,length(code)", []string{"data", "code"}},
+		{"credit_limit=gt.100&page=2&mine&count", []string{"credit_limit"}},
+		{"order=score.desc,data->a.asc", []string{"score", "data"}},
+		{"select=abs(id)-salary,coalesce(a,b)+c::text", []string{"id", "salary", "a", "b", "c"}},
+		{"abs(id)-salary=gt.5", []string{"id", "salary"}},
+		{"select=round(data->>price,2),users.password", []string{"data", "users.password"}},
+	} {
+		values, err := url.ParseQuery(test.query)
+		assert.Nil(t, err)
+		q := NewURLQuery(values, SQLiteDialect{})
+		assert.Equal(t, test.columns, q.Columns(), test.query)
+	}
 }
 
 func TestURLQueryPage(t *testing.T) {
 	v := url.Values{}
-	q := NewURLQuery(v, "")
+	q := NewURLQuery(v, PGDialect{})
 	page, pageSize := q.Page()
 	assert.Equal(t, 1, page)
 	assert.Equal(t, 100, pageSize)
 
 	v = url.Values{"page": []string{"2"}, "page_size": []string{"20"}}
-	q = NewURLQuery(v, "")
+	q = NewURLQuery(v, PGDialect{})
 	page, pageSize = q.Page()
 	assert.Equal(t, 2, page)
 	assert.Equal(t, 20, pageSize)
+
+	v = url.Values{"page": []string{"0"}, "page_size": []string{"x"}}
+	q = NewURLQuery(v, PGDialect{})
+	page, pageSize = q.Page()
+	assert.Equal(t, 1, page)
+	assert.Equal(t, 100, pageSize)
 }
 
 func TestURLQueryIsDebug(t *testing.T) {
 	v := url.Values{}
-	q := NewURLQuery(v, "")
+	q := NewURLQuery(v, PGDialect{})
 	assert.False(t, q.IsDebug())
 
 	v = url.Values{"debug": []string{"1"}}
-	q = NewURLQuery(v, "")
+	q = NewURLQuery(v, PGDialect{})
 	assert.True(t, q.IsDebug())
 }
 
 func TestURLQueryIsCount(t *testing.T) {
 	v := url.Values{}
-	q := NewURLQuery(v, "")
+	q := NewURLQuery(v, PGDialect{})
 	assert.False(t, q.IsCount())
 
 	v = url.Values{"count": []string{"1"}}
-	q = NewURLQuery(v, "")
+	q = NewURLQuery(v, PGDialect{})
 	assert.True(t, q.IsCount())
 }
 
 func TestURLQueryIsSingular(t *testing.T) {
 	v := url.Values{}
-	q := NewURLQuery(v, "")
+	q := NewURLQuery(v, PGDialect{})
 	assert.False(t, q.IsSingular())
 
 	v = url.Values{"singular": []string{"1"}}
-	q = NewURLQuery(v, "")
+	q = NewURLQuery(v, PGDialect{})
 	assert.True(t, q.IsSingular())
 }
 
 func TestURLQueryIsMine(t *testing.T) {
 	v := url.Values{}
-	q := NewURLQuery(v, "")
+	q := NewURLQuery(v, PGDialect{})
 	assert.False(t, q.IsMine())
 
 	v = url.Values{"mine": []string{"1"}}
-	q = NewURLQuery(v, "")
+	q = NewURLQuery(v, PGDialect{})
 	assert.True(t, q.IsMine())
 }
+
+func TestURLQueryIsExplain(t *testing.T) {
+	v := url.Values{}
+	q := NewURLQuery(v, PGDialect{})
+	assert.False(t, q.IsExplain())
+	assert.False(t, q.IsExplainAnalyze())
+
+	v = url.Values{"explain": []string{""}}
+	q = NewURLQuery(v, PGDialect{})
+	assert.True(t, q.IsExplain())
+	assert.False(t, q.IsExplainAnalyze())
+
+	v = url.Values{"explain": []string{"analyze"}}
+	q = NewURLQuery(v, PGDialect{})
+	assert.True(t, q.IsExplain())
+	assert.True(t, q.IsExplainAnalyze())
+}